@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// newTestConfig returns a config with a new, migrated database in a
+// temporary directory.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return &apiConfig{
+		db:         db,
+		jwtSecret:  "test-secret",
+		loginGuard: newLoginGuard(),
+	}
+}
+
+// newTestUser creates a user with a unique email.
+func newTestUser(t *testing.T, cfg *apiConfig) database.User {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: uuid.NewString() + "@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return *user
+}
+
+// serveAs calls handler with a request authenticated as user, the given path
+// values and body, and returns the response.
+func serveAs(t *testing.T, cfg *apiConfig, handler http.HandlerFunc, user database.User, method string, pathValues map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	r := httptest.NewRequest(method, "/", bytes.NewBufferString(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	for k, v := range pathValues {
+		r.SetPathValue(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
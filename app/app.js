@@ -49,6 +49,29 @@ async function createVideoDraft() {
   }
 }
 
+async function loginTOTP(challengeToken) {
+  const code = prompt('Enter the code from your authenticator app (or a recovery code):');
+  if (!code) {
+    throw new Error('Two-factor authentication code is required');
+  }
+  const body = code.includes('-')
+    ? { challenge_token: challengeToken, recovery_code: code }
+    : { challenge_token: challengeToken, code };
+
+  const res = await fetch('/api/login/totp', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to login: ${data.error}`);
+  }
+  return data;
+}
+
 async function login() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
@@ -61,11 +84,15 @@ async function login() {
       },
       body: JSON.stringify({ email, password }),
     });
-    const data = await res.json();
+    let data = await res.json();
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
 
+    if (data.totp_required) {
+      data = await loginTOTP(data.challenge_token);
+    }
+
     if (data.token) {
       localStorage.setItem('token', data.token);
       document.getElementById('auth-section').style.display = 'none';
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditLogin           = "login"
+	auditLoginFailed     = "login_failed"
+	auditRefresh         = "token_refresh"
+	auditRevoke          = "token_revoke"
+	auditVideoUpload     = "video_upload"
+	auditThumbnailUpload = "thumbnail_upload"
+	auditVideoDelete     = "video_delete"
+	auditDatabaseReset   = "database_reset"
+	auditPasswordChange  = "password_change"
+	auditPasswordReset   = "password_reset"
+	auditUserRoleChange  = "user_role_change"
+	auditUserDisable     = "user_disable"
+	auditUserEnable      = "user_enable"
+	auditUserDelete      = "user_delete"
+)
+
+// audit appends event to the audit log, filling in the client IP. Errors are
+// only logged so that a failing audit log doesn't break the action itself.
+func (cfg *apiConfig) audit(r *http.Request, event database.AuditEvent) {
+	event.IP = cfg.clientIP(r)
+	if err := cfg.db.CreateAuditEvent(event); err != nil {
+		log.Printf("Couldn't record audit event %s: %s", event.Action, err)
+	}
+}
+
+const (
+	defaultAuditEventsLimit = 100
+	maxAuditEventsLimit     = 1000
+)
+
+// parseAuditEventFilter reads the filters shared by the audit log endpoints:
+// action, actor_id, target_id, since and until (RFC 3339), before_id and
+// limit.
+func parseAuditEventFilter(r *http.Request) (database.AuditEventFilter, error) {
+	q := r.URL.Query()
+	filter := database.AuditEventFilter{
+		Action:   q.Get("action"),
+		TargetID: q.Get("target_id"),
+	}
+	if v := q.Get("actor_id"); v != "" {
+		actorID, err := uuid.Parse(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid actor_id: %w", err)
+		}
+		filter.ActorID = &actorID
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since: %w", err)
+		}
+		filter.Since = &since
+	}
+	if v := q.Get("until"); v != "" {
+		until, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid until: %w", err)
+		}
+		filter.Until = &until
+	}
+	if v := q.Get("before_id"); v != "" {
+		beforeID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid before_id: %w", err)
+		}
+		filter.BeforeID = beforeID
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// handlerAdminAuditEvents returns a page of audit events, newest first. Pass
+// the ID of the last event as before_id to get the next page.
+func (cfg *apiConfig) handlerAdminAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requirePermission(w, r, permViewAuditLog); !ok {
+		return
+	}
+
+	filter, err := parseAuditEventFilter(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAuditEventsLimit
+	}
+	filter.Limit = min(filter.Limit, maxAuditEventsLimit)
+
+	events, err := cfg.db.GetAuditEvents(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve audit events", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+// handlerAdminAuditEventsExport streams every matching audit event as JSON
+// Lines, newest first.
+func (cfg *apiConfig) handlerAdminAuditEventsExport(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requirePermission(w, r, permViewAuditLog); !ok {
+		return
+	}
+
+	filter, err := parseAuditEventFilter(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tubely-audit-%s.jsonl"`, time.Now().UTC().Format("2006-01-02")))
+	encoder := json.NewEncoder(w)
+	err = cfg.db.EachAuditEvent(filter, func(event database.AuditEvent) error {
+		return encoder.Encode(event)
+	})
+	if err != nil {
+		// the status is already sent, so all we can do is cut the export short
+		log.Printf("Couldn't export audit events: %s", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestAdminAuditEvents(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := newTestUser(t, cfg)
+	admin := newTestUser(t, cfg)
+	if err := cfg.db.SetUserRole(admin.ID, database.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	for _, action := range []string{auditLogin, auditLoginFailed, auditLogin} {
+		if err := cfg.db.CreateAuditEvent(database.AuditEvent{Action: action, ActorID: &user.ID, TargetType: "user", TargetID: user.ID.String()}); err != nil {
+			t.Fatalf("CreateAuditEvent: %v", err)
+		}
+	}
+
+	get := func(handler http.HandlerFunc, as database.User, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		r.Header.Set("Authorization", "Bearer "+mustMakeJWT(t, cfg, as))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name       string
+		as         database.User
+		query      string
+		wantStatus int
+		wantCount  int
+	}{
+		{name: "not an admin", as: user, wantStatus: http.StatusForbidden},
+		{name: "all", as: admin, wantStatus: http.StatusOK, wantCount: 3},
+		{name: "by action", as: admin, query: "action=login", wantStatus: http.StatusOK, wantCount: 2},
+		{name: "by actor", as: admin, query: "actor_id=" + admin.ID.String(), wantStatus: http.StatusOK, wantCount: 0},
+		{name: "limited", as: admin, query: "limit=1", wantStatus: http.StatusOK, wantCount: 1},
+		{name: "invalid actor", as: admin, query: "actor_id=me", wantStatus: http.StatusBadRequest},
+		{name: "invalid since", as: admin, query: "since=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "invalid limit", as: admin, query: "limit=0", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(cfg.handlerAdminAuditEvents, tt.as, tt.query)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var events []database.AuditEvent
+			if err := json.NewDecoder(w.Body).Decode(&events); err != nil {
+				t.Fatalf("decoding events: %v", err)
+			}
+			if len(events) != tt.wantCount {
+				t.Errorf("got %d events, want %d", len(events), tt.wantCount)
+			}
+		})
+	}
+
+	t.Run("export", func(t *testing.T) {
+		if w := get(cfg.handlerAdminAuditEventsExport, user, ""); w.Code != http.StatusForbidden {
+			t.Errorf("export by a user: status = %d, want %d", w.Code, http.StatusForbidden)
+		}
+		w := get(cfg.handlerAdminAuditEventsExport, admin, "action=login")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/jsonl" {
+			t.Errorf("Content-Type = %q, want application/jsonl", got)
+		}
+		var events []database.AuditEvent
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var event database.AuditEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Fatalf("line %d: %v", len(events)+1, err)
+			}
+			events = append(events, event)
+		}
+		if len(events) != 2 {
+			t.Fatalf("exported %d events, want 2", len(events))
+		}
+		for _, event := range events {
+			if event.Action != auditLogin || event.ActorID == nil || *event.ActorID != user.ID {
+				t.Errorf("exported %+v, want a login by %s", event, user.ID)
+			}
+		}
+		if events[0].ID < events[1].ID {
+			t.Errorf("exported event %d before %d, want newest first", events[0].ID, events[1].ID)
+		}
+	})
+}
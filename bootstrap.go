@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runCommand runs a one-off maintenance command instead of the HTTP server.
+//
+//	go run -tags sqlite_fts5 . create-admin -email admin@tubely.com -password password
+func runCommand(db database.Client, args []string) error {
+	switch args[0] {
+	case "create-admin":
+		return createAdmin(db, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// createAdmin creates an admin user, or promotes the user with the given email
+// to admin if it already exists.
+func createAdmin(db database.Client, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the admin user")
+	password := flags.String("password", "", "password of the admin user (ignored if the user already exists)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return fmt.Errorf("-email is required")
+	}
+
+	existing, err := db.GetUserByEmail(*email)
+	if err != nil {
+		return fmt.Errorf("couldn't look up user: %w", err)
+	}
+	if existing.Email != "" {
+		if err := db.SetUserRole(existing.ID, database.RoleAdmin); err != nil {
+			return fmt.Errorf("couldn't promote user: %w", err)
+		}
+		log.Printf("Promoted %s (%s) to admin", existing.Email, existing.ID)
+		return nil
+	}
+
+	if *password == "" {
+		return fmt.Errorf("-password is required to create a new user")
+	}
+	hashedPassword, err := auth.HashPassword(*password)
+	if err != nil {
+		return fmt.Errorf("couldn't hash password: %w", err)
+	}
+	user, err := db.CreateUserWithRole(database.CreateUserParams{
+		Email:    *email,
+		Password: hashedPassword,
+	}, database.RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("couldn't create user: %w", err)
+	}
+	if _, err := db.MarkUserVerified(user.ID, user.Email); err != nil {
+		return fmt.Errorf("couldn't verify user: %w", err)
+	}
+	log.Printf("Created admin %s (%s)", user.Email, user.ID)
+	return nil
+}
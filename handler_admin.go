@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAdminUsersList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requirePermission(w, r, permManageUsers); !ok {
+		return
+	}
+
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+func (cfg *apiConfig) handlerAdminUserDisable(w http.ResponseWriter, r *http.Request) {
+	cfg.setUserDisabled(w, r, true)
+}
+
+func (cfg *apiConfig) handlerAdminUserEnable(w http.ResponseWriter, r *http.Request) {
+	cfg.setUserDisabled(w, r, false)
+}
+
+func (cfg *apiConfig) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	admin, ok := cfg.requirePermission(w, r, permManageUsers)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	if userID == admin.ID {
+		respondWithError(w, http.StatusBadRequest, "You can't disable your own account", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.db.SetUserDisabled(userID, disabled)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	action := auditUserEnable
+	if disabled {
+		action = auditUserDisable
+	}
+	cfg.audit(r, database.AuditEvent{
+		Action:     action,
+		ActorID:    &admin.ID,
+		TargetType: "user",
+		TargetID:   userID.String(),
+	})
+
+	user, err = cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+func (cfg *apiConfig) handlerAdminUserSetRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role database.Role `json:"role"`
+	}
+
+	admin, ok := cfg.requirePermission(w, r, permManageUsers)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+	if userID == admin.ID && params.Role != database.RoleAdmin {
+		respondWithError(w, http.StatusBadRequest, "You can't demote your own account", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.db.SetUserRole(userID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditUserRoleChange,
+		ActorID:    &admin.ID,
+		TargetType: "user",
+		TargetID:   userID.String(),
+		Details:    map[string]string{"from": string(user.Role), "to": string(params.Role)},
+	})
+	user.Role = params.Role
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// handlerAdminUserUnlock lifts a lockout caused by failed login attempts on
+// the user's account.
+func (cfg *apiConfig) handlerAdminUserUnlock(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requirePermission(w, r, permManageUsers); !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	cfg.loginGuard.unlockAccount(user.Email)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminUserDelete(w http.ResponseWriter, r *http.Request) {
+	admin, ok := cfg.requirePermission(w, r, permManageUsers)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	if userID == admin.ID {
+		respondWithError(w, http.StatusBadRequest, "You can't delete your own account", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.deleteUserData(r.Context(), *user)
+	if errors.Is(err, errSoleOrganizationOwner) {
+		respondWithError(w, http.StatusConflict, "Transfer or delete the organizations this user owns first", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete user", err)
+		return
+	}
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditUserDelete,
+		ActorID:    &admin.ID,
+		TargetType: "user",
+		TargetID:   userID.String(),
+		Details:    map[string]string{"email": user.Email},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminVideoGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requirePermission(w, r, permViewAnyVideo); !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
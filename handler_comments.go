@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxCommentLength     = 5000
+	defaultCommentsLimit = 50
+	maxCommentsLimit     = 200
+)
+
+func validateCommentBody(body string) error {
+	if body == "" {
+		return errors.New("Comment can't be empty")
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return fmt.Errorf("Comment can be at most %d characters", maxCommentLength)
+	}
+	return nil
+}
+
+// validateCommentTimestamp checks that a timestamp anchor points into the
+// video, as far as its duration is known.
+func validateCommentTimestamp(video database.Video, seconds float64) error {
+	if seconds < 0 {
+		return errors.New("Timestamp can't be negative")
+	}
+	if video.DurationSeconds != nil && seconds > *video.DurationSeconds {
+		return errors.New("Timestamp is past the end of the video")
+	}
+	return nil
+}
+
+// commentRequest is videoRequest for the comment named by the path, which
+// must be on the video. The caller must be allowed to view the video.
+func (cfg *apiConfig) commentRequest(w http.ResponseWriter, r *http.Request) (*database.User, database.Video, database.Comment, bool) {
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return nil, database.Video{}, database.Comment{}, false
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return nil, database.Video{}, database.Comment{}, false
+	}
+
+	commentID, err := uuid.Parse(r.PathValue("commentID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid comment ID", err)
+		return nil, database.Video{}, database.Comment{}, false
+	}
+	comment, err := cfg.db.GetComment(commentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return nil, database.Video{}, database.Comment{}, false
+	}
+	if comment == nil || comment.VideoID != video.ID || comment.DeletedAt != nil {
+		respondWithError(w, http.StatusNotFound, "Comment not found", nil)
+		return nil, database.Video{}, database.Comment{}, false
+	}
+	return user, video, *comment, true
+}
+
+// handlerCommentsList returns a page of the threads on a video, newest first,
+// or with ?parent=, of the replies to a thread, oldest first. ?limit= and
+// ?cursor= page through them like GET /api/videos, with the same
+// X-Total-Count and X-Next-Cursor headers.
+func (cfg *apiConfig) handlerCommentsList(w http.ResponseWriter, r *http.Request) {
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	q := r.URL.Query()
+	params := database.CommentListParams{
+		VideoID: video.ID,
+		Limit:   defaultCommentsLimit,
+		Cursor:  q.Get("cursor"),
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit %q", v), err)
+			return
+		}
+		params.Limit = min(limit, maxCommentsLimit)
+	}
+	if v := q.Get("parent"); v != "" {
+		parentID, err := uuid.Parse(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid parent comment ID", err)
+			return
+		}
+		params.ParentID = &parentID
+	}
+
+	page, err := cfg.db.ListComments(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve comments", err)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	respondWithJSON(w, http.StatusOK, page.Comments)
+}
+
+// handlerCommentsCreate adds a comment to a video. Anyone who can view the
+// video can comment, starting a thread or, with parent_id, replying to one.
+func (cfg *apiConfig) handlerCommentsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body             string     `json:"body"`
+		ParentID         *uuid.UUID `json:"parent_id"`
+		TimestampSeconds *float64   `json:"timestamp_seconds"`
+	}
+
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Body = strings.TrimSpace(params.Body)
+	if err := validateCommentBody(params.Body); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if params.TimestampSeconds != nil {
+		if err := validateCommentTimestamp(video, *params.TimestampSeconds); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if params.ParentID != nil {
+		parent, err := cfg.db.GetComment(*params.ParentID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+			return
+		}
+		if parent == nil || parent.VideoID != video.ID || parent.DeletedAt != nil {
+			respondWithError(w, http.StatusBadRequest, "Parent comment not found", nil)
+			return
+		}
+	}
+
+	comment, err := cfg.db.CreateComment(database.CreateCommentParams{
+		VideoID:          video.ID,
+		UserID:           user.ID,
+		ParentID:         params.ParentID,
+		Body:             params.Body,
+		TimestampSeconds: params.TimestampSeconds,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create comment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, comment)
+}
+
+// handlerCommentUpdate lets the author of a comment change its body or
+// timestamp. A null timestamp_seconds removes the timestamp.
+func (cfg *apiConfig) handlerCommentUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body             *string         `json:"body"`
+		TimestampSeconds json.RawMessage `json:"timestamp_seconds"`
+	}
+
+	user, video, comment, ok := cfg.commentRequest(w, r)
+	if !ok {
+		return
+	}
+	if comment.UserID != user.ID {
+		respondWithError(w, http.StatusForbidden, "You can only edit your own comments", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Body != nil {
+		comment.Body = strings.TrimSpace(*params.Body)
+		if err := validateCommentBody(comment.Body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if params.TimestampSeconds != nil {
+		if comment.TimestampSeconds, err = decodeNullable[float64](params.TimestampSeconds); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid timestamp_seconds", err)
+			return
+		}
+		if comment.TimestampSeconds != nil {
+			if err := validateCommentTimestamp(video, *comment.TimestampSeconds); err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error(), err)
+				return
+			}
+		}
+	}
+
+	err = cfg.db.UpdateComment(comment)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update comment", err)
+		return
+	}
+
+	updated, err := cfg.db.GetComment(comment.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// handlerCommentDelete deletes a comment. Authors can delete their own
+// comments, and whoever can delete the video any comment on it.
+func (cfg *apiConfig) handlerCommentDelete(w http.ResponseWriter, r *http.Request) {
+	user, video, comment, ok := cfg.commentRequest(w, r)
+	if !ok {
+		return
+	}
+	if comment.UserID != user.ID {
+		canDelete, err := cfg.canAccessVideo(*user, video, videoActionDelete)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check permissions", err)
+			return
+		}
+		if !canDelete {
+			respondWithError(w, http.StatusForbidden, "You can't delete this comment", nil)
+			return
+		}
+	}
+
+	if err := cfg.db.DeleteComment(comment.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete comment", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerCommentsCreateReply(t *testing.T) {
+	tests := []struct {
+		name         string
+		deleteParent bool
+		otherVideo   bool
+		wantStatus   int
+	}{
+		{name: "reply", wantStatus: http.StatusCreated},
+		{name: "deleted parent", deleteParent: true, wantStatus: http.StatusBadRequest},
+		{name: "parent on another video", otherVideo: true, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			user := newTestUser(t, cfg)
+			video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "video", UserID: user.ID})
+			if err != nil {
+				t.Fatalf("CreateVideo: %v", err)
+			}
+			parentVideo := video
+			if tt.otherVideo {
+				parentVideo, err = cfg.db.CreateVideo(database.CreateVideoParams{Title: "other", UserID: user.ID})
+				if err != nil {
+					t.Fatalf("CreateVideo: %v", err)
+				}
+			}
+			parent, err := cfg.db.CreateComment(database.CreateCommentParams{VideoID: parentVideo.ID, UserID: user.ID, Body: "thread"})
+			if err != nil {
+				t.Fatalf("CreateComment: %v", err)
+			}
+			if tt.deleteParent {
+				// keeps the parent as a blanked comment, since it has a reply
+				if _, err := cfg.db.CreateComment(database.CreateCommentParams{VideoID: video.ID, UserID: user.ID, ParentID: &parent.ID, Body: "reply"}); err != nil {
+					t.Fatalf("CreateComment: %v", err)
+				}
+				if err := cfg.db.DeleteComment(parent.ID); err != nil {
+					t.Fatalf("DeleteComment: %v", err)
+				}
+			}
+
+			body := `{"body": "hello", "parent_id": "` + parent.ID.String() + `"}`
+			w := serveAs(t, cfg, cfg.handlerCommentsCreate, user, "POST", map[string]string{"videoID": video.ID.String()}, body)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestHandlerCommentUpdateTimestamp(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       *float64
+	}{
+		{name: "unchanged", body: `{"body": "edited"}`, wantStatus: http.StatusOK, want: ptr(10.0)},
+		{name: "moved", body: `{"timestamp_seconds": 20}`, wantStatus: http.StatusOK, want: ptr(20.0)},
+		{name: "removed", body: `{"timestamp_seconds": null}`, wantStatus: http.StatusOK, want: nil},
+		{name: "negative", body: `{"timestamp_seconds": -1}`, wantStatus: http.StatusBadRequest, want: ptr(10.0)},
+		{name: "not a number", body: `{"timestamp_seconds": "soon"}`, wantStatus: http.StatusBadRequest, want: ptr(10.0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			user := newTestUser(t, cfg)
+			video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "video", UserID: user.ID})
+			if err != nil {
+				t.Fatalf("CreateVideo: %v", err)
+			}
+			comment, err := cfg.db.CreateComment(database.CreateCommentParams{VideoID: video.ID, UserID: user.ID, Body: "at", TimestampSeconds: ptr(10.0)})
+			if err != nil {
+				t.Fatalf("CreateComment: %v", err)
+			}
+
+			pathValues := map[string]string{"videoID": video.ID.String(), "commentID": comment.ID.String()}
+			w := serveAs(t, cfg, cfg.handlerCommentUpdate, user, "PUT", pathValues, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			got, err := cfg.db.GetComment(comment.ID)
+			if err != nil {
+				t.Fatalf("GetComment: %v", err)
+			}
+			if (got.TimestampSeconds == nil) != (tt.want == nil) || (got.TimestampSeconds != nil && *got.TimestampSeconds != *tt.want) {
+				gotJSON, _ := json.Marshal(got.TimestampSeconds)
+				t.Errorf("timestamp = %s, want %v", gotJSON, tt.want)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
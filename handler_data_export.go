@@ -0,0 +1,437 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	// dataExportRetention is how long a finished archive is kept
+	dataExportRetention = 7 * 24 * time.Hour
+	// dataExportLinkTTL is how long a download link stays valid
+	dataExportLinkTTL = 24 * time.Hour
+)
+
+type dataExportResponse struct {
+	database.DataExport
+	DownloadURL *string `json:"download_url"`
+}
+
+// dataExportResponse adds a fresh download link to exports that are ready.
+func (cfg *apiConfig) dataExportResponse(export database.DataExport) (dataExportResponse, error) {
+	resp := dataExportResponse{DataExport: export}
+	if export.Status != database.DataExportReady {
+		return resp, nil
+	}
+	link, err := cfg.dataExportDownloadURL(export)
+	if err != nil {
+		return dataExportResponse{}, err
+	}
+	resp.DownloadURL = &link
+	return resp, nil
+}
+
+// dataExportDownloadURL returns a link that downloads the archive without
+// further authentication. Its token is a typed JWT whose subject is the
+// export ID, valid for dataExportLinkTTL or until the archive expires.
+func (cfg *apiConfig) dataExportDownloadURL(export database.DataExport) (string, error) {
+	ttl := dataExportLinkTTL
+	if export.ExpiresAt != nil {
+		ttl = min(ttl, time.Until(*export.ExpiresAt))
+	}
+	token, err := auth.MakeTypedJWT(auth.TokenTypeDataExport, export.ID, cfg.jwtSecret, ttl)
+	if err != nil {
+		return "", err
+	}
+	return cfg.publicURL + "/api/exports/" + export.ID.String() + "/download?token=" + url.QueryEscape(token), nil
+}
+
+// handlerDataExportCreate starts building an archive of everything stored
+// about the caller. The archive is built in the background; poll
+// GET /api/exports/{exportID} or wait for the email with the download link.
+func (cfg *apiConfig) handlerDataExportCreate(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	exports, err := cfg.db.GetDataExports(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get exports", err)
+		return
+	}
+	for _, export := range exports {
+		if export.Status == database.DataExportPending {
+			// one build at a time per user
+			respondWithJSON(w, http.StatusAccepted, dataExportResponse{DataExport: export})
+			return
+		}
+	}
+
+	cfg.pruneDataExports()
+
+	export, err := cfg.db.CreateDataExport(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create export", err)
+		return
+	}
+	go cfg.buildDataExport(context.Background(), export, *user)
+
+	respondWithJSON(w, http.StatusAccepted, dataExportResponse{DataExport: export})
+}
+
+func (cfg *apiConfig) handlerDataExportsList(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	exports, err := cfg.db.GetDataExports(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get exports", err)
+		return
+	}
+
+	resp := make([]dataExportResponse, 0, len(exports))
+	for _, export := range exports {
+		item, err := cfg.dataExportResponse(export)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create download link", err)
+			return
+		}
+		resp = append(resp, item)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerDataExportGet(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID", err)
+		return
+	}
+
+	export, err := cfg.db.GetDataExport(exportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export", err)
+		return
+	}
+	if export == nil || export.UserID != user.ID {
+		respondWithError(w, http.StatusNotFound, "Export not found", nil)
+		return
+	}
+
+	resp, err := cfg.dataExportResponse(*export)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create download link", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerDataExportDownload(w http.ResponseWriter, r *http.Request) {
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID", err)
+		return
+	}
+
+	tokenExportID, err := auth.ValidateTypedJWT(r.URL.Query().Get("token"), cfg.jwtSecret, auth.TokenTypeDataExport)
+	if err != nil || tokenExportID != exportID {
+		respondWithError(w, http.StatusForbidden, "Invalid or expired download link", err)
+		return
+	}
+
+	export, err := cfg.db.GetDataExport(exportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get export", err)
+		return
+	}
+	if export == nil || export.Status != database.DataExportReady || export.FilePath == nil ||
+		(export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt)) {
+		respondWithError(w, http.StatusNotFound, "Export not found", nil)
+		return
+	}
+
+	f, err := os.Open(*export.FilePath)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Export not found", err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tubely-export-%s.zip"`, export.CreatedAt.Format("2006-01-02")))
+	http.ServeContent(w, r, "", *export.CompletedAt, f)
+}
+
+// buildDataExport writes the archive for export and records the outcome. It
+// runs in the background, so errors are only logged.
+func (cfg *apiConfig) buildDataExport(ctx context.Context, export database.DataExport, user database.User) {
+	archivePath := filepath.Join(cfg.exportsRoot, export.ID.String()+".zip")
+
+	size, err := cfg.writeDataExport(ctx, archivePath, user)
+	if err != nil {
+		log.Printf("Couldn't build data export %s: %s", export.ID, err)
+		os.Remove(archivePath)
+		if err := cfg.db.FailDataExport(export.ID); err != nil {
+			log.Printf("Couldn't mark data export %s as failed: %s", export.ID, err)
+		}
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(dataExportRetention)
+	err = cfg.db.CompleteDataExport(export.ID, archivePath, size, expiresAt)
+	if err != nil {
+		log.Printf("Couldn't complete data export %s: %s", export.ID, err)
+		os.Remove(archivePath)
+		return
+	}
+
+	export.Status = database.DataExportReady
+	export.ExpiresAt = &expiresAt
+	link, err := cfg.dataExportDownloadURL(export)
+	if err != nil {
+		log.Printf("Couldn't create download link for data export %s: %s", export.ID, err)
+		return
+	}
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Your Tubely data export is ready",
+		Body: fmt.Sprintf(
+			"Your data export is ready. Open this link within %s to download it:\n\n%s\n",
+			dataExportLinkTTL, link,
+		),
+	}
+	if err := cfg.mailer.Send(msg); err != nil {
+		log.Printf("Couldn't send data export email: %s", err)
+	}
+}
+
+// writeDataExport writes a ZIP archive with the user's profile, passkeys,
+// organization memberships, webhooks, and the metadata, thumbnails and files
+// of its personal videos to archivePath, and returns its size.
+func (cfg *apiConfig) writeDataExport(ctx context.Context, archivePath string, user database.User) (int64, error) {
+	f, err := os.OpenFile(archivePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	if err := writeZipJSON(zw, "profile.json", user); err != nil {
+		return 0, err
+	}
+
+	creds, err := cfg.db.GetWebAuthnCredentials(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeZipJSON(zw, "passkeys.json", creds); err != nil {
+		return 0, err
+	}
+
+	orgs, err := cfg.db.GetUserOrganizations(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeZipJSON(zw, "organizations.json", orgs); err != nil {
+		return 0, err
+	}
+
+	webhooks, err := cfg.db.GetWebhooks(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeZipJSON(zw, "webhooks.json", webhooks); err != nil {
+		return 0, err
+	}
+
+	type exportedPlaylist struct {
+		database.Playlist
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+	playlists, err := cfg.db.GetPlaylists(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	exportedPlaylists := make([]exportedPlaylist, 0, len(playlists))
+	for _, playlist := range playlists {
+		playlistVideos, err := cfg.db.GetPlaylistVideos(playlist.ID)
+		if err != nil {
+			return 0, err
+		}
+		p := exportedPlaylist{Playlist: playlist, VideoIDs: []uuid.UUID{}}
+		for _, video := range playlistVideos {
+			p.VideoIDs = append(p.VideoIDs, video.ID)
+		}
+		exportedPlaylists = append(exportedPlaylists, p)
+	}
+	if err := writeZipJSON(zw, "playlists.json", exportedPlaylists); err != nil {
+		return 0, err
+	}
+
+	comments, err := cfg.db.GetUserComments(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeZipJSON(zw, "comments.json", comments); err != nil {
+		return 0, err
+	}
+
+	watchProgress, err := cfg.db.GetUserWatchProgress(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeZipJSON(zw, "watch_progress.json", watchProgress); err != nil {
+		return 0, err
+	}
+
+	// videos created for organizations belong to the organization, not the
+	// user
+	videos, err := cfg.db.GetPersonalVideos(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeZipJSON(zw, "videos.json", videos); err != nil {
+		return 0, err
+	}
+
+	for _, video := range videos {
+		if video.ThumbnailURL != nil {
+			if err := cfg.writeZipThumbnail(zw, video.ID, *video.ThumbnailURL); err != nil {
+				return 0, fmt.Errorf("couldn't export thumbnail of video %s: %w", video.ID, err)
+			}
+		}
+		if video.VideoURL != nil {
+			if err := cfg.writeZipVideo(ctx, zw, video.ID, *video.VideoURL); err != nil {
+				return 0, fmt.Errorf("couldn't export video %s: %w", video.ID, err)
+			}
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), f.Close()
+}
+
+// createZipFile adds a file to the archive. zip.Writer.Create would leave the
+// modification time unset.
+func createZipFile(zw *zip.Writer, name string, method uint16) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: time.Now(),
+	})
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v any) error {
+	w, err := createZipFile(zw, name, zip.Deflate)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeZipThumbnail copies a stored thumbnail into the archive. Thumbnails
+// that are missing or not stored by us are skipped.
+func (cfg *apiConfig) writeZipThumbnail(zw *zip.Writer, videoID uuid.UUID, thumbnailURL string) error {
+	thumbnailPath, ok := cfg.thumbnailPath(thumbnailURL)
+	if !ok {
+		return nil
+	}
+	src, err := os.Open(thumbnailPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := createZipFile(zw, "thumbnails/"+videoID.String()+filepath.Ext(thumbnailPath), zip.Deflate)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// writeZipVideo copies a video file from S3 into the archive. Videos that are
+// missing or not stored by us are skipped.
+func (cfg *apiConfig) writeZipVideo(ctx context.Context, zw *zip.Writer, videoID uuid.UUID, videoURL string) error {
+	key, ok := cfg.videoS3Key(videoURL)
+	if !ok {
+		return nil
+	}
+	obj, err := cfg.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cfg.s3Bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	// videos are already compressed
+	w, err := createZipFile(zw, "videos/"+videoID.String()+path.Ext(key), zip.Store)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, obj.Body)
+	return err
+}
+
+// pruneDataExports deletes expired exports and their archives. Errors are
+// only logged.
+func (cfg *apiConfig) pruneDataExports() {
+	paths, err := cfg.db.DeleteExpiredDataExports()
+	if err != nil {
+		log.Printf("Couldn't delete expired data exports: %s", err)
+		return
+	}
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Couldn't delete data export archive: %s", err)
+		}
+	}
+}
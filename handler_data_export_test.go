@@ -0,0 +1,187 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestDataExport(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.assetsRoot = t.TempDir()
+	cfg.exportsRoot = t.TempDir()
+	cfg.publicURL = "https://tubely.example"
+	mail := make(chanMailer, 10)
+	cfg.mailer = mail
+	user := newTestUser(t, cfg)
+	other := newTestUser(t, cfg)
+
+	thumbnail := filepath.Join(cfg.assetsRoot, "thumbnail.png")
+	if err := os.WriteFile(thumbnail, []byte("png"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "mine", UserID: user.ID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	video.ThumbnailURL = &thumbnail
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	if _, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "theirs", UserID: other.ID}); err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+
+	w := serveAs(t, cfg, cfg.handlerDataExportCreate, user, http.MethodPost, nil, "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("create: status = %d: %s", w.Code, w.Body)
+	}
+	var created dataExportResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("decoding export: %v", err)
+	}
+	select {
+	case msg := <-mail:
+		if msg.To != user.Email {
+			t.Errorf("export email sent to %s, want %s", msg.To, user.Email)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("export email not sent")
+	}
+
+	get := func(as database.User) *httptest.ResponseRecorder {
+		return serveAs(t, cfg, cfg.handlerDataExportGet, as, http.MethodGet, map[string]string{"exportID": created.ID.String()}, "")
+	}
+	if w := get(other); w.Code != http.StatusNotFound {
+		t.Errorf("export of another user: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w = get(user)
+	var export dataExportResponse
+	if err := json.NewDecoder(w.Body).Decode(&export); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("get: status %d, %v", w.Code, err)
+	}
+	if export.Status != database.DataExportReady || export.DownloadURL == nil {
+		t.Fatalf("export = %+v, want ready with a download link", export)
+	}
+
+	download := func(exportID uuid.UUID, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/exports/"+exportID.String()+"/download?token="+token, nil)
+		r.SetPathValue("exportID", exportID.String())
+		w := httptest.NewRecorder()
+		cfg.handlerDataExportDownload(w, r)
+		return w
+	}
+	otherExport, err := cfg.db.CreateDataExport(other.ID)
+	if err != nil {
+		t.Fatalf("CreateDataExport: %v", err)
+	}
+	token := strings.SplitN(*export.DownloadURL, "token=", 2)[1]
+	accessToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"without token":           download(created.ID, ""),
+		"with access token":       download(created.ID, accessToken),
+		"token of another export": download(otherExport.ID, token),
+	} {
+		if w.Code != http.StatusForbidden {
+			t.Errorf("download %s: status = %d, want %d", name, w.Code, http.StatusForbidden)
+		}
+	}
+
+	w = download(created.ID, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("download: status = %d: %s", w.Code, w.Body)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("opening %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", f.Name, err)
+		}
+		files[f.Name] = string(data)
+	}
+	for _, name := range []string{"passkeys.json", "organizations.json", "webhooks.json", "playlists.json", "comments.json", "watch_progress.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("archive has no %s", name)
+		}
+	}
+	if !strings.Contains(files["profile.json"], user.Email) {
+		t.Errorf("profile.json = %s, want the user's profile", files["profile.json"])
+	}
+	var videos []database.Video
+	if err := json.Unmarshal([]byte(files["videos.json"]), &videos); err != nil {
+		t.Fatalf("decoding videos.json: %v", err)
+	}
+	if len(videos) != 1 || videos[0].ID != video.ID {
+		t.Errorf("videos.json = %v, want only %s", videos, video.ID)
+	}
+	if got := files["thumbnails/"+video.ID.String()+".png"]; got != "png" {
+		t.Errorf("thumbnail in archive = %q, want %q", got, "png")
+	}
+}
+
+func TestPruneDataExports(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.exportsRoot = t.TempDir()
+	user := newTestUser(t, cfg)
+
+	exports := map[string]time.Time{
+		"expired": time.Now().UTC().Add(-time.Minute),
+		"current": time.Now().UTC().Add(time.Hour),
+	}
+	ids := map[string]uuid.UUID{}
+	for name, expiresAt := range exports {
+		export, err := cfg.db.CreateDataExport(user.ID)
+		if err != nil {
+			t.Fatalf("CreateDataExport: %v", err)
+		}
+		path := filepath.Join(cfg.exportsRoot, name+".zip")
+		if err := os.WriteFile(path, []byte("zip"), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if err := cfg.db.CompleteDataExport(export.ID, path, 3, expiresAt); err != nil {
+			t.Fatalf("CompleteDataExport: %v", err)
+		}
+		ids[name] = export.ID
+	}
+
+	cfg.pruneDataExports()
+
+	for name, id := range ids {
+		wantKept := name == "current"
+		export, err := cfg.db.GetDataExport(id)
+		if err != nil {
+			t.Fatalf("GetDataExport: %v", err)
+		}
+		if kept := export != nil; kept != wantKept {
+			t.Errorf("%s export kept = %v, want %v", name, kept, wantKept)
+		}
+		_, err = os.Stat(filepath.Join(cfg.exportsRoot, name+".zip"))
+		if kept := err == nil; kept != wantKept {
+			t.Errorf("%s archive kept = %v, want %v", name, kept, wantKept)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	deviceCodeTTL         = 10 * time.Minute
+	deviceCodeInterval    = 5 * time.Second
+	deviceCodeGrantType   = "urn:ietf:params:oauth:grant-type:device_code"
+	deviceVerificationURL = "/app/device.html"
+)
+
+// handlerDeviceCode starts an RFC 8628 device authorization grant. The device
+// shows the user code and polls handlerDeviceToken with the device code.
+func (cfg *apiConfig) handlerDeviceCode(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+	}
+
+	deviceCode, err := auth.MakeOneTimeToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create device code", err)
+		return
+	}
+
+	// retry in the unlikely case the user code is taken
+	var userCode string
+	for attempt := 0; ; attempt++ {
+		userCode, err = auth.MakeDeviceUserCode()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create user code", err)
+			return
+		}
+		err = cfg.db.CreateDeviceAuthorization(database.CreateDeviceAuthorizationParams{
+			DeviceCodeHash: auth.HashOneTimeToken(deviceCode),
+			UserCode:       userCode,
+			ExpiresAt:      time.Now().UTC().Add(deviceCodeTTL),
+			Interval:       deviceCodeInterval,
+		})
+		if err == nil {
+			break
+		}
+		if attempt == 2 {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save device code", err)
+			return
+		}
+	}
+
+	verificationURI := cfg.publicURL + deviceVerificationURL
+	respondWithJSON(w, http.StatusOK, response{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + userCode,
+		ExpiresIn:               int(deviceCodeTTL.Seconds()),
+		Interval:                int(deviceCodeInterval.Seconds()),
+	})
+}
+
+// handlerDeviceApprove lets a signed-in user approve (or deny) the device
+// showing the user code.
+func (cfg *apiConfig) handlerDeviceApprove(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UserCode string `json:"user_code"`
+		Deny     bool   `json:"deny"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	userCode := auth.NormalizeDeviceUserCode(params.UserCode)
+
+	grant, err := cfg.db.GetDeviceAuthorizationByUserCode(userCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get device code", err)
+		return
+	}
+	if grant == nil || grant.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusNotFound, "Invalid or expired code", nil)
+		return
+	}
+
+	ok, err := cfg.db.DecideDeviceAuthorization(userCode, user.ID, !params.Deny)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update device code", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusConflict, "This code has already been used", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerDeviceToken is polled by the device. Until the user decides it
+// answers with the RFC 8628 error codes; once approved it issues the same
+// tokens as handlerLogin, exactly once.
+func (cfg *apiConfig) handlerDeviceToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		GrantType  string `json:"grant_type"`
+		DeviceCode string `json:"device_code"`
+	}
+
+	// OAuth clients send forms, but accept JSON like the rest of the API
+	params := parameters{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "invalid_request", err)
+			return
+		}
+	} else {
+		params.GrantType = r.PostFormValue("grant_type")
+		params.DeviceCode = r.PostFormValue("device_code")
+	}
+
+	if params.GrantType != deviceCodeGrantType {
+		respondWithError(w, http.StatusBadRequest, "unsupported_grant_type", nil)
+		return
+	}
+
+	deviceCodeHash := auth.HashOneTimeToken(params.DeviceCode)
+	grant, err := cfg.db.GetDeviceAuthorizationByDeviceCode(deviceCodeHash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "server_error", err)
+		return
+	}
+	if grant == nil || grant.ConsumedAt != nil {
+		respondWithError(w, http.StatusBadRequest, "invalid_grant", nil)
+		return
+	}
+	if grant.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "expired_token", nil)
+		return
+	}
+
+	interval := grant.Interval
+	tooFast := grant.LastPolledAt != nil && time.Since(*grant.LastPolledAt) < grant.Interval
+	if tooFast {
+		interval += 5 * time.Second
+	}
+	if err := cfg.db.PollDeviceAuthorization(deviceCodeHash, interval); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "server_error", err)
+		return
+	}
+	if tooFast {
+		respondWithError(w, http.StatusBadRequest, "slow_down", nil)
+		return
+	}
+
+	if grant.DeniedAt != nil {
+		respondWithError(w, http.StatusBadRequest, "access_denied", nil)
+		return
+	}
+	if grant.ApprovedAt == nil || grant.UserID == nil {
+		respondWithError(w, http.StatusBadRequest, "authorization_pending", nil)
+		return
+	}
+
+	ok, err := cfg.db.ConsumeDeviceAuthorization(deviceCodeHash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "server_error", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "invalid_grant", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(*grant.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "server_error", err)
+		return
+	}
+	if user == nil || user.DisabledAt != nil {
+		respondWithError(w, http.StatusBadRequest, "access_denied", nil)
+		return
+	}
+
+	cfg.respondWithTokens(w, r, *user, "device")
+}
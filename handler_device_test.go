@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestDeviceAuthorization(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := database.NewClient(dbPath)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	cfg := &apiConfig{db: db, jwtSecret: "test-secret", loginGuard: newLoginGuard()}
+	// for moving grants through time
+	raw, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer raw.Close()
+	user := newTestUser(t, cfg)
+
+	newGrant := func() (deviceCode, userCode string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		cfg.handlerDeviceCode(w, httptest.NewRequest(http.MethodPost, "/api/device/code", nil))
+		var resp struct {
+			DeviceCode string `json:"device_code"`
+			UserCode   string `json:"user_code"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("device code: status %d, %v", w.Code, err)
+		}
+		return resp.DeviceCode, resp.UserCode
+	}
+	poll := func(grantType, deviceCode string) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {grantType}, "device_code": {deviceCode}}
+		r := httptest.NewRequest(http.MethodPost, "/api/device/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		cfg.handlerDeviceToken(w, r)
+		return w
+	}
+	decide := func(userCode string, deny bool) int {
+		body := fmt.Sprintf(`{"user_code": %q, "deny": %v}`, userCode, deny)
+		return serveAs(t, cfg, cfg.handlerDeviceApprove, user, http.MethodPost, nil, body).Code
+	}
+	exec := func(query, deviceCode string) {
+		t.Helper()
+		if _, err := raw.Exec(query, auth.HashOneTimeToken(deviceCode)); err != nil {
+			t.Fatalf("updating grant: %v", err)
+		}
+	}
+	// lets the device poll again right away
+	const waited = "UPDATE device_authorizations SET last_polled_at = NULL WHERE device_code_hash = ?"
+	const expired = "UPDATE device_authorizations SET expires_at = datetime('now', '-1 minute') WHERE device_code_hash = ?"
+
+	approved, approvedUserCode := newGrant()
+	denied, deniedUserCode := newGrant()
+	late, lateUserCode := newGrant()
+
+	tests := []struct {
+		name       string
+		do         func() *httptest.ResponseRecorder
+		wantStatus int
+		wantError  string
+	}{
+		{name: "other grant type", do: func() *httptest.ResponseRecorder { return poll("password", approved) }, wantStatus: http.StatusBadRequest, wantError: "unsupported_grant_type"},
+		{name: "unknown device code", do: func() *httptest.ResponseRecorder { return poll(deviceCodeGrantType, "unknown") }, wantStatus: http.StatusBadRequest, wantError: "invalid_grant"},
+		{name: "pending", do: func() *httptest.ResponseRecorder { return poll(deviceCodeGrantType, approved) }, wantStatus: http.StatusBadRequest, wantError: "authorization_pending"},
+		{name: "polled too soon", do: func() *httptest.ResponseRecorder { return poll(deviceCodeGrantType, approved) }, wantStatus: http.StatusBadRequest, wantError: "slow_down"},
+		{name: "approved", do: func() *httptest.ResponseRecorder {
+			exec(waited, approved)
+			// user codes may be typed in lower case and without the dash
+			if code := decide(strings.ToLower(strings.ReplaceAll(approvedUserCode, "-", "")), false); code != http.StatusNoContent {
+				t.Fatalf("approve: status = %d", code)
+			}
+			return poll(deviceCodeGrantType, approved)
+		}, wantStatus: http.StatusOK},
+		{name: "exchanged again", do: func() *httptest.ResponseRecorder {
+			exec(waited, approved)
+			return poll(deviceCodeGrantType, approved)
+		}, wantStatus: http.StatusBadRequest, wantError: "invalid_grant"},
+		{name: "approved again", do: func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			w.WriteHeader(decide(approvedUserCode, false))
+			return w
+		}, wantStatus: http.StatusConflict},
+		{name: "denied", do: func() *httptest.ResponseRecorder {
+			if code := decide(deniedUserCode, true); code != http.StatusNoContent {
+				t.Fatalf("deny: status = %d", code)
+			}
+			return poll(deviceCodeGrantType, denied)
+		}, wantStatus: http.StatusBadRequest, wantError: "access_denied"},
+		{name: "expired", do: func() *httptest.ResponseRecorder {
+			exec(expired, late)
+			return poll(deviceCodeGrantType, late)
+		}, wantStatus: http.StatusBadRequest, wantError: "expired_token"},
+		{name: "approving expired", do: func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			w.WriteHeader(decide(lateUserCode, false))
+			return w
+		}, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.do()
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var resp struct {
+				Error string `json:"error"`
+				Token string `json:"token"`
+			}
+			if w.Body.Len() > 0 {
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("decoding response: %v", err)
+				}
+			}
+			if resp.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", resp.Error, tt.wantError)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if userID, err := auth.ValidateJWT(resp.Token, cfg.jwtSecret); err != nil || userID != user.ID {
+					t.Errorf("access token for %v, %v, want %v", userID, err, user.ID)
+				}
+			}
+		})
+	}
+
+	grant, err := cfg.db.GetDeviceAuthorizationByDeviceCode(auth.HashOneTimeToken(denied))
+	if err != nil || grant == nil {
+		t.Fatalf("GetDeviceAuthorizationByDeviceCode: %v, %v", grant, err)
+	}
+	if grant.Interval != deviceCodeInterval {
+		t.Errorf("interval of a grant polled in time = %s, want %s", grant.Interval, deviceCodeInterval)
+	}
+	grant, err = cfg.db.GetDeviceAuthorizationByDeviceCode(auth.HashOneTimeToken(approved))
+	if err != nil || grant == nil {
+		t.Fatalf("GetDeviceAuthorizationByDeviceCode: %v, %v", grant, err)
+	}
+	if want := deviceCodeInterval + 5*time.Second; grant.Interval != want {
+		t.Errorf("interval after slow_down = %s, want %s", grant.Interval, want)
+	}
+}
@@ -14,11 +14,6 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -28,18 +23,76 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := cfg.clientIP(r)
+	if wait := cfg.loginGuard.retryAfter(params.Email, ip); wait > 0 {
+		respondTooManyAttempts(w, wait)
+		return
+	}
+
 	user, err := cfg.db.GetUserByEmail(params.Email)
 	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	// compare against a dummy hash for unknown emails so that both cases take
+	// as long as a bcrypt check
+	passwordHash := user.Password
+	if user.Email == "" {
+		passwordHash = cfg.dummyPasswordHash
+	}
+	err = auth.CheckPasswordHash(params.Password, passwordHash)
+
+	// disabled accounts are rejected the same way whether or not the password
+	// matched, so the response doesn't confirm a guessed password
+	if user.Email != "" && user.DisabledAt != nil {
+		if err != nil {
+			cfg.loginGuard.recordFailure(params.Email, ip)
+		}
+		respondWithError(w, http.StatusForbidden, "This account has been disabled", nil)
+		return
+	}
+
+	if err != nil || user.Email == "" {
+		cfg.loginGuard.recordFailure(params.Email, ip)
+		event := database.AuditEvent{
+			Action:  auditLoginFailed,
+			Details: map[string]string{"method": "password", "email": params.Email},
+		}
+		if user.Email != "" {
+			event.TargetType = "user"
+			event.TargetID = user.ID.String()
+		}
+		cfg.audit(r, event)
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.Password)
+	enrolled, err := cfg.totpEnrolled(user.ID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+		return
+	}
+	if enrolled {
+		// failures are only forgotten once the second factor is passed too
+		cfg.respondWithTOTPChallenge(w, user)
 		return
 	}
 
+	cfg.loginGuard.recordSuccess(user.Email)
+	cfg.respondWithTokens(w, r, user, "password")
+}
+
+// respondWithTokens issues a new access and refresh token pair for user and
+// writes them along with the user. Every login method ends here, so this is
+// also where logins are audited.
+func (cfg *apiConfig) respondWithTokens(w http.ResponseWriter, r *http.Request, user database.User, method string) {
+	type response struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
 	accessToken, err := auth.MakeJWT(
 		user.ID,
 		cfg.jwtSecret,
@@ -66,6 +119,14 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditLogin,
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Details:    map[string]string{"method": method},
+	})
+
 	respondWithJSON(w, http.StatusOK, response{
 		User:         user,
 		Token:        accessToken,
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerLogin(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		password   string
+		disabled   bool
+		wantStatus int
+	}{
+		{name: "correct password", email: "user@example.com", password: "secret", wantStatus: http.StatusOK},
+		{name: "wrong password", email: "user@example.com", password: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "unknown email", email: "nobody@example.com", password: "secret", wantStatus: http.StatusUnauthorized},
+		{name: "disabled, correct password", email: "user@example.com", password: "secret", disabled: true, wantStatus: http.StatusForbidden},
+		{name: "disabled, wrong password", email: "user@example.com", password: "guess", disabled: true, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := database.NewClient(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			hash, err := auth.HashPassword("secret")
+			if err != nil {
+				t.Fatalf("HashPassword: %v", err)
+			}
+			user, err := db.CreateUser(database.CreateUserParams{Email: "user@example.com", Password: hash})
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			if tt.disabled {
+				if err := db.SetUserDisabled(user.ID, true); err != nil {
+					t.Fatalf("SetUserDisabled: %v", err)
+				}
+			}
+			dummy, err := auth.HashPassword("dummy")
+			if err != nil {
+				t.Fatalf("HashPassword: %v", err)
+			}
+			cfg := &apiConfig{
+				db:                db,
+				jwtSecret:         "test-secret",
+				loginGuard:        newLoginGuard(),
+				dummyPasswordHash: dummy,
+			}
+
+			body := `{"email": "` + tt.email + `", "password": "` + tt.password + `"}`
+			w := httptest.NewRecorder()
+			cfg.handlerLogin(w, httptest.NewRequest("POST", "/api/login", strings.NewReader(body)))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+)
+
+const (
+	oidcLoginStateTTL = 10 * time.Minute
+	// oidcStateCookie binds a login to the browser that started it, so that
+	// nobody can have someone else's browser finish a login they started
+	oidcStateCookie = "tubely_oidc_state"
+)
+
+// setOIDCStateCookie remembers state in the browser for oidcLoginStateTTL, or
+// forgets it if state is empty. SameSite=Lax still sends the cookie along with
+// the identity provider's redirect back to us.
+func (cfg *apiConfig) setOIDCStateCookie(w http.ResponseWriter, state string) {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/",
+		MaxAge:   int(oidcLoginStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if state == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login state", err)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login state", err)
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login state", err)
+		return
+	}
+
+	authURL, err := cfg.oidcProvider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach identity provider", err)
+		return
+	}
+
+	err = cfg.db.CreateOIDCLoginState(database.OIDCLoginState{
+		State:        state,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginStateTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save login state", err)
+		return
+	}
+
+	cfg.setOIDCStateCookie(w, state)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "Identity provider returned an error: "+errCode, nil)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login wasn't started in this browser", err)
+		return
+	}
+	cfg.setOIDCStateCookie(w, "")
+
+	state, err := cfg.db.UseOIDCLoginState(query.Get("state"))
+	if errors.Is(err, database.ErrInvalidOIDCState) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired login state", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login state", err)
+		return
+	}
+	if state.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired login state", nil)
+		return
+	}
+
+	idToken, err := cfg.oidcProvider.Exchange(r.Context(), query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't sign in with identity provider", err)
+		return
+	}
+
+	user, err := cfg.userForIDToken(idToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't sign in with identity provider", err)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "This account has been disabled", nil)
+		return
+	}
+
+	// the identity provider only replaces the password, so users with
+	// two-factor authentication still need to pass it
+	enrolled, err := cfg.totpEnrolled(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+		return
+	}
+	if enrolled {
+		cfg.respondWithTOTPChallenge(w, *user)
+		return
+	}
+
+	cfg.respondWithTokens(w, r, *user, "oidc")
+}
+
+// userForIDToken returns the user linked to the identity in idToken. Unknown
+// identities are linked to the user with the same email, or to a new user if
+// there is none; either way the provider must have verified the email.
+func (cfg *apiConfig) userForIDToken(idToken *oidc.IDToken) (*database.User, error) {
+	user, err := cfg.db.GetUserByIdentity(idToken.Issuer, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return nil, errors.New("identity provider didn't return a verified email")
+	}
+
+	existing, err := cfg.db.GetUserByEmail(idToken.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing.Email != "" {
+		user = &existing
+	} else {
+		// the account can only be used through the identity provider until
+		// the user resets its password
+		randomPassword, err := auth.MakeOneTimeToken()
+		if err != nil {
+			return nil, err
+		}
+		hashedPassword, err := auth.HashPassword(randomPassword)
+		if err != nil {
+			return nil, err
+		}
+		user, err = cfg.db.CreateUser(database.CreateUserParams{
+			Email:    idToken.Email,
+			Password: hashedPassword,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.db.LinkUserIdentity(user.ID, idToken.Issuer, idToken.Subject); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.db.MarkUserVerified(user.ID, idToken.Email); err != nil {
+		return nil, err
+	}
+	return cfg.db.GetUser(user.ID)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testOIDCClientID    = "tubely"
+	testOIDCRedirectURL = "http://localhost:8091/api/oidc/callback"
+)
+
+// mockOIDCProvider is an OpenID provider that signs in whoever is set as its
+// current user, and checks PKCE like a real provider would.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu sync.Mutex
+	// requests are the authorization requests by the code they were answered
+	// with
+	requests map[string]url.Values
+
+	subject       string
+	email         string
+	emailVerified bool
+	// nonce, if set, replaces the nonce of the authorization request in the
+	// ID token
+	nonce string
+	// challenge, if set, replaces the PKCE challenge of the authorization
+	// request the token request is checked against
+	challenge string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	p := &mockOIDCProvider{key: key, requests: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("GET /authorize", p.handleAuthorize)
+	mux.HandleFunc("POST /token", p.handleToken)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// handleAuthorize signs the current user in right away and redirects back
+// with a code.
+func (p *mockOIDCProvider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testOIDCClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	code, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.mu.Lock()
+	p.requests[code] = q
+	p.mu.Unlock()
+
+	redirect := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+redirect.Encode(), http.StatusFound)
+}
+
+func (p *mockOIDCProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	authRequest, ok := p.requests[r.PostForm.Get("code")]
+	delete(p.requests, r.PostForm.Get("code"))
+	if !ok || r.PostForm.Get("redirect_uri") != authRequest.Get("redirect_uri") {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	challenge := authRequest.Get("code_challenge")
+	if p.challenge != "" {
+		challenge = p.challenge
+	}
+	if oidc.CodeChallengeS256(r.PostForm.Get("code_verifier")) != challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	nonce := authRequest.Get("nonce")
+	if p.nonce != "" {
+		nonce = p.nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.server.URL,
+		"aud":            testOIDCClientID,
+		"sub":            p.subject,
+		"email":          p.email,
+		"email_verified": p.emailVerified,
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func newOIDCTestConfig(t *testing.T, provider *mockOIDCProvider) *apiConfig {
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return &apiConfig{
+		db:         db,
+		jwtSecret:  "test-secret",
+		publicURL:  "http://localhost:8091",
+		loginGuard: newLoginGuard(),
+		oidcProvider: oidc.NewProvider(oidc.Config{
+			Issuer:      provider.server.URL,
+			ClientID:    testOIDCClientID,
+			RedirectURL: testOIDCRedirectURL,
+			HTTPClient:  provider.server.Client(),
+		}),
+	}
+}
+
+// startOIDCLogin starts a login and returns the browser's state cookie and
+// the callback URL the provider redirected back to.
+func startOIDCLogin(t *testing.T, cfg *apiConfig, provider *mockOIDCProvider) (*http.Cookie, *url.URL) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	cfg.handlerOIDCLogin(w, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body)
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("login didn't set an HttpOnly state cookie: %v", w.Result().Cookies())
+	}
+
+	client := provider.server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing callback URL: %v", err)
+	}
+	return cookie, callback
+}
+
+func finishOIDCLogin(cfg *apiConfig, cookie *http.Cookie, callback *url.URL) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	cfg.handlerOIDCCallback(w, r)
+	return w
+}
+
+func TestOIDCLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares the provider and database, and may return a user
+		// the login must end up signed in as
+		setup func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *database.User
+		// login runs the flow, by default startOIDCLogin then
+		// finishOIDCLogin
+		login      func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *httptest.ResponseRecorder
+		wantStatus int
+		wantTOTP   bool
+	}{
+		{
+			name:       "new identity creates a linked user",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "linked identity signs in its user",
+			setup: func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *database.User {
+				user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "other@example.com", Password: "hash"})
+				if err != nil {
+					t.Fatalf("CreateUser: %v", err)
+				}
+				if err := cfg.db.LinkUserIdentity(user.ID, p.server.URL, p.subject); err != nil {
+					t.Fatalf("LinkUserIdentity: %v", err)
+				}
+				return user
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "verified email links the existing user",
+			setup: func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *database.User {
+				user, err := cfg.db.CreateUser(database.CreateUserParams{Email: p.email, Password: "hash"})
+				if err != nil {
+					t.Fatalf("CreateUser: %v", err)
+				}
+				return user
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "unverified email isn't linked",
+			setup: func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *database.User {
+				if _, err := cfg.db.CreateUser(database.CreateUserParams{Email: p.email, Password: "hash"}); err != nil {
+					t.Fatalf("CreateUser: %v", err)
+				}
+				p.emailVerified = false
+				return nil
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "user with TOTP gets a challenge",
+			setup: func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *database.User {
+				user, err := cfg.db.CreateUser(database.CreateUserParams{Email: p.email, Password: "hash"})
+				if err != nil {
+					t.Fatalf("CreateUser: %v", err)
+				}
+				if err := cfg.db.StartUserTOTPEnrollment(user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+					t.Fatalf("StartUserTOTPEnrollment: %v", err)
+				}
+				if err := cfg.db.ConfirmUserTOTP(user.ID, 1, nil); err != nil {
+					t.Fatalf("ConfirmUserTOTP: %v", err)
+				}
+				return nil
+			},
+			wantStatus: http.StatusOK,
+			wantTOTP:   true,
+		},
+		{
+			name: "missing state cookie",
+			login: func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *httptest.ResponseRecorder {
+				_, callback := startOIDCLogin(t, cfg, p)
+				return finishOIDCLogin(cfg, nil, callback)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "state cookie of another login",
+			login: func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *httptest.ResponseRecorder {
+				attackerCookie, _ := startOIDCLogin(t, cfg, p)
+				_, victimCallback := startOIDCLogin(t, cfg, p)
+				return finishOIDCLogin(cfg, attackerCookie, victimCallback)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "state is used only once",
+			login: func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *httptest.ResponseRecorder {
+				cookie, callback := startOIDCLogin(t, cfg, p)
+				if w := finishOIDCLogin(cfg, cookie, callback); w.Code != http.StatusOK {
+					t.Fatalf("first callback status = %d: %s", w.Code, w.Body)
+				}
+				return finishOIDCLogin(cfg, cookie, callback)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "nonce mismatch",
+			setup: func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *database.User {
+				p.nonce = "replayed-nonce"
+				return nil
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "PKCE verifier mismatch",
+			setup: func(t *testing.T, cfg *apiConfig, p *mockOIDCProvider) *database.User {
+				p.challenge = oidc.CodeChallengeS256("someone else's verifier")
+				return nil
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockOIDCProvider(t)
+			p.subject = "subject-1"
+			p.email = "user@example.com"
+			p.emailVerified = true
+			cfg := newOIDCTestConfig(t, p)
+			var wantUser *database.User
+			if tt.setup != nil {
+				wantUser = tt.setup(t, cfg, p)
+			}
+
+			var w *httptest.ResponseRecorder
+			if tt.login != nil {
+				w = tt.login(t, cfg, p)
+			} else {
+				cookie, callback := startOIDCLogin(t, cfg, p)
+				w = finishOIDCLogin(cfg, cookie, callback)
+			}
+			if w.Code != tt.wantStatus {
+				t.Fatalf("callback status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				ID             string `json:"id"`
+				Token          string `json:"token"`
+				TOTPRequired   bool   `json:"totp_required"`
+				ChallengeToken string `json:"challenge_token"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if tt.wantTOTP {
+				if !resp.TOTPRequired || resp.ChallengeToken == "" || resp.Token != "" {
+					t.Fatalf("response = %+v, want a TOTP challenge and no token", resp)
+				}
+				return
+			}
+			if resp.Token == "" {
+				t.Fatalf("response has no token")
+			}
+
+			linked, err := cfg.db.GetUserByIdentity(p.server.URL, p.subject)
+			if err != nil {
+				t.Fatalf("GetUserByIdentity: %v", err)
+			}
+			if linked == nil || linked.ID.String() != resp.ID {
+				t.Fatalf("identity is linked to %v, want the signed in user %s", linked, resp.ID)
+			}
+			if wantUser != nil && linked.ID != wantUser.ID {
+				t.Errorf("signed in as %s, want %s", linked.ID, wantUser.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxOrganizationNameLength = 100
+
+func validOrganizationName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	if utf8.RuneCountInString(name) > maxOrganizationNameLength {
+		return "", fmt.Errorf("name can be at most %d characters", maxOrganizationNameLength)
+	}
+	return name, nil
+}
+
+func (cfg *apiConfig) handlerOrganizationsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	name, err := validOrganizationName(params.Name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization name", err)
+		return
+	}
+
+	org, err := cfg.db.CreateOrganization(name, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, database.UserOrganization{
+		Organization: org,
+		Role:         database.OrganizationRoleOwner,
+	})
+}
+
+func (cfg *apiConfig) handlerOrganizationsList(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	orgs, err := cfg.db.GetUserOrganizations(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organizations", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+// organizationRequest authenticates the request and checks that the caller
+// has at least the given role in the organization named by the path. On
+// failure it writes the error response and returns false.
+func (cfg *apiConfig) organizationRequest(w http.ResponseWriter, r *http.Request, min database.OrganizationRole) (*database.User, *database.OrganizationMember, bool) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return nil, nil, false
+	}
+
+	organizationID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return nil, nil, false
+	}
+
+	member, ok := cfg.requireOrganizationRole(w, user.ID, organizationID, min)
+	if !ok {
+		return nil, nil, false
+	}
+	return user, member, true
+}
+
+func (cfg *apiConfig) handlerOrganizationGet(w http.ResponseWriter, r *http.Request) {
+	_, member, ok := cfg.organizationRequest(w, r, database.OrganizationRoleViewer)
+	if !ok {
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(member.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	if org == nil {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, database.UserOrganization{
+		Organization: *org,
+		Role:         member.Role,
+	})
+}
+
+func (cfg *apiConfig) handlerOrganizationUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	_, member, ok := cfg.organizationRequest(w, r, database.OrganizationRoleOwner)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	name, err := validOrganizationName(params.Name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization name", err)
+		return
+	}
+
+	err = cfg.db.RenameOrganization(member.OrganizationID, name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update organization", err)
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(member.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, database.UserOrganization{
+		Organization: *org,
+		Role:         member.Role,
+	})
+}
+
+// handlerOrganizationDelete deletes an organization that no longer owns any
+// videos.
+func (cfg *apiConfig) handlerOrganizationDelete(w http.ResponseWriter, r *http.Request) {
+	_, member, ok := cfg.organizationRequest(w, r, database.OrganizationRoleOwner)
+	if !ok {
+		return
+	}
+
+	n, err := cfg.db.CountOrganizationVideos(member.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count videos", err)
+		return
+	}
+	if n > 0 {
+		respondWithError(w, http.StatusConflict, "Delete the organization's videos first", nil)
+		return
+	}
+
+	err = cfg.db.DeleteOrganization(member.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete organization", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerOrganizationMembersList(w http.ResponseWriter, r *http.Request) {
+	_, member, ok := cfg.organizationRequest(w, r, database.OrganizationRoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := cfg.db.GetOrganizationMembers(member.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// handlerOrganizationMembersAdd adds an existing user to the organization by
+// email.
+func (cfg *apiConfig) handlerOrganizationMembersAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string                    `json:"email"`
+		Role  database.OrganizationRole `json:"role"`
+	}
+
+	_, member, ok := cfg.organizationRequest(w, r, database.OrganizationRoleOwner)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.Email == "" {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	added, err := cfg.db.AddOrganizationMember(member.OrganizationID, user.ID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add member", err)
+		return
+	}
+	if !added {
+		respondWithError(w, http.StatusConflict, "User is already a member", nil)
+		return
+	}
+
+	newMember, err := cfg.db.GetOrganizationMember(member.OrganizationID, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get member", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, newMember)
+}
+
+// organizationMemberTarget returns the member named by the userID path value
+// of an organization request. On failure it writes the error response and
+// returns false.
+func (cfg *apiConfig) organizationMemberTarget(w http.ResponseWriter, r *http.Request, organizationID uuid.UUID) (*database.OrganizationMember, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return nil, false
+	}
+	target, err := cfg.db.GetOrganizationMember(organizationID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get member", err)
+		return nil, false
+	}
+	if target == nil {
+		respondWithError(w, http.StatusNotFound, "Member not found", nil)
+		return nil, false
+	}
+	return target, true
+}
+
+// isLastOwner reports whether member is the organization's only owner, who
+// can't leave or be demoted.
+func (cfg *apiConfig) isLastOwner(member database.OrganizationMember) (bool, error) {
+	if member.Role != database.OrganizationRoleOwner {
+		return false, nil
+	}
+	n, err := cfg.db.CountOrganizationOwners(member.OrganizationID)
+	if err != nil {
+		return false, err
+	}
+	return n <= 1, nil
+}
+
+func (cfg *apiConfig) handlerOrganizationMemberSetRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role database.OrganizationRole `json:"role"`
+	}
+
+	_, member, ok := cfg.organizationRequest(w, r, database.OrganizationRoleOwner)
+	if !ok {
+		return
+	}
+	target, ok := cfg.organizationMemberTarget(w, r, member.OrganizationID)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+
+	if params.Role != database.OrganizationRoleOwner {
+		lastOwner, err := cfg.isLastOwner(*target)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count owners", err)
+			return
+		}
+		if lastOwner {
+			respondWithError(w, http.StatusConflict, "An organization needs at least one owner", nil)
+			return
+		}
+	}
+
+	err = cfg.db.SetOrganizationMemberRole(member.OrganizationID, target.UserID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update member", err)
+		return
+	}
+	target.Role = params.Role
+
+	respondWithJSON(w, http.StatusOK, target)
+}
+
+// handlerOrganizationMemberRemove removes a member. Owners can remove anyone,
+// other members only themselves.
+func (cfg *apiConfig) handlerOrganizationMemberRemove(w http.ResponseWriter, r *http.Request) {
+	user, member, ok := cfg.organizationRequest(w, r, database.OrganizationRoleViewer)
+	if !ok {
+		return
+	}
+	target, ok := cfg.organizationMemberTarget(w, r, member.OrganizationID)
+	if !ok {
+		return
+	}
+	if target.UserID != user.ID && member.Role != database.OrganizationRoleOwner {
+		respondWithError(w, http.StatusForbidden, "Only owners can remove other members", nil)
+		return
+	}
+
+	lastOwner, err := cfg.isLastOwner(*target)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count owners", err)
+		return
+	}
+	if lastOwner {
+		respondWithError(w, http.StatusConflict, "An organization needs at least one owner", nil)
+		return
+	}
+
+	err = cfg.db.RemoveOrganizationMember(member.OrganizationID, target.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove member", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
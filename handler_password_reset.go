@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+)
+
+const passwordResetTokenTTL = time.Hour
+
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required", nil)
+		return
+	}
+
+	// always answer the same way, and before looking the email up, so that
+	// neither the response nor its timing reveals which emails have an
+	// account
+	go cfg.sendPasswordResetEmail(params.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendPasswordResetEmail issues a reset token for the account with the given
+// email, if there is one, and mails it. Errors are only logged.
+func (cfg *apiConfig) sendPasswordResetEmail(email string) {
+	user, err := cfg.db.GetUserByEmail(email)
+	if err != nil {
+		log.Printf("Couldn't look up user for password reset: %s", err)
+		return
+	}
+	if user.Email == "" || user.DisabledAt != nil {
+		return
+	}
+
+	token, err := auth.MakeOneTimeToken()
+	if err != nil {
+		log.Printf("Couldn't create password reset token: %s", err)
+		return
+	}
+	err = cfg.db.CreatePasswordResetToken(database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashOneTimeToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(passwordResetTokenTTL),
+	})
+	if err != nil {
+		log.Printf("Couldn't save password reset token: %s", err)
+		return
+	}
+
+	link := cfg.publicURL + "/app/reset.html?reset_token=" + url.QueryEscape(token)
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password of your Tubely account.\n\n"+
+				"Use this link or token within %s to choose a new password:\n\n%s\n\nToken: %s\n\n"+
+				"If you didn't ask for this, you can ignore this email.\n",
+			passwordResetTokenTTL, link, token,
+		),
+	}
+	if err := cfg.mailer.Send(msg); err != nil {
+		log.Printf("Couldn't send password reset email: %s", err)
+	}
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Token == "" || params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Token and password are required", nil)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	userID, err := cfg.db.ResetPasswordWithToken(auth.HashOneTimeToken(params.Token), hashedPassword)
+	if errors.Is(err, database.ErrInvalidPasswordResetToken) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditPasswordReset,
+		ActorID:    &userID,
+		TargetType: "user",
+		TargetID:   userID.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
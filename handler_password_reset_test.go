@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+)
+
+// chanMailer hands the messages it is asked to send to a channel.
+type chanMailer chan mailer.Message
+
+func (m chanMailer) Send(msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+func TestPasswordReset(t *testing.T) {
+	cfg := newTestConfig(t)
+	mail := make(chanMailer, 10)
+	cfg.mailer = mail
+	cfg.publicURL = "https://tubely.example"
+	user := newTestUser(t, cfg)
+
+	post := func(handler http.HandlerFunc, body string) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return w.Code
+	}
+	for _, email := range []string{"nobody@example.com", user.Email} {
+		if code := post(cfg.handlerPasswordResetRequest, fmt.Sprintf(`{"email": %q}`, email)); code != http.StatusAccepted {
+			t.Fatalf("requesting reset for %s: status = %d, want %d", email, code, http.StatusAccepted)
+		}
+	}
+
+	var msg mailer.Message
+	select {
+	case msg = <-mail:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no reset email sent")
+	}
+	if msg.To != user.Email {
+		t.Fatalf("email sent to %s, want %s", msg.To, user.Email)
+	}
+	link := regexp.MustCompile(`https://\S+`).FindString(msg.Body)
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parsing link %q: %v", link, err)
+	}
+	if parsed.Path != "/app/reset.html" {
+		t.Errorf("link points to %s, want the reset page", parsed.Path)
+	}
+	token := parsed.Query().Get("reset_token")
+
+	if code := post(cfg.handlerPasswordResetConfirm, fmt.Sprintf(`{"token": %q, "password": "new password"}`, token)); code != http.StatusNoContent {
+		t.Fatalf("confirming reset: status = %d, want %d", code, http.StatusNoContent)
+	}
+	got, err := cfg.db.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if err := auth.CheckPasswordHash("new password", got.Password); err != nil {
+		t.Errorf("password wasn't changed: %v", err)
+	}
+	if code := post(cfg.handlerPasswordResetConfirm, fmt.Sprintf(`{"token": %q, "password": "again"}`, token)); code != http.StatusBadRequest {
+		t.Errorf("reusing the token: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	select {
+	case msg := <-mail:
+		t.Errorf("unexpected email to %s", msg.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
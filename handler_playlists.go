@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxPlaylistVideos = 1000
+
+func validatePlaylistTitle(title string) error {
+	if title == "" {
+		return errors.New("Title can't be empty")
+	}
+	if utf8.RuneCountInString(title) > maxVideoTitleLength {
+		return fmt.Errorf("Title can be at most %d characters", maxVideoTitleLength)
+	}
+	return nil
+}
+
+// playlistRequest authenticates the request and loads the playlist named by
+// the path. Other users may only read playlists that aren't private, and
+// don't learn that private ones exist. On failure it writes the error
+// response and returns false.
+func (cfg *apiConfig) playlistRequest(w http.ResponseWriter, r *http.Request, write bool) (*database.User, database.Playlist, bool) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return nil, database.Playlist{}, false
+	}
+
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return nil, database.Playlist{}, false
+	}
+
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return nil, database.Playlist{}, false
+	}
+	if playlist == nil || (playlist.UserID != user.ID && playlist.Visibility == database.PlaylistVisibilityPrivate) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return nil, database.Playlist{}, false
+	}
+	if write && playlist.UserID != user.ID {
+		respondWithError(w, http.StatusForbidden, "Only the owner can change a playlist", nil)
+		return nil, database.Playlist{}, false
+	}
+	return user, *playlist, true
+}
+
+// playlistVideos returns the videos of the playlist the user may watch, in
+// order, with where the user left off in each.
+func (cfg *apiConfig) playlistVideos(user database.User, playlistID uuid.UUID) ([]database.Video, error) {
+	videos, err := cfg.db.GetPlaylistVideos(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	visible := []database.Video{}
+	for _, video := range videos {
+		ok, err := cfg.canAccessVideo(user, video, videoActionView)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			visible = append(visible, video)
+		}
+	}
+	if err := cfg.setResumePositions(user.ID, visible); err != nil {
+		return nil, err
+	}
+	return visible, nil
+}
+
+func (cfg *apiConfig) handlerPlaylistsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string                      `json:"title"`
+		Description string                      `json:"description"`
+		Visibility  database.PlaylistVisibility `json:"visibility"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Title = strings.TrimSpace(params.Title)
+	if err := validatePlaylistTitle(params.Title); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err := validateVideoDescription(params.Description); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if params.Visibility == "" {
+		params.Visibility = database.PlaylistVisibilityPrivate
+	}
+	if !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid visibility %q", params.Visibility), nil)
+		return
+	}
+
+	playlist, err := cfg.db.CreatePlaylist(database.CreatePlaylistParams{
+		UserID:      user.ID,
+		Title:       params.Title,
+		Description: params.Description,
+		Visibility:  params.Visibility,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, playlist)
+}
+
+// handlerPlaylistsList returns the caller's playlists, or the public
+// playlists of another user with ?user=.
+func (cfg *apiConfig) handlerPlaylistsList(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	var playlists []database.Playlist
+	if v := r.URL.Query().Get("user"); v != "" {
+		userID, err := uuid.Parse(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+			return
+		}
+		if userID == user.ID {
+			playlists, err = cfg.db.GetPlaylists(user.ID)
+		} else {
+			playlists, err = cfg.db.GetPublicPlaylists(userID)
+		}
+	} else {
+		playlists, err = cfg.db.GetPlaylists(user.ID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, playlists)
+}
+
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	_, playlist, ok := cfg.playlistRequest(w, r, false)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, playlist)
+}
+
+// handlerPlaylistUpdate changes the title, description or visibility of a
+// playlist. Omitted fields are left as they are.
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string                      `json:"title"`
+		Description *string                      `json:"description"`
+		Visibility  *database.PlaylistVisibility `json:"visibility"`
+	}
+
+	_, playlist, ok := cfg.playlistRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title != nil {
+		playlist.Title = strings.TrimSpace(*params.Title)
+		if err := validatePlaylistTitle(playlist.Title); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if params.Description != nil {
+		if err := validateVideoDescription(*params.Description); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		playlist.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		if !params.Visibility.Valid() {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid visibility %q", *params.Visibility), nil)
+			return
+		}
+		playlist.Visibility = *params.Visibility
+	}
+
+	if err := cfg.db.UpdatePlaylist(playlist); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+
+	updated, err := cfg.db.GetPlaylist(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// handlerPlaylistDelete deletes a playlist. Its videos are left alone.
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	_, playlist, ok := cfg.playlistRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DeletePlaylist(playlist.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPlaylistVideosList returns the videos of a playlist in order, in the
+// shape of handlerVideoGet. Videos the caller may not watch are left out.
+func (cfg *apiConfig) handlerPlaylistVideosList(w http.ResponseWriter, r *http.Request) {
+	user, playlist, ok := cfg.playlistRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	videos, err := cfg.playlistVideos(*user, playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// handlerPlaylistVideosAdd adds a video the caller can watch to a playlist, at
+// position if it is given and at the end otherwise. It responds with the
+// playlist's videos.
+func (cfg *apiConfig) handlerPlaylistVideosAdd(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID  uuid.UUID `json:"video_id"`
+		Position *int      `json:"position"`
+	}
+
+	user, playlist, ok := cfg.playlistRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Position != nil && *params.Position < 0 {
+		respondWithError(w, http.StatusBadRequest, "Position can't be negative", nil)
+		return
+	}
+	if playlist.VideoCount >= maxPlaylistVideos {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A playlist can have at most %d videos", maxPlaylistVideos), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	added, err := cfg.db.AddPlaylistVideo(playlist.ID, video.ID, params.Position)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video", err)
+		return
+	}
+	if !added {
+		respondWithError(w, http.StatusConflict, "Video is already in the playlist", nil)
+		return
+	}
+
+	videos, err := cfg.playlistVideos(*user, playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+// handlerPlaylistVideosReorder moves the videos of a playlist into the order
+// given, all in one transaction. Videos left out of the order, such as those
+// the caller can no longer watch, keep their order after the others. It
+// responds with the playlist's videos.
+func (cfg *apiConfig) handlerPlaylistVideosReorder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	user, playlist, ok := cfg.playlistRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, id := range params.VideoIDs {
+		if seen[id] {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Video %s is listed twice", id), nil)
+			return
+		}
+		seen[id] = true
+	}
+
+	err = cfg.db.ReorderPlaylist(playlist.ID, params.VideoIDs)
+	if errors.Is(err, database.ErrNotInPlaylist) {
+		respondWithError(w, http.StatusBadRequest, "Every video must be in the playlist", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reorder playlist", err)
+		return
+	}
+
+	videos, err := cfg.playlistVideos(*user, playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+func (cfg *apiConfig) handlerPlaylistVideoRemove(w http.ResponseWriter, r *http.Request) {
+	_, playlist, ok := cfg.playlistRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	removed, err := cfg.db.RemovePlaylistVideo(playlist.ID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "Video is not in the playlist", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
@@ -23,6 +24,10 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
+	if user == nil || user.DisabledAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
@@ -34,6 +39,13 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditRefresh,
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+	})
+
 	respondWithJSON(w, http.StatusOK, response{
 		Token: accessToken,
 	})
@@ -46,11 +58,27 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// look the user up first, the token no longer finds it once revoked
+	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user for refresh token", err)
+		return
+	}
+
 	err = cfg.db.RevokeRefreshToken(refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
 	}
 
+	if user != nil {
+		cfg.audit(r, database.AuditEvent{
+			Action:     auditRevoke,
+			ActorID:    &user.ID,
+			TargetType: "user",
+			TargetID:   user.ID.String(),
+		})
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
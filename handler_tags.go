@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxVideoTags     = 20
+	maxTagLength     = 50
+	defaultTagsLimit = 10
+	maxTagsLimit     = 100
+)
+
+// normalizeTag lowercases a tag name and collapses its whitespace, so that
+// tags differing only in case or spacing are the same tag.
+func normalizeTag(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// normalizeVideoTags normalizes the tags of a video, dropping duplicates, and
+// checks that they are valid.
+func normalizeVideoTags(names []string) ([]string, error) {
+	tags := []string{}
+	for _, name := range names {
+		tag := normalizeTag(name)
+		if tag == "" {
+			return nil, errors.New("Tags can't be empty")
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("Tags can be at most %d characters", maxTagLength)
+		}
+		if strings.Contains(tag, ",") {
+			return nil, errors.New("Tags can't contain commas")
+		}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxVideoTags {
+		return nil, fmt.Errorf("A video can have at most %d tags", maxVideoTags)
+	}
+	return tags, nil
+}
+
+// handlerTagsList suggests tags for autocompletion: the caller's tags starting
+// with ?prefix=, most used first. ?limit= caps how many are returned.
+func (cfg *apiConfig) handlerTagsList(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	userID := user.ID
+
+	limit := defaultTagsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit %q", v), err)
+			return
+		}
+		limit = min(n, maxTagsLimit)
+	}
+
+	tags, err := cfg.db.GetTags(userID, normalizeTag(r.URL.Query().Get("prefix")), limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tags", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tags)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeVideoTags(t *testing.T) {
+	tooMany := make([]string, maxVideoTags+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("a", i+1)
+	}
+
+	tests := []struct {
+		name    string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none", tags: nil, want: []string{}},
+		{name: "case and spacing", tags: []string{"  Go  Lang ", "SQL"}, want: []string{"go lang", "sql"}},
+		{name: "duplicates", tags: []string{"go", "Go", " go"}, want: []string{"go"}},
+		{name: "empty", tags: []string{"go", "   "}, wantErr: true},
+		{name: "comma", tags: []string{"a,b"}, wantErr: true},
+		{name: "too long", tags: []string{strings.Repeat("é", maxTagLength+1)}, wantErr: true},
+		{name: "longest", tags: []string{strings.Repeat("é", maxTagLength)}, want: []string{strings.Repeat("é", maxTagLength)}},
+		{name: "too many", tags: tooMany, wantErr: true},
+		{name: "too many before dropping duplicates", tags: slices.Repeat([]string{"go"}, maxVideoTags+1), want: []string{"go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeVideoTags(tt.tags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeVideoTags error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("normalizeVideoTags = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer            = "Tubely"
+	totpChallengeTTL      = 5 * time.Minute
+	totpRecoveryCodeCount = 10
+)
+
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	existing, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor authentication", err)
+		return
+	}
+	if existing != nil && existing.ConfirmedAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create TOTP secret", err)
+		return
+	}
+	err = cfg.db.StartUserTOTPEnrollment(user.ID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save TOTP secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret: secret,
+		URI:    auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor authentication", err)
+		return
+	}
+	if totp == nil {
+		respondWithError(w, http.StatusBadRequest, "Start enrollment first", nil)
+		return
+	}
+	if totp.ConfirmedAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, params.Code, time.Now(), totp.LastUsedStep)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", nil)
+		return
+	}
+
+	codes, err := auth.MakeRecoveryCodes(totpRecoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+
+	err = cfg.db.ConfirmUserTOTP(user.ID, step, hashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: codes,
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	err = auth.CheckPasswordHash(params.Password, user.Password)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	err = cfg.db.DeleteUserTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// totpEnrolled reports whether the user confirmed a TOTP enrollment, and so
+// must pass a TOTP challenge after signing in with a password or an identity
+// provider.
+func (cfg *apiConfig) totpEnrolled(userID uuid.UUID) (bool, error) {
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		return false, err
+	}
+	return totp != nil && totp.ConfirmedAt != nil, nil
+}
+
+// respondWithTOTPChallenge answers a login with a correct password, or
+// through an identity provider, for a user with two-factor authentication.
+// The challenge token must be exchanged at POST /api/login/totp together with
+// a TOTP or recovery code.
+func (cfg *apiConfig) respondWithTOTPChallenge(w http.ResponseWriter, user database.User) {
+	type response struct {
+		TOTPRequired   bool   `json:"totp_required"`
+		ChallengeToken string `json:"challenge_token"`
+	}
+
+	challenge, err := auth.MakeTypedJWT(auth.TokenTypeTOTPChallenge, user.ID, cfg.jwtSecret, totpChallengeTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		TOTPRequired:   true,
+		ChallengeToken: challenge,
+	})
+}
+
+func (cfg *apiConfig) handlerLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := auth.ValidateTypedJWT(params.ChallengeToken, cfg.jwtSecret, auth.TokenTypeTOTPChallenge)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil || user.DisabledAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge", nil)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor authentication", err)
+		return
+	}
+	if totp == nil || totp.ConfirmedAt == nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge", nil)
+		return
+	}
+
+	ip := cfg.clientIP(r)
+	if wait := cfg.loginGuard.retryAfter(user.Email, ip); wait > 0 {
+		respondTooManyAttempts(w, wait)
+		return
+	}
+
+	var ok bool
+	switch {
+	case params.Code != "":
+		var step int64
+		step, ok = auth.ValidateTOTP(totp.Secret, params.Code, time.Now(), totp.LastUsedStep)
+		if ok {
+			ok, err = cfg.db.UseTOTPStep(userID, step)
+		}
+	case params.RecoveryCode != "":
+		ok, err = cfg.db.UseTOTPRecoveryCode(userID, auth.HashRecoveryCode(params.RecoveryCode))
+	default:
+		respondWithError(w, http.StatusBadRequest, "Code or recovery code is required", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+		return
+	}
+	if !ok {
+		cfg.loginGuard.recordFailure(user.Email, ip)
+		cfg.audit(r, database.AuditEvent{
+			Action:     auditLoginFailed,
+			TargetType: "user",
+			TargetID:   user.ID.String(),
+			Details:    map[string]string{"method": "totp", "email": user.Email},
+		})
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	cfg.loginGuard.recordSuccess(user.Email)
+	cfg.respondWithTokens(w, r, *user, "totp")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestLoginWithTOTP(t *testing.T) {
+	cfg := newTestConfig(t)
+	hash, err := auth.HashPassword("secret")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	cfg.dummyPasswordHash = hash
+	created, err := cfg.db.CreateUser(database.CreateUserParams{Email: "user@example.com", Password: hash})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	user := *created
+
+	// enroll
+	w := serveAs(t, cfg, cfg.handlerTOTPEnroll, user, http.MethodPost, nil, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("enroll: status = %d: %s", w.Code, w.Body)
+	}
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&enrollment); err != nil {
+		t.Fatalf("decoding enrollment: %v", err)
+	}
+	step := auth.TOTPStep(time.Now())
+	code := func(step int64) string {
+		c, err := auth.TOTPCode(enrollment.Secret, step)
+		if err != nil {
+			t.Fatalf("TOTPCode: %v", err)
+		}
+		return c
+	}
+	w = serveAs(t, cfg, cfg.handlerTOTPConfirm, user, http.MethodPost, nil, fmt.Sprintf(`{"code": %q}`, code(step)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm: status = %d: %s", w.Code, w.Body)
+	}
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&confirmation); err != nil {
+		t.Fatalf("decoding recovery codes: %v", err)
+	}
+	if len(confirmation.RecoveryCodes) != totpRecoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(confirmation.RecoveryCodes), totpRecoveryCodeCount)
+	}
+
+	// the password alone only gets a challenge
+	login := func() string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		cfg.handlerLogin(w, httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email": "user@example.com", "password": "secret"}`)))
+		var resp struct {
+			Token          string `json:"token"`
+			TOTPRequired   bool   `json:"totp_required"`
+			ChallengeToken string `json:"challenge_token"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decoding login: %v", err)
+		}
+		if w.Code != http.StatusOK || !resp.TOTPRequired || resp.Token != "" || resp.ChallengeToken == "" {
+			t.Fatalf("login: status %d, %+v", w.Code, resp)
+		}
+		return resp.ChallengeToken
+	}
+	challenge := login()
+
+	tests := []struct {
+		name       string
+		challenge  string
+		body       string
+		wantStatus int
+	}{
+		{name: "recovery code", challenge: challenge, body: `"recovery_code": "` + strings.ToUpper(confirmation.RecoveryCodes[0]) + `"`, wantStatus: http.StatusOK},
+		{name: "recovery code used again", challenge: challenge, body: `"recovery_code": "` + confirmation.RecoveryCodes[0] + `"`, wantStatus: http.StatusUnauthorized},
+		{name: "code of the step used to confirm", challenge: login(), body: `"code": "` + code(step) + `"`, wantStatus: http.StatusUnauthorized},
+		{name: "code of the next step", challenge: challenge, body: `"code": "` + code(step+1) + `"`, wantStatus: http.StatusOK},
+		{name: "access token as challenge", challenge: mustMakeJWT(t, cfg, user), body: `"code": "` + code(step+1) + `"`, wantStatus: http.StatusUnauthorized},
+		{name: "no code", challenge: challenge, body: `"code": ""`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"challenge_token": "` + tt.challenge + `", ` + tt.body + `}`
+			w := httptest.NewRecorder()
+			cfg.handlerLoginTOTP(w, httptest.NewRequest(http.MethodPost, "/api/login/totp", strings.NewReader(body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Token string `json:"token"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decoding tokens: %v", err)
+			}
+			if userID, err := auth.ValidateJWT(resp.Token, cfg.jwtSecret); err != nil || userID != user.ID {
+				t.Errorf("access token for %v, %v, want %v", userID, err, user.ID)
+			}
+		})
+	}
+}
+
+func mustMakeJWT(t *testing.T, cfg *apiConfig, user database.User) string {
+	t.Helper()
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	return token
+}
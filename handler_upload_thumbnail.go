@@ -3,16 +3,17 @@ package main
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
@@ -24,21 +25,36 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	user, err := cfg.authenticatedUser(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	userID := user.ID
+
+	if !cfg.requireVerified(w, userID, actionUploadThumbnail) {
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	// get video metadata from database before writing anything to disk
+	metadata, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		fmt.Fprintln(os.Stderr, "Couldn't get video from database")
+		respondWithError(w, http.StatusBadRequest, "Internal server error", err)
+		return
+	}
+	if !cfg.requireVideoAccess(w, userID, metadata, videoActionEdit) {
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	// the new thumbnail replaces the stored one, so its size doesn't count
+	quota, allowance, ok := cfg.requireStorageQuota(w, r, metadata, metadata.ThumbnailSizeBytes)
+	if !ok {
+		return
+	}
 
 	const maxUploadSize = 10 << 20 // 10MB
+	r.Body = http.MaxBytesReader(w, r.Body, min(maxUploadSize, allowance))
 	r.ParseMultipartForm(maxUploadSize)
 	thumbnailFile, fileHeader, err := r.FormFile("thumbnail")
 	if err != nil {
@@ -71,50 +87,50 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	thumbnailPath := base64.RawURLEncoding.EncodeToString(randBytes) + "." + mediaType
 	thumbnailPath = filepath.Join(cfg.assetsRoot, thumbnailPath)
 	dest, err := os.Create(thumbnailPath)
-	fmt.Println("thumbnail path: ", thumbnailPath)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Couldn't create thumbnail file")
 		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 	defer dest.Close()
-	_, err = io.Copy(dest, thumbnailFile)
+	thumbnailSize, err := io.Copy(dest, thumbnailFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Couldn't copy thumbnail file")
 		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 
-	// get video metadata from database
-	metadata, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Couldn't get video from database")
-		respondWithError(w, http.StatusBadRequest, "Internal server error", err)
+	// store thumbnail in database
+	previousVideo := metadata
+	metadata, err = cfg.db.UpdateVideoThumbnail(videoID, thumbnailPath, thumbnailSize, quota)
+	if errors.Is(err, database.ErrStorageQuotaExceeded) {
+		os.Remove(thumbnailPath)
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Storage quota exceeded", err)
 		return
 	}
-	if metadata.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "You cannot modify this video", err)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Couldn't update video to database")
+		os.Remove(thumbnailPath)
+		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 
-	// remove the old file as we just created a new thumbnail file
-	tmp := *metadata.ThumbnailURL
-	err = os.Remove(tmp)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to remove old thumbnail file: %s", err)
+	// only now that the video points to the new thumbnail can the old file go
+	replaced := previousVideo.ThumbnailURL != nil
+	previousVideo.VideoURL = nil
+	if err := cfg.deleteVideoMedia(r.Context(), previousVideo); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to delete replaced thumbnail of %s: %s\n", videoID, err)
 		// this error doesn't really affect our operation so we don't have to return
 	}
 
-	// store thumbnail in database
-	metadata.ID = videoID
-	metadata.CreatedAt = time.Now()
-	metadata.UpdatedAt = time.Now()
-	metadata.ThumbnailURL = &thumbnailPath
-	if err = cfg.db.UpdateVideo(metadata); err != nil {
-		fmt.Fprintln(os.Stderr, "Couldn't update video to database")
-		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
-		return
-	}
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditThumbnailUpload,
+		ActorID:    &userID,
+		TargetType: "video",
+		TargetID:   videoID.String(),
+		Details:    map[string]string{"replaced": strconv.FormatBool(replaced)},
+	})
+	cfg.emitWebhookEvent(webhookThumbnailUpdated, metadata)
 
 	respondWithJSON(w, http.StatusOK, metadata)
 }
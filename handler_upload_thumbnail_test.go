@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func uploadTestThumbnail(t *testing.T, cfg *apiConfig, user database.User, video database.Video) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="thumbnail"; filename="thumb.png"`)
+	header.Set("Content-Type", "image/png")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	part.Write([]byte("not really a png"))
+	form.Close()
+
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	r := httptest.NewRequest("POST", "/", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.SetPathValue("videoID", video.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerUploadThumbnail(w, r)
+	return w
+}
+
+func TestHandlerUploadThumbnailReplacesFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		failUpdate   bool
+		wantStatus   int
+		wantOldKept  bool
+		wantNewFiles int
+	}{
+		{name: "replaced", wantStatus: http.StatusOK, wantNewFiles: 1},
+		{name: "update fails", failUpdate: true, wantStatus: http.StatusInternalServerError, wantOldKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dbPath := filepath.Join(dir, "test.db")
+			db, err := database.NewClient(dbPath)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			cfg := &apiConfig{db: db, jwtSecret: "test-secret", assetsRoot: filepath.Join(dir, "assets")}
+			if err := os.Mkdir(cfg.assetsRoot, 0o755); err != nil {
+				t.Fatalf("Mkdir: %v", err)
+			}
+			user := newTestUser(t, cfg)
+			video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "video", UserID: user.ID})
+			if err != nil {
+				t.Fatalf("CreateVideo: %v", err)
+			}
+
+			if w := uploadTestThumbnail(t, cfg, user, video); w.Code != http.StatusOK {
+				t.Fatalf("first upload: status = %d: %s", w.Code, w.Body)
+			}
+			video, err = cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatalf("GetVideo: %v", err)
+			}
+			oldPath := *video.ThumbnailURL
+
+			if tt.failUpdate {
+				raw, err := sql.Open("sqlite3", dbPath)
+				if err != nil {
+					t.Fatalf("opening database: %v", err)
+				}
+				defer raw.Close()
+				_, err = raw.Exec(`
+				CREATE TRIGGER fail_thumbnail_update BEFORE UPDATE OF thumbnail_url ON videos
+				BEGIN SELECT RAISE(ABORT, 'thumbnail update failed'); END
+				`)
+				if err != nil {
+					t.Fatalf("creating trigger: %v", err)
+				}
+			}
+
+			if w := uploadTestThumbnail(t, cfg, user, video); w.Code != tt.wantStatus {
+				t.Fatalf("second upload: status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if _, err := os.Stat(oldPath); (err == nil) != tt.wantOldKept {
+				t.Errorf("old thumbnail exists = %v, want %v", err == nil, tt.wantOldKept)
+			}
+			files, err := os.ReadDir(cfg.assetsRoot)
+			if err != nil {
+				t.Fatalf("ReadDir: %v", err)
+			}
+			// the old thumbnail, if kept, plus the new one, if stored
+			wantFiles := tt.wantNewFiles
+			if tt.wantOldKept {
+				wantFiles++
+			}
+			if len(files) != wantFiles {
+				t.Errorf("got %d files in assets, want %d", len(files), wantFiles)
+			}
+		})
+	}
+}
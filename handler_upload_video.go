@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -13,22 +15,51 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
 var DEBUG bool = true
 
-func processVideoForFastStart(filePath string) (string, error) {
+// processVideoForFastStart remuxes the video so that its metadata comes
+// first. onProgress is called with the percentage done, computed from the
+// position ffmpeg reports and the video's duration.
+func processVideoForFastStart(filePath string, duration time.Duration, onProgress func(percent float64)) (string, error) {
 	var processedVideoPath string = filePath + ".processed"
-	var processCommand *exec.Cmd = exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", processedVideoPath)
-	err := processCommand.Run()
+	var processCommand *exec.Cmd = exec.Command("ffmpeg", "-nostats", "-progress", "pipe:1", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", processedVideoPath)
+	progress, err := processCommand.StdoutPipe()
 	if err != nil {
 		return "", err
 	}
+	if err := processCommand.Start(); err != nil {
+		return "", err
+	}
+
+	// -progress writes blocks of key=value lines, each with the position
+	// reached so far as out_time_us
+	scanner := bufio.NewScanner(progress)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "out_time_us=")
+		if !ok || duration <= 0 {
+			continue
+		}
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		percent := float64(time.Duration(us)*time.Microsecond) / float64(duration) * 100
+		onProgress(math.Min(math.Max(percent, 0), 100))
+	}
+
+	if err := processCommand.Wait(); err != nil {
+		return "", err
+	}
 	return processedVideoPath, nil
 }
 
@@ -77,15 +108,14 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 
 	// authenticate user to get a user ID
-	token, err := auth.GetBearerToken(r.Header)
+	user, err := cfg.authenticatedUser(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
+	userID := user.ID
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	if !cfg.requireVerified(w, userID, actionUploadVideo) {
 		return
 	}
 
@@ -97,21 +127,33 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if videoMetadata.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "You are NOT allowed to upload this video", err)
+	if !cfg.requireVideoAccess(w, userID, videoMetadata, videoActionEdit) {
 		return
 	}
 
+	// the new video replaces the stored one, so its size doesn't count
+	quota, allowance, ok := cfg.requireStorageQuota(w, r, videoMetadata, videoMetadata.VideoSizeBytes)
+	if !ok {
+		return
+	}
+
+	// from here on, clients following GET /api/videos/{videoID}/events learn
+	// how the upload went
+	fail := func(code int, msg string, err error) {
+		cfg.videoProgress.publish(videoID, videoProgressEvent{Stage: progressFailed, Error: msg})
+		respondWithError(w, code, msg, err)
+	}
+
 	// parse multipart form to get the uploaded video
 	// first make sure the uploaded file is not too big, since malicious requests could overload the server
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	r.Body = http.MaxBytesReader(w, r.Body, min(maxUploadSize, allowance))
 	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
-		respondWithError(w, http.StatusBadRequest, "File too large", err)
+		fail(http.StatusBadRequest, "File too large", err)
 		return
 	}
 	videoFile, fileHeader, err := r.FormFile("video")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse video form", err)
+		fail(http.StatusBadRequest, "Couldn't parse video form", err)
 		return
 	}
 	defer videoFile.Close()
@@ -119,11 +161,11 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	// validate the uploaded file to ensure it's an MP4 video
 	mediaType, _, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Type"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse media type", err)
+		fail(http.StatusBadRequest, "Couldn't parse media type", err)
 		return
 	}
 	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid media type", err)
+		fail(http.StatusBadRequest, "Invalid media type", err)
 		return
 	}
 
@@ -134,30 +176,49 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	_, err = io.Copy(tempFile, videoFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Couldn't copy video file")
-		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
+		fail(http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 	tempFile.Seek(0, io.SeekStart) // read the file again from the beginning, as we already moved the offset to the end of the file by copying it above
+	cfg.videoProgress.publish(videoID, videoProgressEvent{Stage: progressUploadReceived})
+	cfg.emitWebhookEvent(webhookVideoUploaded, videoMetadata)
 
-	fmt.Println(tempFile.Name())
+	cfg.videoProgress.publish(videoID, videoProgressEvent{Stage: progressProbing})
 	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to get video's aspect ratio: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
+		fail(http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
+	duration, err := getVideoDuration(tempFile.Name())
+	if err != nil {
+		// the video is still usable, only without a known duration
+		fmt.Fprintf(os.Stderr, "failed to get video's duration: %s", err)
+	}
 
 	// process the video with ffmpeg for FastStart
-	processedVideoPath, err := processVideoForFastStart(tempFile.Name())
+	zero := 0.0
+	cfg.videoProgress.publish(videoID, videoProgressEvent{Stage: progressRemuxing, Percent: &zero})
+	processedVideoPath, err := processVideoForFastStart(tempFile.Name(), duration, func(percent float64) {
+		cfg.videoProgress.publish(videoID, videoProgressEvent{Stage: progressRemuxing, Percent: &percent})
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to process video for FastStart: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
+		fail(http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
+	defer os.Remove(processedVideoPath)
 	processedVideo, err := os.Open(processedVideoPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to open processed video: %s", err)
-		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
+		fail(http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+	defer processedVideo.Close()
+	processedVideoInfo, err := processedVideo.Stat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to stat processed video: %s", err)
+		fail(http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 	// then delete the original video
@@ -168,23 +229,23 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 
 	// put the object into S3
+	cfg.videoProgress.publish(videoID, videoProgressEvent{Stage: progressStoring})
 	randBytes := make([]byte, 32)
 	_, err = rand.Read(randBytes)
 	if err != nil {
 		fmt.Println("Couldn't create random bytes for S3 file key")
-		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
+		fail(http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 	s3FileKey := base64.RawURLEncoding.EncodeToString(randBytes)
 	s3FileKey = s3FileKey + ".mp4"
+	orientation := database.OrientationOther
 	if aspectRatio == "16:9" {
-		s3FileKey = "landscape/" + s3FileKey
+		orientation = database.OrientationLandscape
 	} else if aspectRatio == "9:16" {
-		s3FileKey = "portrait/" + s3FileKey
-	} else {
-		s3FileKey = "other/" + s3FileKey
+		orientation = database.OrientationPortrait
 	}
-	fmt.Println(s3FileKey)
+	s3FileKey = string(orientation) + "/" + s3FileKey
 
 	s3PutObjectInput := &s3.PutObjectInput{
 		Bucket:      aws.String(cfg.s3Bucket),
@@ -196,27 +257,58 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 
 	if err != nil {
 		fmt.Println("Couldn't put object into S3")
-		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
+		fail(http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 
 	// update video url in database, get the presigned URL and return it to the client
 	var _url string = cfg.s3CfDistribution + "/" + s3FileKey
-	videoMetadata.VideoURL = &_url
+	previousVideo := videoMetadata
 	//presignedVideo, err := cfg.dbVideoToSignedVideo(videoMetadata)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting presigned URL for video: %s", err)
-		return
-	}
 	//if DEBUG {
 	//	fmt.Printf("Presigned URL from UPLOAD func: %s\n", *presignedVideo.VideoURL)
 	//}
-	if err = cfg.db.UpdateVideo(videoMetadata); err != nil {
+	fileParams := database.UpdateVideoFileParams{
+		VideoURL:    _url,
+		SizeBytes:   processedVideoInfo.Size(),
+		Orientation: orientation,
+		QuotaBytes:  quota,
+	}
+	if duration > 0 {
+		seconds := duration.Seconds()
+		fileParams.DurationSeconds = &seconds
+	}
+	videoMetadata, err = cfg.db.UpdateVideoFile(videoID, fileParams)
+	if errors.Is(err, database.ErrStorageQuotaExceeded) {
+		// another upload took the room left while this one was processed
+		if err := cfg.deleteVideoMedia(r.Context(), database.Video{VideoURL: &_url}); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to delete video over quota of %s: %s\n", videoID, err)
+		}
+		fail(http.StatusRequestEntityTooLarge, "Storage quota exceeded", err)
+		return
+	}
+	if err != nil {
 		fmt.Println("Couldn't update video in database")
-		respondWithError(w, http.StatusInternalServerError, "Internal server error", err)
+		fail(http.StatusInternalServerError, "Internal server error", err)
 		return
 	}
 
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditVideoUpload,
+		ActorID:    &userID,
+		TargetType: "video",
+		TargetID:   videoID.String(),
+		Details:    map[string]string{"key": s3FileKey},
+	})
+	cfg.emitWebhookEvent(webhookVideoProcessed, videoMetadata)
+	cfg.videoProgress.publish(videoID, videoProgressEvent{Stage: progressDone, Video: &videoMetadata})
+
+	// the replaced video file no longer counts against the quota, so it must go
+	previousVideo.ThumbnailURL = nil
+	if err := cfg.deleteVideoMedia(r.Context(), previousVideo); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to delete replaced video of %s: %s\n", videoID, err)
+	}
+
 	respondWithJSON(w, http.StatusOK, videoMetadata)
 }
 
@@ -227,6 +319,29 @@ func gcd(a int, b int) int {
 	return gcd(b, a%b)
 }
 
+// getVideoDuration returns the duration of the video's container.
+func getVideoDuration(filePath string) (time.Duration, error) {
+	command := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_entries", "format=duration", filePath)
+	commandStdout := &bytes.Buffer{}
+	command.Stdout = commandStdout
+	if err := command.Run(); err != nil {
+		return 0, err
+	}
+	result := struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}{}
+	if err := json.Unmarshal(commandStdout.Bytes(), &result); err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(result.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", result.Format.Duration, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
 func getVideoAspectRatio(filePath string) (string, error) {
 	command := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-select_streams", "v:0", "-show_entries", "stream=width,height", filePath)
 	commandStdout := &bytes.Buffer{}
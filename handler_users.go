@@ -2,7 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -26,6 +31,10 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Email and password are required", nil)
 		return
 	}
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", nil)
+		return
+	}
 
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
@@ -42,5 +51,226 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	cfg.sendVerificationEmail(*user)
+
 	respondWithJSON(w, http.StatusCreated, user)
 }
+
+// validEmail reports whether s is a bare email address such as
+// "user@example.com", without a display name or angle brackets.
+func validEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+const maxUserNameLength = 100
+
+func (cfg *apiConfig) handlerUsersMeGet(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+func (cfg *apiConfig) handlerUsersMeUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name *string `json:"name"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Name != nil {
+		name := strings.TrimSpace(*params.Name)
+		if utf8.RuneCountInString(name) > maxUserNameLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Name can be at most %d characters", maxUserNameLength), nil)
+			return
+		}
+		err = cfg.db.UpdateUserName(user.ID, name)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+			return
+		}
+	}
+
+	user, err = cfg.db.GetUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// handlerUsersMePassword changes the password of the caller, who must know
+// the current one. All sessions are signed out.
+func (cfg *apiConfig) handlerUsersMePassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.NewPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "New password is required", nil)
+		return
+	}
+
+	err = auth.CheckPasswordHash(params.OldPassword, user.Password)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+	// signs the user out of all sessions too
+	err = cfg.db.UpdateUserPassword(user.ID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditPasswordChange,
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerUsersMeEmail starts an email change. The new email only replaces the
+// current one once the user opens the verification link sent to it.
+func (cfg *apiConfig) handlerUsersMeEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", nil)
+		return
+	}
+	if params.Email == user.Email {
+		respondWithError(w, http.StatusBadRequest, "This is already your email address", nil)
+		return
+	}
+
+	err = auth.CheckPasswordHash(params.Password, user.Password)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	existing, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check email", err)
+		return
+	}
+	if existing.Email != "" {
+		respondWithError(w, http.StatusConflict, "Email is already in use", nil)
+		return
+	}
+
+	err = cfg.db.SetUserPendingEmail(user.ID, params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	pending := *user
+	pending.Email = params.Email
+	cfg.sendVerificationEmail(pending)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerUsersMeDelete deletes the caller's account along with its videos and
+// their stored media.
+func (cfg *apiConfig) handlerUsersMeDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	err = auth.CheckPasswordHash(params.Password, user.Password)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	err = cfg.deleteUserData(r.Context(), *user)
+	if errors.Is(err, errSoleOrganizationOwner) {
+		respondWithError(w, http.StatusConflict, "Transfer or delete the organizations you own first", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditUserDelete,
+		ActorID:    &user.ID,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Details:    map[string]string{"email": user.Email},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
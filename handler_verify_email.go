@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+)
+
+const emailVerificationTTL = 48 * time.Hour
+
+// sendVerificationEmail mails the user a signed link to verify user.Email,
+// which is either its current or its pending email. Errors are only logged:
+// the user can ask for a new link.
+func (cfg *apiConfig) sendVerificationEmail(user database.User) {
+	token, err := auth.MakeEmailVerificationJWT(user.ID, user.Email, cfg.jwtSecret, emailVerificationTTL)
+	if err != nil {
+		log.Printf("Couldn't create email verification token: %s", err)
+		return
+	}
+
+	link := cfg.publicURL + "/api/verify_email?token=" + url.QueryEscape(token)
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Tubely email address",
+		Body: fmt.Sprintf(
+			"Open this link within %s to verify your email address:\n\n%s\n",
+			emailVerificationTTL, link,
+		),
+	}
+	go func() {
+		if err := cfg.mailer.Send(msg); err != nil {
+			log.Printf("Couldn't send verification email: %s", err)
+		}
+	}()
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	userID, email, err := auth.ValidateEmailVerificationJWT(r.URL.Query().Get("token"), cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification link", err)
+		return
+	}
+
+	ok, err := cfg.db.MarkUserVerified(userID, email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+	if !ok {
+		// the link may be for a requested email change instead
+		existing, err := cfg.db.GetUserByEmail(email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check email", err)
+			return
+		}
+		if existing.Email != "" {
+			respondWithError(w, http.StatusConflict, "Email is already in use", nil)
+			return
+		}
+		ok, err = cfg.db.ConfirmUserPendingEmail(userID, email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't change email", err)
+			return
+		}
+	}
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification link", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+func (cfg *apiConfig) handlerVerifyEmailResend(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	if user.VerifiedAt != nil {
+		respondWithError(w, http.StatusConflict, "Email is already verified", nil)
+		return
+	}
+
+	cfg.sendVerificationEmail(*user)
+
+	w.WriteHeader(http.StatusAccepted)
+}
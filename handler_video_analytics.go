@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxPlaybackSessionIDLength = 100
+	maxRebufferMS              = 10 * 60 * 1000
+	defaultAnalyticsDays       = 30
+	maxAnalyticsDays           = 366
+)
+
+// playbackSummary adds the rates owners care about to playback stats.
+type playbackSummary struct {
+	database.PlaybackStats
+	// CompletionRate is the share of views that were watched to the end
+	CompletionRate      float64 `json:"completion_rate"`
+	AverageWatchSeconds float64 `json:"average_watch_seconds"`
+}
+
+func summarizePlayback(stats database.PlaybackStats) playbackSummary {
+	summary := playbackSummary{PlaybackStats: stats}
+	if stats.Views > 0 {
+		summary.CompletionRate = min(float64(stats.Completions)/float64(stats.Views), 1)
+		summary.AverageWatchSeconds = stats.WatchSeconds / float64(stats.Views)
+	}
+	return summary
+}
+
+// parseAnalyticsRange parses the ?from= and ?to= dates (YYYY-MM-DD, UTC) of
+// an analytics request. They default to the last defaultAnalyticsDays days
+// up to today, and may span at most maxAnalyticsDays days.
+func parseAnalyticsRange(r *http.Request) (from, to time.Time, err error) {
+	q := r.URL.Query()
+	to = time.Now().UTC().Truncate(24 * time.Hour)
+	if v := q.Get("to"); v != "" {
+		to, err = time.Parse(time.DateOnly, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Invalid to date %q", v)
+		}
+	}
+	from = to.AddDate(0, 0, 1-defaultAnalyticsDays)
+	if v := q.Get("from"); v != "" {
+		from, err = time.Parse(time.DateOnly, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("Invalid from date %q", v)
+		}
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, errors.New("The from date can't be after the to date")
+	}
+	if to.Sub(from) >= maxAnalyticsDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("The date range can span at most %d days", maxAnalyticsDays)
+	}
+	return from, to, nil
+}
+
+// handlerVideoBeacon records a playback event reported by the player. A
+// session_id chosen by the player ties together the events of one playback;
+// the first one counts as a view unless the caller viewed the video shortly
+// before. watched_seconds is how much was played since the previous beacon.
+func (cfg *apiConfig) handlerVideoBeacon(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		SessionID      string                     `json:"session_id"`
+		Event          database.PlaybackEventType `json:"event"`
+		Quartile       int                        `json:"quartile"`
+		WatchedSeconds float64                    `json:"watched_seconds"`
+		RebufferMS     int64                      `json:"rebuffer_ms"`
+	}
+
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.SessionID == "" || len(params.SessionID) > maxPlaybackSessionIDLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Session ID must be 1 to %d characters", maxPlaybackSessionIDLength), nil)
+		return
+	}
+	if !params.Event.Valid() {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid event %q", params.Event), nil)
+		return
+	}
+	if params.Event == database.PlaybackEventProgress && params.Quartile != 25 && params.Quartile != 50 && params.Quartile != 75 {
+		respondWithError(w, http.StatusBadRequest, "Progress events need a quartile of 25, 50 or 75", nil)
+		return
+	}
+	if params.WatchedSeconds < 0 {
+		respondWithError(w, http.StatusBadRequest, "Watched seconds can't be negative", nil)
+		return
+	}
+	if params.RebufferMS < 0 || params.RebufferMS > maxRebufferMS {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Rebuffer time must be between 0 and %d ms", maxRebufferMS), nil)
+		return
+	}
+
+	err = cfg.db.RecordPlaybackEvent(database.PlaybackEvent{
+		VideoID:        video.ID,
+		UserID:         user.ID,
+		SessionID:      params.SessionID,
+		Type:           params.Event,
+		Quartile:       params.Quartile,
+		WatchedSeconds: params.WatchedSeconds,
+		RebufferMS:     params.RebufferMS,
+		At:             time.Now(),
+	})
+	if errors.Is(err, database.ErrTooManyPlaybackSessions) {
+		respondWithError(w, http.StatusTooManyRequests, "Too many playback sessions started, try again later", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record playback event", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerVideoAnalytics returns the playback stats of a video between
+// ?from= and ?to=, in total and for each day. Days without playback are
+// included with zero counts. Only those who can edit the video see them.
+func (cfg *apiConfig) handlerVideoAnalytics(w http.ResponseWriter, r *http.Request) {
+	type dailyStats struct {
+		Day string `json:"day"`
+		playbackSummary
+	}
+	type response struct {
+		From   string          `json:"from"`
+		To     string          `json:"to"`
+		Totals playbackSummary `json:"totals"`
+		Days   []dailyStats    `json:"days"`
+	}
+
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionEdit) {
+		return
+	}
+	from, to, err := parseAnalyticsRange(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	stats, err := cfg.db.GetVideoDailyStats(video.ID, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve analytics", err)
+		return
+	}
+
+	byDay := make(map[string]database.PlaybackStats, len(stats))
+	var totals database.PlaybackStats
+	for _, s := range stats {
+		byDay[s.Day] = s.PlaybackStats
+		totals.Add(s.PlaybackStats)
+	}
+	resp := response{
+		From:   from.Format(time.DateOnly),
+		To:     to.Format(time.DateOnly),
+		Totals: summarizePlayback(totals),
+		Days:   []dailyStats{},
+	}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		key := day.Format(time.DateOnly)
+		resp.Days = append(resp.Days, dailyStats{Day: key, playbackSummary: summarizePlayback(byDay[key])})
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerVideosAnalytics returns the playback stats of each of the caller's
+// videos between ?from= and ?to=, most viewed first.
+func (cfg *apiConfig) handlerVideosAnalytics(w http.ResponseWriter, r *http.Request) {
+	type videoStats struct {
+		VideoID uuid.UUID `json:"video_id"`
+		Title   string    `json:"title"`
+		playbackSummary
+	}
+	type response struct {
+		From   string       `json:"from"`
+		To     string       `json:"to"`
+		Videos []videoStats `json:"videos"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	userID := user.ID
+	from, to, err := parseAnalyticsRange(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	summaries, err := cfg.db.GetUserVideoStats(userID, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve analytics", err)
+		return
+	}
+
+	resp := response{
+		From:   from.Format(time.DateOnly),
+		To:     to.Format(time.DateOnly),
+		Videos: make([]videoStats, 0, len(summaries)),
+	}
+	for _, s := range summaries {
+		resp.Videos = append(resp.Videos, videoStats{
+			VideoID:         s.VideoID,
+			Title:           s.Title,
+			playbackSummary: summarizePlayback(s.PlaybackStats),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
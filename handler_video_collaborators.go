@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+// videoRequest authenticates the request and loads the video named by the
+// path. On failure it writes the error response and returns false.
+func (cfg *apiConfig) videoRequest(w http.ResponseWriter, r *http.Request) (*database.User, database.Video, bool) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return nil, database.Video{}, false
+	}
+	video, ok := cfg.pathVideo(w, r)
+	return user, video, ok
+}
+
+// pathVideo loads the video named by the path. On failure it writes the error
+// response and returns false.
+func (cfg *apiConfig) pathVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorsList(w http.ResponseWriter, r *http.Request) {
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionShare) {
+		return
+	}
+
+	collaborators, err := cfg.db.GetVideoCollaborators(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve collaborators", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, collaborators)
+}
+
+// handlerVideoCollaboratorsInvite shares the video with an existing user, or
+// changes the role of a collaborator, and lets the user know by email.
+func (cfg *apiConfig) handlerVideoCollaboratorsInvite(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string                    `json:"email"`
+		Role  database.CollaboratorRole `json:"role"`
+	}
+
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionShare) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+
+	invitee, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if invitee.Email == "" {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if invitee.ID == video.UserID {
+		respondWithError(w, http.StatusBadRequest, "The video's owner can't be a collaborator", nil)
+		return
+	}
+
+	err = cfg.db.SetVideoCollaborator(video.ID, invitee.ID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add collaborator", err)
+		return
+	}
+
+	collaborator, err := cfg.db.GetVideoCollaborator(video.ID, invitee.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get collaborator", err)
+		return
+	}
+
+	msg := mailer.Message{
+		To:      invitee.Email,
+		Subject: fmt.Sprintf("%s shared a video with you on Tubely", user.Email),
+		Body: fmt.Sprintf(
+			"%s added you as %s of the video %q.\n\nOpen Tubely to see it:\n\n%s/app/\n",
+			user.Email, params.Role, video.Title, cfg.publicURL,
+		),
+	}
+	go func() {
+		if err := cfg.mailer.Send(msg); err != nil {
+			log.Printf("Couldn't send collaborator email: %s", err)
+		}
+	}()
+
+	respondWithJSON(w, http.StatusOK, collaborator)
+}
+
+// handlerVideoCollaboratorRemove stops sharing the video with a user. Besides
+// those who may share the video, collaborators can remove themselves.
+func (cfg *apiConfig) handlerVideoCollaboratorRemove(w http.ResponseWriter, r *http.Request) {
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	if userID != user.ID && !cfg.requireVideoAccess(w, user.ID, video, videoActionShare) {
+		return
+	}
+
+	removed, err := cfg.db.RemoveVideoCollaborator(video.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove collaborator", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "Collaborator not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestCanAccessVideoAsCollaborator(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := newTestUser(t, cfg)
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "shared", UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	viewer := newTestUser(t, cfg)
+	editor := newTestUser(t, cfg)
+	stranger := newTestUser(t, cfg)
+	if err := cfg.db.SetVideoCollaborator(video.ID, viewer.ID, database.CollaboratorRoleViewer); err != nil {
+		t.Fatalf("SetVideoCollaborator: %v", err)
+	}
+	if err := cfg.db.SetVideoCollaborator(video.ID, editor.ID, database.CollaboratorRoleEditor); err != nil {
+		t.Fatalf("SetVideoCollaborator: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		user database.User
+		want map[videoAction]bool
+	}{
+		{name: "owner", user: owner, want: map[videoAction]bool{videoActionView: true, videoActionEdit: true, videoActionDelete: true, videoActionShare: true}},
+		{name: "viewer", user: viewer, want: map[videoAction]bool{videoActionView: true}},
+		{name: "editor", user: editor, want: map[videoAction]bool{videoActionView: true, videoActionEdit: true}},
+		{name: "stranger", user: stranger, want: map[videoAction]bool{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, action := range []videoAction{videoActionView, videoActionEdit, videoActionDelete, videoActionShare} {
+				got, err := cfg.canAccessVideo(tt.user, video, action)
+				if err != nil {
+					t.Fatalf("canAccessVideo: %v", err)
+				}
+				if got != tt.want[action] {
+					t.Errorf("%s: got %v, want %v", action, got, tt.want[action])
+				}
+			}
+		})
+	}
+}
+
+func TestVideoCollaboratorsManagement(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.mailer = make(chanMailer, 10)
+	owner := newTestUser(t, cfg)
+	editor := newTestUser(t, cfg)
+	viewer := newTestUser(t, cfg)
+	invitee := newTestUser(t, cfg)
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "shared", UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	if err := cfg.db.SetVideoCollaborator(video.ID, viewer.ID, database.CollaboratorRoleViewer); err != nil {
+		t.Fatalf("SetVideoCollaborator: %v", err)
+	}
+	if err := cfg.db.SetVideoCollaborator(video.ID, editor.ID, database.CollaboratorRoleEditor); err != nil {
+		t.Fatalf("SetVideoCollaborator: %v", err)
+	}
+
+	videoPath := map[string]string{"videoID": video.ID.String()}
+	collaboratorPath := func(user database.User) map[string]string {
+		return map[string]string{"videoID": video.ID.String(), "userID": user.ID.String()}
+	}
+	invite := fmt.Sprintf(`{"email": %q, "role": "editor"}`, invitee.Email)
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		as         database.User
+		method     string
+		pathValues map[string]string
+		body       string
+		wantStatus int
+	}{
+		{name: "collaborator lists", handler: cfg.handlerVideoCollaboratorsList, as: editor, method: http.MethodGet, pathValues: videoPath, wantStatus: http.StatusForbidden},
+		{name: "collaborator invites", handler: cfg.handlerVideoCollaboratorsInvite, as: editor, method: http.MethodPost, pathValues: videoPath, body: invite, wantStatus: http.StatusForbidden},
+		{name: "collaborator removes another", handler: cfg.handlerVideoCollaboratorRemove, as: editor, method: http.MethodDelete, pathValues: collaboratorPath(viewer), wantStatus: http.StatusForbidden},
+		{name: "collaborator removes itself", handler: cfg.handlerVideoCollaboratorRemove, as: viewer, method: http.MethodDelete, pathValues: collaboratorPath(viewer), wantStatus: http.StatusNoContent},
+		{name: "owner invites", handler: cfg.handlerVideoCollaboratorsInvite, as: owner, method: http.MethodPost, pathValues: videoPath, body: invite, wantStatus: http.StatusOK},
+		{name: "owner invites itself", handler: cfg.handlerVideoCollaboratorsInvite, as: owner, method: http.MethodPost, pathValues: videoPath, body: fmt.Sprintf(`{"email": %q, "role": "viewer"}`, owner.Email), wantStatus: http.StatusBadRequest},
+		{name: "owner removes", handler: cfg.handlerVideoCollaboratorRemove, as: owner, method: http.MethodDelete, pathValues: collaboratorPath(editor), wantStatus: http.StatusNoContent},
+		{name: "owner removes a non-collaborator", handler: cfg.handlerVideoCollaboratorRemove, as: owner, method: http.MethodDelete, pathValues: collaboratorPath(editor), wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(t, cfg, tt.handler, tt.as, tt.method, tt.pathValues, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+
+	collaborators, err := cfg.db.GetVideoCollaborators(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideoCollaborators: %v", err)
+	}
+	if len(collaborators) != 1 || collaborators[0].UserID != invitee.ID {
+		t.Errorf("collaborators = %+v, want only %s", collaborators, invitee.ID)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// videoEventsHeartbeat is how often an idle stream gets a comment line,
+	// so that proxies don't close it
+	videoEventsHeartbeat = 15 * time.Second
+	// videoEventsTokenTTL is how long a client has to open the stream with a
+	// token from handlerVideoEventsToken
+	videoEventsTokenTTL = time.Minute
+)
+
+// handlerVideoEventsToken returns a short-lived token for opening the event
+// stream of a video. Browsers' EventSource can't send an Authorization
+// header, so it passes the token as the token query parameter instead. The
+// token is only checked when the stream opens; clients that reconnect later
+// need a new one.
+func (cfg *apiConfig) handlerVideoEventsToken(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Token string `json:"token"`
+	}
+
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	token, err := auth.MakeTypedJWT(auth.TokenTypeVideoEvents, user.ID, cfg.jwtSecret, videoEventsTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response{Token: token})
+}
+
+// videoEventsUser authenticates a request for an event stream, by the token
+// query parameter if there is one and otherwise by the Authorization header.
+// Access tokens aren't accepted in the query, where they would end up in
+// logs.
+func (cfg *apiConfig) videoEventsUser(r *http.Request) (*database.User, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return cfg.authenticatedUser(r)
+	}
+	userID, err := auth.ValidateTypedJWT(token, cfg.jwtSecret, auth.TokenTypeVideoEvents)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.activeUser(userID)
+}
+
+// handlerVideoEvents streams the progress of uploads of a video as
+// Server-Sent Events. Each event is named after its stage and carries a
+// videoProgressEvent as JSON. The stream stays open across uploads until the
+// client disconnects.
+func (cfg *apiConfig) handlerVideoEvents(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.videoEventsUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+		return
+	}
+	video, ok := cfg.pathVideo(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	events, unsubscribe := cfg.videoProgress.subscribe(video.ID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		log.Printf("Couldn't flush event stream: %s", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(videoEventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Couldn't encode progress event: %s", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Stage, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
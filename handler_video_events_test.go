@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestVideoEvents(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.videoProgress = newVideoProgressBroker()
+	owner := newTestUser(t, cfg)
+	stranger := newTestUser(t, cfg)
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "uploading", UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	pathValues := map[string]string{"videoID": video.ID.String()}
+
+	if w := serveAs(t, cfg, cfg.handlerVideoEventsToken, stranger, http.MethodPost, pathValues, ""); w.Code != http.StatusForbidden {
+		t.Errorf("token for another user's video: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w := serveAs(t, cfg, cfg.handlerVideoEventsToken, owner, http.MethodPost, pathValues, "")
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("token: status %d, %v", w.Code, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/videos/{videoID}/events", cfg.handlerVideoEvents)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	streamURL := srv.URL + "/api/videos/" + video.ID.String() + "/events"
+
+	for name, token := range map[string]string{
+		"no token":     "",
+		"access token": mustMakeJWT(t, cfg, owner),
+		"forged token": resp.Token + "x",
+	} {
+		res, err := http.Get(streamURL + "?token=" + token)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want %d", name, res.StatusCode, http.StatusUnauthorized)
+		}
+	}
+
+	res, err := http.Get(streamURL + "?token=" + resp.Token)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	// the headers are flushed after subscribing, so this can't be missed
+	cfg.videoProgress.publish(video.ID, videoProgressEvent{Stage: progressProbing})
+	scanner := bufio.NewScanner(res.Body)
+	var lines []string
+	for len(lines) < 2 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	want := []string{"event: " + progressProbing, `data: {"stage":"probing"}`}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("stream = %q, want %q", lines, want)
+	}
+}
@@ -2,26 +2,75 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
+const (
+	maxVideoTitleLength       = 200
+	maxVideoDescriptionLength = 5000
+)
+
+func validateVideoTitle(title string) error {
+	if title == "" {
+		return errors.New("Title can't be empty")
+	}
+	if utf8.RuneCountInString(title) > maxVideoTitleLength {
+		return fmt.Errorf("Title can be at most %d characters", maxVideoTitleLength)
+	}
+	return nil
+}
+
+func validateVideoDescription(description string) error {
+	if utf8.RuneCountInString(description) > maxVideoDescriptionLength {
+		return fmt.Errorf("Description can be at most %d characters", maxVideoDescriptionLength)
+	}
+	return nil
+}
+
+// videoETag is the entity tag of a video's current version.
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%d"`, video.Version)
+}
+
+// ifMatch reports whether the If-Match header of the request names the
+// video's current version. Weak tags never match, as If-Match uses the strong
+// comparison.
+func ifMatch(r *http.Request, video database.Video) bool {
+	header := r.Header.Get("If-Match")
+	current := videoETag(video)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		database.CreateVideoParams
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
+	user, err := cfg.authenticatedUser(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+	userID := user.ID
+
+	if !cfg.requireVerified(w, userID, actionCreateVideo) {
 		return
 	}
 
@@ -32,17 +81,112 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
 		return
 	}
+	params.Title = strings.TrimSpace(params.Title)
+	if err := validateVideoTitle(params.Title); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err := validateVideoDescription(params.Description); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if params.Tags, err = normalizeVideoTags(params.Tags); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 	params.UserID = userID
+	if params.OrganizationID != nil {
+		if _, ok := cfg.requireOrganizationRole(w, userID, *params.OrganizationID, database.OrganizationRoleEditor); !ok {
+			return
+		}
+	}
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	cfg.emitWebhookEvent(webhookVideoCreated, video)
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
 
+// handlerVideoMetaUpdate applies a partial update to the title, description
+// and tags of a video. Tags replace all of the video's tags. The If-Match
+// header must hold the ETag the client last saw, so that the update fails
+// with 412 if someone else changed the video since instead of silently
+// overwriting their change. Requests without it get 428.
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string   `json:"title"`
+		Description *string   `json:"description"`
+		Tags        *[]string `json:"tags"`
+	}
+
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionEdit) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title != nil {
+		*params.Title = strings.TrimSpace(*params.Title)
+		if err := validateVideoTitle(*params.Title); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if params.Description != nil {
+		if err := validateVideoDescription(*params.Description); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if params.Tags != nil {
+		if *params.Tags, err = normalizeVideoTags(*params.Tags); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	if r.Header.Get("If-Match") == "" {
+		w.Header().Set("ETag", videoETag(video))
+		respondWithError(w, http.StatusPreconditionRequired, "If-Match header is required", nil)
+		return
+	}
+	if !ifMatch(r, video) {
+		w.Header().Set("ETag", videoETag(video))
+		respondWithError(w, http.StatusPreconditionFailed, "Video was changed since it was read", nil)
+		return
+	}
+	updated, err := cfg.db.UpdateVideoMetadata(video.ID, database.UpdateVideoMetadataParams{
+		Title:       params.Title,
+		Description: params.Description,
+		Tags:        params.Tags,
+	}, video.Version)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if updated == nil {
+		// changed or deleted between reading and updating it
+		respondWithError(w, http.StatusPreconditionFailed, "Video was changed since it was read", nil)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(*updated))
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -51,12 +195,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	user, err := cfg.authenticatedUser(r)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -67,8 +206,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionDelete) {
 		return
 	}
 
@@ -78,6 +216,20 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	cfg.audit(r, database.AuditEvent{
+		Action:     auditVideoDelete,
+		ActorID:    &user.ID,
+		TargetType: "video",
+		TargetID:   video.ID.String(),
+		Details:    map[string]string{"title": video.Title, "owner_id": video.UserID.String()},
+	})
+	cfg.emitWebhookEvent(webhookVideoDeleted, video)
+
+	if err := cfg.deleteVideoMedia(r.Context(), video); err != nil {
+		// the video is gone for the user either way, so only log it
+		fmt.Fprintf(os.Stderr, "failed to delete media of video %s: %s\n", video.ID, err)
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -89,11 +241,29 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+
+	videos := []database.Video{video}
+	if err := cfg.setResumePositions(user.ID, videos); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watch progress", err)
+		return
+	}
+	video = videos[0]
 
 	// if a video exists, then it will have an URL. We need an URL to presign
 	if video.VideoURL == nil {
@@ -110,24 +280,157 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, video)
 }
 
+const (
+	defaultVideosLimit = 100
+	maxVideosLimit     = 500
+)
+
+// parseVideoListParams reads the paging, sorting and filtering parameters of
+// GET /api/videos: limit, cursor, sort (created_at, updated_at, title or
+// duration), order (asc or desc), has_video, has_thumbnail, orientation,
+// created_since and created_before (RFC 3339), and tag, which may be repeated
+// to select videos with all of the tags, or with any of them if tag_mode is
+// any.
+func parseVideoListParams(r *http.Request) (database.VideoListParams, error) {
+	q := r.URL.Query()
+	params := database.VideoListParams{
+		Sort:       database.VideoSortCreatedAt,
+		Descending: true,
+		Limit:      defaultVideosLimit,
+		Cursor:     q.Get("cursor"),
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return params, fmt.Errorf("invalid limit %q", v)
+		}
+		params.Limit = min(limit, maxVideosLimit)
+	}
+	if v := q.Get("sort"); v != "" {
+		params.Sort = database.VideoSort(v)
+		if !params.Sort.Valid() {
+			return params, fmt.Errorf("invalid sort %q", v)
+		}
+		// titles read best A to Z, everything else newest or longest first
+		params.Descending = params.Sort != database.VideoSortTitle
+	}
+	switch v := q.Get("order"); v {
+	case "":
+	case "asc":
+		params.Descending = false
+	case "desc":
+		params.Descending = true
+	default:
+		return params, fmt.Errorf("invalid order %q", v)
+	}
+	var err error
+	if params.HasVideo, err = parseOptionalBool(q.Get("has_video")); err != nil {
+		return params, fmt.Errorf("invalid has_video: %w", err)
+	}
+	if params.HasThumbnail, err = parseOptionalBool(q.Get("has_thumbnail")); err != nil {
+		return params, fmt.Errorf("invalid has_thumbnail: %w", err)
+	}
+	if v := q.Get("orientation"); v != "" {
+		params.Orientation = database.Orientation(v)
+		if !params.Orientation.Valid() {
+			return params, fmt.Errorf("invalid orientation %q", v)
+		}
+	}
+	if params.CreatedSince, err = parseOptionalTime(q.Get("created_since")); err != nil {
+		return params, fmt.Errorf("invalid created_since: %w", err)
+	}
+	if params.CreatedBefore, err = parseOptionalTime(q.Get("created_before")); err != nil {
+		return params, fmt.Errorf("invalid created_before: %w", err)
+	}
+	for _, tag := range q["tag"] {
+		if tag = normalizeTag(tag); tag != "" && !slices.Contains(params.Tags, tag) {
+			params.Tags = append(params.Tags, tag)
+		}
+	}
+	switch v := q.Get("tag_mode"); v {
+	case "", "all":
+	case "any":
+		params.AnyTag = true
+	default:
+		return params, fmt.Errorf("invalid tag_mode %q", v)
+	}
+	return params, nil
+}
+
+// parseOptionalBool parses a boolean query parameter, returning nil if it is
+// empty.
+func parseOptionalBool(s string) (*bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// parseOptionalTime parses an RFC 3339 query parameter, returning nil if it
+// is empty.
+func parseOptionalTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// handlerVideosRetrieve returns a page of the caller's personal videos, or of
+// an organization's videos with ?org=. The X-Total-Count header holds how
+// many videos match on all pages, and X-Next-Cursor, unless this is the last
+// page, the cursor of the next one.
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	user, err := cfg.authenticatedUser(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID := user.ID
+
+	params, err := parseVideoListParams(r)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	// ?org= lists the videos of an organization instead of the user's own
+	if org := r.URL.Query().Get("org"); org != "" {
+		organizationID, err := uuid.Parse(org)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+			return
+		}
+		if _, ok := cfg.requireOrganizationRole(w, userID, organizationID, database.OrganizationRoleViewer); !ok {
+			return
+		}
+		params.OrganizationID = &organizationID
+	} else {
+		params.UserID = &userID
+	}
+
+	page, err := cfg.db.ListVideos(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
+	if err := cfg.setResumePositions(userID, page.Videos); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watch progress", err)
+		return
+	}
+
 	// get the presigned URL for each video
 	//for i, video := range videos {
 	//	// if a video exists, then it will have an URL. We need an URL to presign
@@ -141,5 +444,9 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 	//	}
 	//}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	respondWithJSON(w, http.StatusOK, page.Videos)
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestVideoMetaUpdate(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := database.NewClient(dbPath)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	cfg := &apiConfig{db: db, jwtSecret: "test-secret", loginGuard: newLoginGuard()}
+	owner := newTestUser(t, cfg)
+	viewer := newTestUser(t, cfg)
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "before", Description: "kept", UserID: owner.ID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	if err := cfg.db.SetVideoCollaborator(video.ID, viewer.ID, database.CollaboratorRoleViewer); err != nil {
+		t.Fatalf("SetVideoCollaborator: %v", err)
+	}
+	// updated_at only has second precision, so age the video to see it bumped
+	raw, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer raw.Close()
+	if _, err := raw.Exec("UPDATE videos SET updated_at = '2000-01-01 00:00:00' WHERE id = ?", video.ID.String()); err != nil {
+		t.Fatalf("aging video: %v", err)
+	}
+
+	patch := func(as database.User, ifMatch, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+mustMakeJWT(t, cfg, as))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		r.SetPathValue("videoID", video.ID.String())
+		w := httptest.NewRecorder()
+		cfg.handlerVideoMetaUpdate(w, r)
+		return w
+	}
+	current := videoETag(video)
+
+	tests := []struct {
+		name       string
+		as         database.User
+		ifMatch    string
+		body       string
+		wantStatus int
+	}{
+		{name: "viewer", as: viewer, ifMatch: current, body: `{"title": "viewer"}`, wantStatus: http.StatusForbidden},
+		{name: "empty title", as: owner, ifMatch: current, body: `{"title": "  "}`, wantStatus: http.StatusBadRequest},
+		{name: "without If-Match", as: owner, body: `{"title": "blind"}`, wantStatus: http.StatusPreconditionRequired},
+		{name: "stale", as: owner, ifMatch: `"0"`, body: `{"title": "stale"}`, wantStatus: http.StatusPreconditionFailed},
+		{name: "weak", as: owner, ifMatch: "W/" + current, body: `{"title": "weak"}`, wantStatus: http.StatusPreconditionFailed},
+		{name: "current", as: owner, ifMatch: `"0", ` + current, body: `{"title": " after "}`, wantStatus: http.StatusOK},
+		{name: "replayed", as: owner, ifMatch: current, body: `{"title": "replayed"}`, wantStatus: http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := patch(tt.as, tt.ifMatch, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code == http.StatusPreconditionRequired || w.Code == http.StatusPreconditionFailed {
+				if w.Header().Get("ETag") == "" {
+					t.Error("no ETag of the current version")
+				}
+			}
+		})
+	}
+
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if got.Title != "after" || got.Description != "kept" {
+		t.Errorf("title, description = %q, %q, want %q, %q", got.Title, got.Description, "after", "kept")
+	}
+	if got.Version != video.Version+1 {
+		t.Errorf("version = %d, want %d", got.Version, video.Version+1)
+	}
+	if time.Since(got.UpdatedAt) > time.Minute {
+		t.Errorf("updated_at = %s, want it bumped", got.UpdatedAt)
+	}
+
+	w := patch(owner, "*", `{"description": "changed"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("If-Match *: status = %d: %s", w.Code, w.Body)
+	}
+	var updated database.Video
+	if err := json.NewDecoder(w.Body).Decode(&updated); err != nil {
+		t.Fatalf("decoding video: %v", err)
+	}
+	if etag := w.Header().Get("ETag"); etag != videoETag(updated) || updated.Version != got.Version+1 {
+		t.Errorf("ETag %s, version %d, want version %d", etag, updated.Version, got.Version+1)
+	}
+}
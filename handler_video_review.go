@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxReviewNoteLength = 1000
+
+// validateAnnotationSpan checks that an annotation's span starts before it
+// ends and lies within the video, as far as its duration is known.
+func validateAnnotationSpan(video database.Video, start float64, end *float64) error {
+	if err := validateCommentTimestamp(video, start); err != nil {
+		return err
+	}
+	if end != nil {
+		if *end < start {
+			return errors.New("End can't be before the start")
+		}
+		if err := validateCommentTimestamp(video, *end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRegion checks that a region is a non-empty rectangle inside the
+// frame.
+func validateRegion(region database.Region) error {
+	if region.X < 0 || region.Y < 0 || region.Width <= 0 || region.Height <= 0 ||
+		region.X+region.Width > 1 || region.Y+region.Height > 1 {
+		return errors.New("Region must be a rectangle inside the frame, in fractions of its size")
+	}
+	return nil
+}
+
+// decodeNullable decodes a JSON value that may be null into a new value,
+// returning nil for null.
+func decodeNullable[T any](raw json.RawMessage) (*T, error) {
+	if bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// annotationRequest is videoRequest for the annotation named by the path,
+// which must be on the video. The caller must be allowed to view the video.
+func (cfg *apiConfig) annotationRequest(w http.ResponseWriter, r *http.Request) (*database.User, database.Video, database.Annotation, bool) {
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return nil, database.Video{}, database.Annotation{}, false
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return nil, database.Video{}, database.Annotation{}, false
+	}
+
+	annotationID, err := uuid.Parse(r.PathValue("annotationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid annotation ID", err)
+		return nil, database.Video{}, database.Annotation{}, false
+	}
+	annotation, err := cfg.db.GetAnnotation(annotationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get annotation", err)
+		return nil, database.Video{}, database.Annotation{}, false
+	}
+	if annotation == nil || annotation.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Annotation not found", nil)
+		return nil, database.Video{}, database.Annotation{}, false
+	}
+	return user, video, *annotation, true
+}
+
+// handlerAnnotationsList returns the annotations of a video in the order they
+// appear in it. ?resolved=true or false only returns those that are or
+// aren't resolved.
+func (cfg *apiConfig) handlerAnnotationsList(w http.ResponseWriter, r *http.Request) {
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	resolved, err := parseOptionalBool(r.URL.Query().Get("resolved"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resolved", err)
+		return
+	}
+
+	annotations, err := cfg.db.GetAnnotations(video.ID, resolved)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve annotations", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, annotations)
+}
+
+// handlerAnnotationsCreate adds an annotation to a video. Anyone who can view
+// the video can annotate it.
+func (cfg *apiConfig) handlerAnnotationsCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body         string           `json:"body"`
+		StartSeconds float64          `json:"start_seconds"`
+		EndSeconds   *float64         `json:"end_seconds"`
+		Region       *database.Region `json:"region"`
+	}
+
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Body = strings.TrimSpace(params.Body)
+	if err := validateCommentBody(params.Body); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err := validateAnnotationSpan(video, params.StartSeconds, params.EndSeconds); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if params.Region != nil {
+		if err := validateRegion(*params.Region); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	annotation, err := cfg.db.CreateAnnotation(database.CreateAnnotationParams{
+		VideoID:      video.ID,
+		UserID:       user.ID,
+		Body:         params.Body,
+		StartSeconds: params.StartSeconds,
+		EndSeconds:   params.EndSeconds,
+		Region:       params.Region,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create annotation", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, annotation)
+}
+
+// handlerAnnotationUpdate applies a partial update to an annotation. Only its
+// author can change what it says and where it points; end_seconds and region
+// can be set to null to remove them. Whoever can edit the video can also
+// resolve it and reopen it.
+func (cfg *apiConfig) handlerAnnotationUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body         *string         `json:"body"`
+		StartSeconds *float64        `json:"start_seconds"`
+		EndSeconds   json.RawMessage `json:"end_seconds"`
+		Region       json.RawMessage `json:"region"`
+		Resolved     *bool           `json:"resolved"`
+	}
+
+	user, video, annotation, ok := cfg.annotationRequest(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	isAuthor := annotation.UserID == user.ID
+	changesContent := params.Body != nil || params.StartSeconds != nil || params.EndSeconds != nil || params.Region != nil
+	if changesContent && !isAuthor {
+		respondWithError(w, http.StatusForbidden, "You can only edit your own annotations", nil)
+		return
+	}
+	if params.Resolved != nil && !isAuthor {
+		canEdit, err := cfg.canAccessVideo(*user, video, videoActionEdit)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check permissions", err)
+			return
+		}
+		if !canEdit {
+			respondWithError(w, http.StatusForbidden, "You can't resolve this annotation", nil)
+			return
+		}
+	}
+
+	if params.Body != nil {
+		annotation.Body = strings.TrimSpace(*params.Body)
+		if err := validateCommentBody(annotation.Body); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if params.StartSeconds != nil {
+		annotation.StartSeconds = *params.StartSeconds
+	}
+	if params.EndSeconds != nil {
+		if annotation.EndSeconds, err = decodeNullable[float64](params.EndSeconds); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid end_seconds", err)
+			return
+		}
+	}
+	if err := validateAnnotationSpan(video, annotation.StartSeconds, annotation.EndSeconds); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if params.Region != nil {
+		if annotation.Region, err = decodeNullable[database.Region](params.Region); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid region", err)
+			return
+		}
+		if annotation.Region != nil {
+			if err := validateRegion(*annotation.Region); err != nil {
+				respondWithError(w, http.StatusBadRequest, err.Error(), err)
+				return
+			}
+		}
+	}
+
+	if changesContent {
+		if err := cfg.db.UpdateAnnotation(annotation); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update annotation", err)
+			return
+		}
+	}
+	if params.Resolved != nil {
+		var resolvedBy *uuid.UUID
+		if *params.Resolved {
+			resolvedBy = &user.ID
+		}
+		if err := cfg.db.SetAnnotationResolved(annotation.ID, resolvedBy); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update annotation", err)
+			return
+		}
+	}
+
+	updated, err := cfg.db.GetAnnotation(annotation.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get annotation", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// handlerAnnotationDelete deletes an annotation. Authors can delete their own
+// annotations, and whoever can delete the video any annotation on it.
+func (cfg *apiConfig) handlerAnnotationDelete(w http.ResponseWriter, r *http.Request) {
+	user, video, annotation, ok := cfg.annotationRequest(w, r)
+	if !ok {
+		return
+	}
+	if annotation.UserID != user.ID {
+		canDelete, err := cfg.canAccessVideo(*user, video, videoActionDelete)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check permissions", err)
+			return
+		}
+		if !canDelete {
+			respondWithError(w, http.StatusForbidden, "You can't delete this annotation", nil)
+			return
+		}
+	}
+
+	if err := cfg.db.DeleteAnnotation(annotation.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete annotation", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type videoReviewResponse struct {
+	Status database.ReviewStatus `json:"status"`
+	// Next are the statuses the video can move to from Status
+	Next        []database.ReviewStatus     `json:"next"`
+	Transitions []database.ReviewTransition `json:"transitions"`
+}
+
+func (cfg *apiConfig) videoReview(video database.Video) (videoReviewResponse, error) {
+	transitions, err := cfg.db.GetVideoReviewTransitions(video.ID)
+	if err != nil {
+		return videoReviewResponse{}, err
+	}
+	resp := videoReviewResponse{
+		Status:      video.ReviewStatus,
+		Next:        []database.ReviewStatus{},
+		Transitions: transitions,
+	}
+	for _, status := range []database.ReviewStatus{
+		database.ReviewStatusDraft,
+		database.ReviewStatusInReview,
+		database.ReviewStatusChangesRequested,
+		database.ReviewStatusApproved,
+	} {
+		if video.ReviewStatus.CanMoveTo(status) {
+			resp.Next = append(resp.Next, status)
+		}
+	}
+	return resp, nil
+}
+
+// handlerVideoReviewGet returns the review status of a video, the statuses it
+// can move to and every transition it went through.
+func (cfg *apiConfig) handlerVideoReviewGet(w http.ResponseWriter, r *http.Request) {
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	resp, err := cfg.videoReview(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve review", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerVideoReviewUpdate moves a video to another review status, recording
+// who did it and an optional note. Moves the workflow doesn't allow from the
+// current status fail with 409.
+func (cfg *apiConfig) handlerVideoReviewUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Status database.ReviewStatus `json:"status"`
+		Note   string                `json:"note"`
+	}
+
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionEdit) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Status.Valid() {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid status %q", params.Status), nil)
+		return
+	}
+	params.Note = strings.TrimSpace(params.Note)
+	if utf8.RuneCountInString(params.Note) > maxReviewNoteLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Note can be at most %d characters", maxReviewNoteLength), nil)
+		return
+	}
+	if !video.ReviewStatus.CanMoveTo(params.Status) {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("A video can't go from %s to %s", video.ReviewStatus, params.Status), nil)
+		return
+	}
+
+	updated, err := cfg.db.SetVideoReviewStatus(video.ID, video.ReviewStatus, params.Status, user.ID, params.Note)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update review status", err)
+		return
+	}
+	if updated == nil {
+		respondWithError(w, http.StatusConflict, "Review status was changed since it was read", nil)
+		return
+	}
+
+	resp, err := cfg.videoReview(*updated)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve review", err)
+		return
+	}
+	w.Header().Set("ETag", videoETag(*updated))
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerVideoReviewUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		from       database.ReviewStatus
+		role       database.CollaboratorRole
+		body       string
+		wantStatus int
+		wantNext   []database.ReviewStatus
+		wantNote   string
+	}{
+		{
+			name:       "submit draft",
+			from:       database.ReviewStatusDraft,
+			body:       `{"status": "in_review", "note": " ready "}`,
+			wantStatus: http.StatusOK,
+			wantNext:   []database.ReviewStatus{database.ReviewStatusDraft, database.ReviewStatusChangesRequested, database.ReviewStatusApproved},
+			wantNote:   "ready",
+		},
+		{
+			name:       "approve in review",
+			from:       database.ReviewStatusInReview,
+			body:       `{"status": "approved"}`,
+			wantStatus: http.StatusOK,
+			wantNext:   []database.ReviewStatus{database.ReviewStatusDraft, database.ReviewStatusInReview},
+		},
+		{
+			name:       "editor requests changes",
+			from:       database.ReviewStatusInReview,
+			role:       database.CollaboratorRoleEditor,
+			body:       `{"status": "changes_requested"}`,
+			wantStatus: http.StatusOK,
+			wantNext:   []database.ReviewStatus{database.ReviewStatusDraft, database.ReviewStatusInReview},
+		},
+		{name: "approve draft", from: database.ReviewStatusDraft, body: `{"status": "approved"}`, wantStatus: http.StatusConflict},
+		{name: "stay in draft", from: database.ReviewStatusDraft, body: `{"status": "draft"}`, wantStatus: http.StatusConflict},
+		{name: "unknown status", from: database.ReviewStatusDraft, body: `{"status": "published"}`, wantStatus: http.StatusBadRequest},
+		{
+			name:       "note too long",
+			from:       database.ReviewStatusDraft,
+			body:       fmt.Sprintf(`{"status": "in_review", "note": %q}`, strings.Repeat("a", maxReviewNoteLength+1)),
+			wantStatus: http.StatusBadRequest,
+		},
+		{name: "viewer", from: database.ReviewStatusInReview, role: database.CollaboratorRoleViewer, body: `{"status": "approved"}`, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			owner := newTestUser(t, cfg)
+			video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "video", UserID: owner.ID})
+			if err != nil {
+				t.Fatalf("CreateVideo: %v", err)
+			}
+			if tt.from == database.ReviewStatusInReview {
+				if _, err := cfg.db.SetVideoReviewStatus(video.ID, database.ReviewStatusDraft, tt.from, owner.ID, ""); err != nil {
+					t.Fatalf("SetVideoReviewStatus: %v", err)
+				}
+			}
+			caller := owner
+			if tt.role != "" {
+				caller = newTestUser(t, cfg)
+				if err := cfg.db.SetVideoCollaborator(video.ID, caller.ID, tt.role); err != nil {
+					t.Fatalf("SetVideoCollaborator: %v", err)
+				}
+			}
+
+			pathValues := map[string]string{"videoID": video.ID.String()}
+			w := serveAs(t, cfg, cfg.handlerVideoReviewUpdate, caller, http.MethodPut, pathValues, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			w = serveAs(t, cfg, cfg.handlerVideoReviewGet, owner, http.MethodGet, pathValues, "")
+			var resp videoReviewResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decoding review: %v", err)
+			}
+			transitions := 0
+			if tt.from == database.ReviewStatusInReview {
+				transitions++
+			}
+			if tt.wantStatus != http.StatusOK {
+				if resp.Status != tt.from || len(resp.Transitions) != transitions {
+					t.Errorf("rejected move changed the review: %s with %d transitions", resp.Status, len(resp.Transitions))
+				}
+				return
+			}
+			if !slices.Equal(resp.Next, tt.wantNext) {
+				t.Errorf("next = %v, want %v", resp.Next, tt.wantNext)
+			}
+			if len(resp.Transitions) != transitions+1 {
+				t.Fatalf("got %d transitions, want %d", len(resp.Transitions), transitions+1)
+			}
+			last := resp.Transitions[len(resp.Transitions)-1]
+			if last.From != tt.from || last.To != resp.Status || last.UserID != caller.ID || last.Note != tt.wantNote {
+				t.Errorf("last transition = %+v", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultVideoSearchLimit = 20
+	maxVideoSearchLimit     = 100
+)
+
+// highlightHTML escapes text for HTML and wraps the terms the search
+// highlighted in <mark> elements.
+func highlightHTML(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, database.HighlightStart, "<mark>")
+	return strings.ReplaceAll(text, database.HighlightEnd, "</mark>")
+}
+
+// handlerVideosSearch searches the titles, descriptions and tags of the videos
+// the caller can see for ?q=, best matches first. Every word of the query
+// must match, and matches the start of words, so results show up while the
+// query is typed. ?org= only searches an organization's videos, and ?limit=
+// and ?offset= page through the results. The X-Total-Count header holds how
+// many videos match on all pages.
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	userID := user.ID
+
+	q := r.URL.Query()
+	params := database.VideoSearchParams{
+		UserID: userID,
+		Query:  q.Get("q"),
+		Limit:  defaultVideoSearchLimit,
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit %q", v), err)
+			return
+		}
+		params.Limit = min(limit, maxVideoSearchLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid offset %q", v), err)
+			return
+		}
+		params.Offset = offset
+	}
+	if org := q.Get("org"); org != "" {
+		organizationID, err := uuid.Parse(org)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+			return
+		}
+		if _, ok := cfg.requireOrganizationRole(w, userID, organizationID, database.OrganizationRoleViewer); !ok {
+			return
+		}
+		params.OrganizationID = &organizationID
+	}
+
+	results, total, err := cfg.db.SearchVideos(params)
+	if errors.Is(err, database.ErrEmptySearch) {
+		respondWithError(w, http.StatusBadRequest, "Search query is required", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+
+	videos := make([]database.Video, len(results))
+	for i := range results {
+		videos[i] = results[i].Video
+	}
+	if err := cfg.setResumePositions(userID, videos); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watch progress", err)
+		return
+	}
+	for i := range results {
+		results[i].ResumeAt = videos[i].ResumeAt
+		results[i].TitleHighlight = highlightHTML(results[i].TitleHighlight)
+		results[i].Snippet = highlightHTML(results[i].Snippet)
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	respondWithJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultContinueWatchingLimit = 20
+	maxContinueWatchingLimit     = 100
+)
+
+// setResumePositions sets ResumeAt on each of the videos the user left off
+// watching.
+func (cfg *apiConfig) setResumePositions(userID uuid.UUID, videos []database.Video) error {
+	ids := make([]uuid.UUID, 0, len(videos))
+	for _, video := range videos {
+		ids = append(ids, video.ID)
+	}
+	progress, err := cfg.db.GetWatchProgress(userID, ids)
+	if err != nil {
+		return err
+	}
+	for i, video := range videos {
+		if p, ok := progress[video.ID]; ok {
+			videos[i].ResumeAt = p.ResumeAt()
+		}
+	}
+	return nil
+}
+
+// handlerWatchProgressUpdate saves the caller's playback position in a video,
+// which the player reports as it plays. Positions past the end of the video
+// are taken as its end.
+func (cfg *apiConfig) handlerWatchProgressUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		PositionSeconds *float64 `json:"position_seconds"`
+	}
+	type response struct {
+		database.WatchProgress
+		ResumeAt *float64 `json:"resume_at"`
+	}
+
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.requireVideoAccess(w, user.ID, video, videoActionView) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.PositionSeconds == nil {
+		respondWithError(w, http.StatusBadRequest, "Position is required", nil)
+		return
+	}
+	position := *params.PositionSeconds
+	if position < 0 {
+		respondWithError(w, http.StatusBadRequest, "Position can't be negative", nil)
+		return
+	}
+	if video.DurationSeconds != nil {
+		position = min(position, *video.DurationSeconds)
+	}
+
+	progress, err := cfg.db.SaveWatchProgress(user.ID, video.ID, position, video.DurationSeconds)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save watch progress", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{WatchProgress: progress, ResumeAt: progress.ResumeAt()})
+}
+
+// handlerWatchProgressDelete forgets the caller's position in a video, which
+// also takes it off their continue watching list.
+func (cfg *apiConfig) handlerWatchProgressDelete(w http.ResponseWriter, r *http.Request) {
+	user, video, ok := cfg.videoRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DeleteWatchProgress(user.ID, video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete watch progress", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerContinueWatching lists the videos the caller started watching but
+// didn't finish, most recently watched first, with where to resume each.
+// Videos the caller can't view anymore are left out.
+func (cfg *apiConfig) handlerContinueWatching(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	limit := defaultContinueWatchingLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit %q", v), err)
+			return
+		}
+		limit = min(n, maxContinueWatchingLimit)
+	}
+
+	// videos the caller can't view are only filtered out here, so keep
+	// fetching until the page is full or there are no more
+	visible := []database.Video{}
+	for offset := 0; len(visible) < limit; offset += limit {
+		videos, progress, err := cfg.db.GetUnfinishedVideos(user.ID, limit, offset)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+			return
+		}
+		for i, video := range videos {
+			if len(visible) == limit {
+				break
+			}
+			ok, err := cfg.canAccessVideo(*user, video, videoActionView)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't check permissions", err)
+				return
+			}
+			if ok {
+				video.ResumeAt = progress[i].ResumeAt()
+				visible = append(visible, video)
+			}
+		}
+		if len(videos) < limit {
+			break
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, visible)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerContinueWatching(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := newTestUser(t, cfg)
+	other := newTestUser(t, cfg)
+
+	// the user watched three of their own videos, then three videos of
+	// someone else they can't view anymore, most recently
+	var own []database.Video
+	for i, owner := range []database.User{user, user, user, other, other, other} {
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: strconv.Itoa(i), UserID: owner.ID})
+		if err != nil {
+			t.Fatalf("CreateVideo: %v", err)
+		}
+		if owner.ID == user.ID {
+			own = append(own, video)
+		}
+		if _, err := cfg.db.SaveWatchProgress(user.ID, video.ID, float64(10+i), nil); err != nil {
+			t.Fatalf("SaveWatchProgress: %v", err)
+		}
+		// progress is ordered by when it was saved
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		limit int
+		want  []database.Video
+	}{
+		{limit: 1, want: own[2:]},
+		{limit: 2, want: []database.Video{own[2], own[1]}},
+		{limit: 3, want: []database.Video{own[2], own[1], own[0]}},
+		{limit: 10, want: []database.Video{own[2], own[1], own[0]}},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.limit), func(t *testing.T) {
+			w := serveAs(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+				r.URL.RawQuery = "limit=" + strconv.Itoa(tt.limit)
+				cfg.handlerContinueWatching(w, r)
+			}, user, "GET", nil, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			var got []database.Video
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d videos, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i].ID {
+					t.Errorf("video %d = %q, want %q", i, got[i].Title, tt.want[i].Title)
+				}
+				if got[i].ResumeAt == nil {
+					t.Errorf("video %d has no resume position", i)
+				}
+			}
+		})
+	}
+}
+
+func TestVideoJSONResumeAt(t *testing.T) {
+	position := 42.0
+	tests := []struct {
+		name     string
+		resumeAt *float64
+		want     string
+	}{
+		{name: "nothing to resume", want: "null"},
+		{name: "resumable", resumeAt: &position, want: "42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(database.Video{ResumeAt: tt.resumeAt})
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			got, ok := fields["resume_at"]
+			if !ok {
+				t.Fatalf("resume_at is missing from %s", data)
+			}
+			if string(got) != tt.want {
+				t.Errorf("resume_at = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const webAuthnSessionTTL = 5 * time.Minute
+
+// webAuthnUser adapts a database.User and its passkeys to webauthn.User. The
+// user handle is the raw user ID.
+type webAuthnUser struct {
+	user        database.User
+	credentials []webauthn.Credential
+}
+
+func (u webAuthnUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (cfg *apiConfig) loadWebAuthnUser(user database.User) (webAuthnUser, error) {
+	stored, err := cfg.db.GetWebAuthnCredentials(user.ID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	u := webAuthnUser{user: user}
+	for _, s := range stored {
+		var cred webauthn.Credential
+		if err := json.Unmarshal(s.Data, &cred); err != nil {
+			return webAuthnUser{}, err
+		}
+		u.credentials = append(u.credentials, cred)
+	}
+	return u, nil
+}
+
+// saveWebAuthnSession stores the ceremony state and returns its ID, which the
+// client sends back with the authenticator's response.
+func (cfg *apiConfig) saveWebAuthnSession(userID *uuid.UUID, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	id, err := auth.MakeOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+	err = cfg.db.CreateWebAuthnSession(database.WebAuthnSession{
+		ID:        id,
+		UserID:    userID,
+		Data:      data,
+		ExpiresAt: time.Now().UTC().Add(webAuthnSessionTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (cfg *apiConfig) loadWebAuthnSession(id string) (database.WebAuthnSession, webauthn.SessionData, error) {
+	stored, err := cfg.db.UseWebAuthnSession(id)
+	if err != nil {
+		return database.WebAuthnSession{}, webauthn.SessionData{}, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(stored.Data, &session); err != nil {
+		return database.WebAuthnSession{}, webauthn.SessionData{}, err
+	}
+	return stored, session, nil
+}
+
+func (cfg *apiConfig) handlerWebAuthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		SessionID string `json:"session_id"`
+		Options   any    `json:"options"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	waUser, err := cfg.loadWebAuthnUser(*user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load passkeys", err)
+		return
+	}
+
+	options, session, err := cfg.webAuthn.BeginRegistration(
+		waUser,
+		webauthn.WithExclusions(webauthn.Credentials(waUser.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start passkey registration", err)
+		return
+	}
+
+	sessionID, err := cfg.saveWebAuthnSession(&user.ID, session)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save passkey registration", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		SessionID: sessionID,
+		Options:   options,
+	})
+}
+
+// handlerWebAuthnRegisterFinish expects the authenticator's attestation
+// response as the request body and the session ID (and an optional name for
+// the passkey) as query parameters.
+func (cfg *apiConfig) handlerWebAuthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	stored, session, err := cfg.loadWebAuthnSession(r.URL.Query().Get("session_id"))
+	if errors.Is(err, database.ErrInvalidWebAuthnSession) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired session", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load session", err)
+		return
+	}
+	if stored.UserID == nil || *stored.UserID != user.ID {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired session", nil)
+		return
+	}
+
+	waUser, err := cfg.loadWebAuthnUser(*user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load passkeys", err)
+		return
+	}
+
+	credential, err := cfg.webAuthn.FinishRegistration(waUser, session, r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't verify passkey", err)
+		return
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save passkey", err)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Passkey"
+	}
+	err = cfg.db.CreateWebAuthnCredential(database.WebAuthnCredential{
+		ID:     base64.RawURLEncoding.EncodeToString(credential.ID),
+		UserID: user.ID,
+		Name:   name,
+		Data:   data,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save passkey", err)
+		return
+	}
+
+	creds, err := cfg.db.GetWebAuthnCredentials(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load passkeys", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, creds)
+}
+
+func (cfg *apiConfig) handlerWebAuthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		SessionID string `json:"session_id"`
+		Options   any    `json:"options"`
+	}
+
+	options, session, err := cfg.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start passkey login", err)
+		return
+	}
+
+	sessionID, err := cfg.saveWebAuthnSession(nil, session)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save passkey login", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		SessionID: sessionID,
+		Options:   options,
+	})
+}
+
+// handlerWebAuthnLoginFinish expects the authenticator's assertion response as
+// the request body and the session ID as a query parameter. On success it
+// answers like handlerLogin.
+func (cfg *apiConfig) handlerWebAuthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	_, session, err := cfg.loadWebAuthnSession(r.URL.Query().Get("session_id"))
+	if errors.Is(err, database.ErrInvalidWebAuthnSession) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired session", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load session", err)
+		return
+	}
+
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		user, err := cfg.db.GetUser(userID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, errors.New("user not found")
+		}
+		return cfg.loadWebAuthnUser(*user)
+	}
+
+	waUser, credential, err := cfg.webAuthn.FinishPasskeyLogin(findUser, session, r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't verify passkey", err)
+		return
+	}
+	user := waUser.(webAuthnUser).user
+
+	// disabled accounts don't get to use their passkeys, not even to bump
+	// their sign counts
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "This account has been disabled", nil)
+		return
+	}
+
+	if credential.Authenticator.CloneWarning {
+		respondWithError(w, http.StatusUnauthorized, "Passkey may have been cloned", nil)
+		return
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update passkey", err)
+		return
+	}
+	err = cfg.db.UpdateWebAuthnCredential(base64.RawURLEncoding.EncodeToString(credential.ID), data)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update passkey", err)
+		return
+	}
+
+	cfg.loginGuard.recordSuccess(user.Email)
+	cfg.respondWithTokens(w, r, user, "passkey")
+}
+
+func (cfg *apiConfig) handlerWebAuthnCredentialsList(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	creds, err := cfg.db.GetWebAuthnCredentials(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load passkeys", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, creds)
+}
+
+func (cfg *apiConfig) handlerWebAuthnCredentialDelete(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	ok, err := cfg.db.DeleteWebAuthnCredential(user.ID, r.PathValue("credentialID"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete passkey", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Passkey not found", nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testWebAuthnRPID   = "localhost"
+	testWebAuthnOrigin = "http://localhost:8091"
+)
+
+// softwareAuthenticator is a passkey authenticator backed by an in-memory
+// P-256 key. It creates "none" attestations and signs assertions for
+// whatever origin it is told the browser is on.
+type softwareAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softwareAuthenticator{key: key, credentialID: id}
+}
+
+func (a *softwareAuthenticator) clientData(t *testing.T, typ, challenge, origin string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": origin})
+	if err != nil {
+		t.Fatalf("marshaling client data: %v", err)
+	}
+	return data
+}
+
+// authenticatorData returns the authenticator data for rpID with the user
+// present and verified flags set, followed by extra.
+func (a *softwareAuthenticator) authenticatorData(rpID string, flags byte, extra []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags|0x01|0x04)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, extra...)
+}
+
+// create answers navigator.credentials.create for the challenge.
+func (a *softwareAuthenticator) create(t *testing.T, challenge, origin string, userHandle []byte) []byte {
+	t.Helper()
+	a.userHandle = userHandle
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("marshaling public key: %v", err)
+	}
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(testWebAuthnRPID, 0x40, attested),
+	})
+	if err != nil {
+		t.Fatalf("marshaling attestation: %v", err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    b64(a.clientData(t, "webauthn.create", challenge, origin)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// get answers navigator.credentials.get for the challenge.
+func (a *softwareAuthenticator) get(t *testing.T, challenge, origin string) []byte {
+	t.Helper()
+	clientData := a.clientData(t, "webauthn.get", challenge, origin)
+	authData := a.authenticatorData(testWebAuthnRPID, 0, nil)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("signing assertion: %v", err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softwareAuthenticator) credential(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatalf("marshaling credential: %v", err)
+	}
+	return data
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newWebAuthnTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	wa, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: "Tubely",
+		RPID:          testWebAuthnRPID,
+		RPOrigins:     []string{testWebAuthnOrigin},
+	})
+	if err != nil {
+		t.Fatalf("webauthn.New: %v", err)
+	}
+	return &apiConfig{
+		db:         db,
+		jwtSecret:  "test-secret",
+		loginGuard: newLoginGuard(),
+		webAuthn:   wa,
+	}
+}
+
+// beginWebAuthn calls a begin handler and returns the session ID and the
+// challenge it issued.
+func beginWebAuthn(t *testing.T, handler http.HandlerFunc, token string) (string, string) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin: status = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		SessionID string `json:"session_id"`
+		Options   struct {
+			PublicKey struct {
+				Challenge string `json:"challenge"`
+			} `json:"publicKey"`
+		} `json:"options"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding begin response: %v", err)
+	}
+	return resp.SessionID, resp.Options.PublicKey.Challenge
+}
+
+func finishWebAuthn(handler http.HandlerFunc, token, sessionID string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/?session_id="+sessionID, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// registerPasskey creates a user and registers the authenticator as their
+// passkey.
+func registerPasskey(t *testing.T, cfg *apiConfig, authenticator *softwareAuthenticator) database.User {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "user@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+
+	sessionID, challenge := beginWebAuthn(t, cfg.handlerWebAuthnRegisterBegin, token)
+	body := authenticator.create(t, challenge, testWebAuthnOrigin, user.ID[:])
+	w := finishWebAuthn(cfg.handlerWebAuthnRegisterFinish, token, sessionID, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register finish: status = %d: %s", w.Code, w.Body)
+	}
+	return *user
+}
+
+func storedSignCount(t *testing.T, cfg *apiConfig, user database.User) uint32 {
+	t.Helper()
+	stored, err := cfg.db.GetWebAuthnCredentials(user.ID)
+	if err != nil {
+		t.Fatalf("GetWebAuthnCredentials: %v", err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("got %d passkeys, want 1", len(stored))
+	}
+	var cred webauthn.Credential
+	if err := json.Unmarshal(stored[0].Data, &cred); err != nil {
+		t.Fatalf("decoding passkey: %v", err)
+	}
+	return cred.Authenticator.SignCount
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	tests := []struct {
+		name       string
+		origin     string
+		wantStatus int
+	}{
+		{name: "valid", origin: testWebAuthnOrigin, wantStatus: http.StatusCreated},
+		{name: "wrong origin", origin: "https://evil.example.com", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newWebAuthnTestConfig(t)
+			user, err := cfg.db.CreateUser(database.CreateUserParams{Email: "user@example.com", Password: "hash"})
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+			if err != nil {
+				t.Fatalf("MakeJWT: %v", err)
+			}
+
+			sessionID, challenge := beginWebAuthn(t, cfg.handlerWebAuthnRegisterBegin, token)
+			body := newSoftwareAuthenticator(t).create(t, challenge, tt.origin, user.ID[:])
+			w := finishWebAuthn(cfg.handlerWebAuthnRegisterFinish, token, sessionID, body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			stored, err := cfg.db.GetWebAuthnCredentials(user.ID)
+			if err != nil {
+				t.Fatalf("GetWebAuthnCredentials: %v", err)
+			}
+			wantStored := 0
+			if tt.wantStatus == http.StatusCreated {
+				wantStored = 1
+			}
+			if len(stored) != wantStored {
+				t.Errorf("got %d passkeys, want %d", len(stored), wantStored)
+			}
+		})
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	tests := []struct {
+		name          string
+		origin        string
+		signCount     uint32
+		disabled      bool
+		wantStatus    int
+		wantSignCount uint32
+	}{
+		{name: "valid", origin: testWebAuthnOrigin, signCount: 5, wantStatus: http.StatusOK, wantSignCount: 5},
+		{name: "wrong origin", origin: "https://evil.example.com", signCount: 5, wantStatus: http.StatusUnauthorized, wantSignCount: 1},
+		{name: "sign count went back", origin: testWebAuthnOrigin, signCount: 1, wantStatus: http.StatusUnauthorized, wantSignCount: 1},
+		{name: "disabled user", origin: testWebAuthnOrigin, signCount: 5, disabled: true, wantStatus: http.StatusForbidden, wantSignCount: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newWebAuthnTestConfig(t)
+			authenticator := newSoftwareAuthenticator(t)
+			authenticator.signCount = 1
+			user := registerPasskey(t, cfg, authenticator)
+			if tt.disabled {
+				if err := cfg.db.SetUserDisabled(user.ID, true); err != nil {
+					t.Fatalf("SetUserDisabled: %v", err)
+				}
+			}
+
+			authenticator.signCount = tt.signCount
+			sessionID, challenge := beginWebAuthn(t, cfg.handlerWebAuthnLoginBegin, "")
+			body := authenticator.get(t, challenge, tt.origin)
+			w := finishWebAuthn(cfg.handlerWebAuthnLoginFinish, "", sessionID, body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := storedSignCount(t, cfg, user); got != tt.wantSignCount {
+				t.Errorf("stored sign count = %d, want %d", got, tt.wantSignCount)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				// the same assertion can't be used twice
+				w := finishWebAuthn(cfg.handlerWebAuthnLoginFinish, "", sessionID, body)
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("replayed login: status = %d, want %d", w.Code, http.StatusBadRequest)
+				}
+			}
+		})
+	}
+}
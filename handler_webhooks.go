@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultWebhookDeliveriesLimit = 50
+	maxWebhookDeliveriesLimit     = 500
+)
+
+// validateWebhookURL checks that a webhook URL is an absolute http(s) URL.
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("URL must use http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL must have a host")
+	}
+	return nil
+}
+
+// validateWebhookEvents checks that events is a non-empty list of known
+// events.
+func validateWebhookEvents(events []string) error {
+	if len(events) == 0 {
+		return fmt.Errorf("at least one event is required")
+	}
+	for _, event := range events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+func makeWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// webhookRequest authenticates the request and loads the caller's webhook
+// named by the path. On failure it writes the error response and returns
+// false.
+func (cfg *apiConfig) webhookRequest(w http.ResponseWriter, r *http.Request) (database.Webhook, bool) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Webhook{}, false
+	}
+
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID", err)
+		return database.Webhook{}, false
+	}
+
+	webhook, err := cfg.db.GetWebhook(webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook", err)
+		return database.Webhook{}, false
+	}
+	if webhook == nil || webhook.UserID != user.ID {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return database.Webhook{}, false
+	}
+	return *webhook, true
+}
+
+// webhookDeliveryRequest is webhookRequest for the delivery named by the
+// path.
+func (cfg *apiConfig) webhookDeliveryRequest(w http.ResponseWriter, r *http.Request) (database.Webhook, database.WebhookDelivery, bool) {
+	webhook, ok := cfg.webhookRequest(w, r)
+	if !ok {
+		return database.Webhook{}, database.WebhookDelivery{}, false
+	}
+
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID", err)
+		return database.Webhook{}, database.WebhookDelivery{}, false
+	}
+
+	delivery, err := cfg.db.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get delivery", err)
+		return database.Webhook{}, database.WebhookDelivery{}, false
+	}
+	if delivery == nil || delivery.WebhookID != webhook.ID {
+		respondWithError(w, http.StatusNotFound, "Delivery not found", nil)
+		return database.Webhook{}, database.WebhookDelivery{}, false
+	}
+	return webhook, *delivery, true
+}
+
+// handlerWebhooksCreate registers a webhook for events on the caller's
+// videos. The response holds the signing secret, which is never shown again.
+func (cfg *apiConfig) handlerWebhooksCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	type response struct {
+		database.Webhook
+		Secret string `json:"secret"`
+	}
+
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateWebhookURL(params.URL); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid URL: "+err.Error(), err)
+		return
+	}
+	if err := validateWebhookEvents(params.Events); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid events: "+err.Error(), err)
+		return
+	}
+
+	secret, err := makeWebhookSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook secret", err)
+		return
+	}
+
+	webhook, err := cfg.db.CreateWebhook(database.CreateWebhookParams{
+		UserID: user.ID,
+		URL:    params.URL,
+		Secret: secret,
+		Events: params.Events,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{Webhook: webhook, Secret: secret})
+}
+
+func (cfg *apiConfig) handlerWebhooksList(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	webhooks, err := cfg.db.GetWebhooks(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhooks)
+}
+
+func (cfg *apiConfig) handlerWebhookGet(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.webhookRequest(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhook)
+}
+
+// handlerWebhookUpdate changes the URL, events or active flag of a webhook.
+// Omitted fields are left as they are. Pending deliveries of an inactive
+// webhook wait until it is active again.
+func (cfg *apiConfig) handlerWebhookUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    *string   `json:"url"`
+		Events *[]string `json:"events"`
+		Active *bool     `json:"active"`
+	}
+
+	webhook, ok := cfg.webhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.URL != nil {
+		if err := validateWebhookURL(*params.URL); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid URL: "+err.Error(), err)
+			return
+		}
+		webhook.URL = *params.URL
+	}
+	if params.Events != nil {
+		if err := validateWebhookEvents(*params.Events); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid events: "+err.Error(), err)
+			return
+		}
+		webhook.Events = *params.Events
+	}
+	if params.Active != nil {
+		webhook.Active = *params.Active
+	}
+
+	if err := cfg.db.UpdateWebhook(webhook); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update webhook", err)
+		return
+	}
+	if webhook.Active {
+		// deliveries held back while the webhook was inactive are due now
+		cfg.webhooks.notify()
+	}
+
+	updated, err := cfg.db.GetWebhook(webhook.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+func (cfg *apiConfig) handlerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.webhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DeleteWebhook(webhook.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type webhookDeliveryResponse struct {
+	database.WebhookDelivery
+	Payload    json.RawMessage                   `json:"payload"`
+	AttemptLog []database.WebhookDeliveryAttempt `json:"attempt_log,omitempty"`
+}
+
+// handlerWebhookDeliveriesList returns the latest deliveries of a webhook,
+// newest first. ?limit= caps how many are returned.
+func (cfg *apiConfig) handlerWebhookDeliveriesList(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.webhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultWebhookDeliveriesLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit %q", v), err)
+			return
+		}
+		limit = min(n, maxWebhookDeliveriesLimit)
+	}
+
+	deliveries, err := cfg.db.GetWebhookDeliveries(webhook.ID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve deliveries", err)
+		return
+	}
+
+	resp := make([]webhookDeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		resp = append(resp, webhookDeliveryResponse{WebhookDelivery: delivery, Payload: delivery.Payload})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerWebhookDeliveryGet returns a delivery together with the log of every
+// attempt made to send it.
+func (cfg *apiConfig) handlerWebhookDeliveryGet(w http.ResponseWriter, r *http.Request) {
+	_, delivery, ok := cfg.webhookDeliveryRequest(w, r)
+	if !ok {
+		return
+	}
+
+	attempts, err := cfg.db.GetWebhookDeliveryAttempts(delivery.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve delivery attempts", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookDeliveryResponse{
+		WebhookDelivery: delivery,
+		Payload:         delivery.Payload,
+		AttemptLog:      attempts,
+	})
+}
+
+// handlerWebhookDeliveryRedeliver queues the payload of a past delivery again,
+// as a new delivery with its own attempts. The payload is sent unchanged, so
+// receivers can recognize the event by its ID.
+func (cfg *apiConfig) handlerWebhookDeliveryRedeliver(w http.ResponseWriter, r *http.Request) {
+	webhook, delivery, ok := cfg.webhookDeliveryRequest(w, r)
+	if !ok {
+		return
+	}
+
+	redelivery, err := cfg.db.CreateWebhookDelivery(webhook.ID, delivery.Event, delivery.Payload)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue delivery", err)
+		return
+	}
+	cfg.webhooks.notify()
+
+	respondWithJSON(w, http.StatusAccepted, webhookDeliveryResponse{WebhookDelivery: redelivery, Payload: redelivery.Payload})
+}
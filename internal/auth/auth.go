@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -17,9 +18,18 @@ import (
 type TokenType string
 
 const (
-	TokenTypeAccess TokenType = "tubely-access"
+	TokenTypeAccess            TokenType = "tubely-access"
+	TokenTypeEmailVerification TokenType = "tubely-email-verification"
+	TokenTypeTOTPChallenge     TokenType = "tubely-totp-challenge"
+	TokenTypeDataExport        TokenType = "tubely-data-export"
+	TokenTypeVideoEvents       TokenType = "tubely-video-events"
 )
 
+type emailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
 func HashPassword(password string) (string, error) {
@@ -38,10 +48,21 @@ func MakeJWT(
 	userID uuid.UUID,
 	tokenSecret string,
 	expiresIn time.Duration,
+) (string, error) {
+	return MakeTypedJWT(TokenTypeAccess, userID, tokenSecret, expiresIn)
+}
+
+// MakeTypedJWT signs a token for userID whose issuer is tokenType, so that e.g.
+// a login challenge token can't be used as an access token.
+func MakeTypedJWT(
+	tokenType TokenType,
+	userID uuid.UUID,
+	tokenSecret string,
+	expiresIn time.Duration,
 ) (string, error) {
 	signingKey := []byte(tokenSecret)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
+		Issuer:    string(tokenType),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
@@ -50,6 +71,10 @@ func MakeJWT(
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return ValidateTypedJWT(tokenString, tokenSecret, TokenTypeAccess)
+}
+
+func ValidateTypedJWT(tokenString, tokenSecret string, tokenType TokenType) (uuid.UUID, error) {
 	claimsStruct := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
@@ -69,7 +94,7 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
+	if issuer != string(tokenType) {
 		return uuid.Nil, errors.New("invalid issuer")
 	}
 
@@ -80,6 +105,49 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	return id, nil
 }
 
+// MakeEmailVerificationJWT returns a signed token proving that whoever holds it
+// received mail at email. It is only valid while the user still has that email.
+func MakeEmailVerificationJWT(
+	userID uuid.UUID,
+	email string,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	signingKey := []byte(tokenSecret)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, emailVerificationClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeEmailVerification),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+	})
+	return token.SignedString(signingKey)
+}
+
+func ValidateEmailVerificationJWT(tokenString, tokenSecret string) (uuid.UUID, string, error) {
+	claims := emailVerificationClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+	)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if claims.Issuer != string(TokenTypeEmailVerification) {
+		return uuid.Nil, "", errors.New("invalid issuer")
+	}
+
+	id, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("invalid user ID: %w", err)
+	}
+	return id, claims.Email, nil
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
@@ -102,6 +170,18 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// MakeOneTimeToken returns a random token meant to be sent to the user once,
+// e.g. in a password reset email. Only its hash should be stored.
+func MakeOneTimeToken() (string, error) {
+	return MakeRefreshToken()
+}
+
+// HashOneTimeToken returns the value to store and look up for a one-time token.
+func HashOneTimeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
@@ -114,3 +194,40 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// userCodeAlphabet has no vowels, to avoid spelling words, and no easily
+// confused characters (RFC 8628 section 6.1).
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// MakeDeviceUserCode returns a short code such as "WDJB-MJHT" for the user to
+// type when approving a device.
+func MakeDeviceUserCode() (string, error) {
+	code := make([]byte, 0, 9)
+	b := make([]byte, 1)
+	for len(code) < 9 {
+		if len(code) == 4 {
+			code = append(code, '-')
+			continue
+		}
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		// reject bytes past the last full multiple of the alphabet size so
+		// every character is equally likely
+		if int(b[0]) >= 256-256%len(userCodeAlphabet) {
+			continue
+		}
+		code = append(code, userCodeAlphabet[int(b[0])%len(userCodeAlphabet)])
+	}
+	return string(code), nil
+}
+
+// NormalizeDeviceUserCode accepts user codes typed in lower case or without
+// the dash.
+func NormalizeDeviceUserCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 Appendix B, SHA-1, cut down from 8 to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	// secrets are accepted in lower case too
+	if got, err := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", TOTPStep(time.Unix(59, 0))); err != nil || got != "287082" {
+		t.Errorf("lower case secret: TOTPCode = %q, %v", got, err)
+	}
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Errorf("TOTPCode accepted an invalid secret")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPStep(now)
+	code := func(step int64) string {
+		c, err := TOTPCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatalf("TOTPCode: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name      string
+		code      string
+		notBefore int64
+		wantStep  int64
+		wantOK    bool
+	}{
+		{name: "current step", code: code(current), wantStep: current, wantOK: true},
+		{name: "previous step", code: code(current - 1), wantStep: current - 1, wantOK: true},
+		{name: "next step", code: code(current + 1), wantStep: current + 1, wantOK: true},
+		{name: "two steps ago", code: code(current - 2)},
+		{name: "two steps ahead", code: code(current + 2)},
+		{name: "surrounding spaces", code: " " + code(current) + " ", wantStep: current, wantOK: true},
+		{name: "step already used", code: code(current), notBefore: current},
+		{name: "later step than the one used", code: code(current + 1), notBefore: current, wantStep: current + 1, wantOK: true},
+		{name: "too short", code: code(current)[:5]},
+		{name: "wrong code", code: "000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfc6238Secret, tt.code, now, tt.notBefore)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("ValidateTOTP = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	codes, err := MakeRecoveryCodes(2)
+	if err != nil {
+		t.Fatalf("MakeRecoveryCodes: %v", err)
+	}
+	if len(codes) != 2 || codes[0] == codes[1] {
+		t.Fatalf("MakeRecoveryCodes = %v", codes)
+	}
+	// users may type codes without the dash, in upper case or with spaces
+	want := HashRecoveryCode("0123abcd-4567ef89")
+	for _, typed := range []string{"0123abcd4567ef89", " 0123ABCD-4567EF89 "} {
+		if HashRecoveryCode(typed) != want {
+			t.Errorf("HashRecoveryCode(%q) differs", typed)
+		}
+	}
+}
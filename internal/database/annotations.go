@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Region is a rectangle of the video frame. Coordinates are fractions of the
+// frame's width and height, measured from its top left corner, so they don't
+// depend on the resolution the video is played at.
+type Region struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Annotation is a review note on a span of a video, or a single moment if
+// EndSeconds is nil, optionally pointing at a region of the frame.
+type Annotation struct {
+	ID           uuid.UUID  `json:"id"`
+	VideoID      uuid.UUID  `json:"video_id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	Body         string     `json:"body"`
+	StartSeconds float64    `json:"start_seconds"`
+	EndSeconds   *float64   `json:"end_seconds"`
+	Region       *Region    `json:"region"`
+	ResolvedAt   *time.Time `json:"resolved_at"`
+	ResolvedBy   *uuid.UUID `json:"resolved_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+const annotationColumns = `id, video_id, user_id, body, start_seconds, end_seconds, region_x, region_y, region_width, region_height, resolved_at, resolved_by, created_at, updated_at`
+
+func scanAnnotation(row rowScanner) (Annotation, error) {
+	var a Annotation
+	var x, y, width, height sql.NullFloat64
+	err := row.Scan(
+		&a.ID,
+		&a.VideoID,
+		&a.UserID,
+		&a.Body,
+		&a.StartSeconds,
+		&a.EndSeconds,
+		&x, &y, &width, &height,
+		&a.ResolvedAt,
+		&a.ResolvedBy,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+	)
+	if err != nil {
+		return Annotation{}, err
+	}
+	if x.Valid {
+		a.Region = &Region{X: x.Float64, Y: y.Float64, Width: width.Float64, Height: height.Float64}
+	}
+	return a, nil
+}
+
+// regionArgs are the values of the region columns for region.
+func regionArgs(region *Region) []any {
+	if region == nil {
+		return []any{nil, nil, nil, nil}
+	}
+	return []any{region.X, region.Y, region.Width, region.Height}
+}
+
+type CreateAnnotationParams struct {
+	VideoID      uuid.UUID
+	UserID       uuid.UUID
+	Body         string
+	StartSeconds float64
+	EndSeconds   *float64
+	Region       *Region
+}
+
+func (c Client) CreateAnnotation(params CreateAnnotationParams) (Annotation, error) {
+	id := uuid.New()
+	args := []any{id.String(), params.VideoID.String(), params.UserID.String(), params.Body, params.StartSeconds, params.EndSeconds}
+	_, err := c.db.Exec(`
+	INSERT INTO annotations (
+		id, video_id, user_id, body, start_seconds, end_seconds,
+		region_x, region_y, region_width, region_height,
+		created_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, append(args, regionArgs(params.Region)...)...)
+	if err != nil {
+		return Annotation{}, err
+	}
+
+	annotation, err := c.GetAnnotation(id)
+	if err != nil {
+		return Annotation{}, err
+	}
+	return *annotation, nil
+}
+
+// GetAnnotation returns the annotation, or nil if there is none with that ID.
+func (c Client) GetAnnotation(id uuid.UUID) (*Annotation, error) {
+	annotation, err := scanAnnotation(c.db.QueryRow("SELECT "+annotationColumns+" FROM annotations WHERE id = ?", id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &annotation, nil
+}
+
+// GetAnnotations returns the annotations of the video in the order they
+// appear in it. If resolved isn't nil, only those that are or aren't
+// resolved are returned.
+func (c Client) GetAnnotations(videoID uuid.UUID, resolved *bool) ([]Annotation, error) {
+	query := "SELECT " + annotationColumns + " FROM annotations WHERE video_id = ?"
+	if resolved != nil {
+		query += " AND " + nullCondition("resolved_at", *resolved)
+	}
+	query += " ORDER BY start_seconds, COALESCE(end_seconds, start_seconds), created_at"
+
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	annotations := []Annotation{}
+	for rows.Next() {
+		annotation, err := scanAnnotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations, rows.Err()
+}
+
+// UpdateAnnotation saves the body, span and region of the annotation.
+func (c Client) UpdateAnnotation(annotation Annotation) error {
+	args := []any{annotation.Body, annotation.StartSeconds, annotation.EndSeconds}
+	args = append(args, regionArgs(annotation.Region)...)
+	_, err := c.db.Exec(`
+	UPDATE annotations
+	SET
+		body = ?,
+		start_seconds = ?,
+		end_seconds = ?,
+		region_x = ?,
+		region_y = ?,
+		region_width = ?,
+		region_height = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, append(args, annotation.ID.String())...)
+	return err
+}
+
+// SetAnnotationResolved marks the annotation resolved by the user, or
+// unresolved if resolvedBy is nil. Resolving a resolved annotation again
+// keeps who resolved it first.
+func (c Client) SetAnnotationResolved(id uuid.UUID, resolvedBy *uuid.UUID) error {
+	_, err := c.db.Exec(`
+	UPDATE annotations
+	SET
+		resolved_at = CASE WHEN ? IS NULL THEN NULL ELSE COALESCE(resolved_at, CURRENT_TIMESTAMP) END,
+		resolved_by = CASE WHEN ? IS NULL THEN NULL ELSE COALESCE(resolved_by, ?) END,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, resolvedBy, resolvedBy, resolvedBy, id.String())
+	return err
+}
+
+func (c Client) DeleteAnnotation(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM annotations WHERE id = ?", id.String())
+	return err
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent records a security-relevant or destructive action. Events can
+// only be added: triggers on the table reject updates and deletes.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Action    string    `json:"action"`
+	// ActorID is nil for actions by unauthenticated clients, such as failed
+	// logins.
+	ActorID    *uuid.UUID        `json:"actor_id"`
+	TargetType string            `json:"target_type"`
+	TargetID   string            `json:"target_id"`
+	IP         string            `json:"ip"`
+	Details    map[string]string `json:"details"`
+}
+
+// AuditEventFilter selects audit events. Zero fields don't filter.
+type AuditEventFilter struct {
+	Action   string
+	ActorID  *uuid.UUID
+	TargetID string
+	Since    *time.Time
+	Until    *time.Time
+	// BeforeID pages through events: only events older than it are returned
+	BeforeID int64
+	Limit    int
+}
+
+func (c Client) CreateAuditEvent(event AuditEvent) error {
+	details := []byte("{}")
+	if len(event.Details) > 0 {
+		var err error
+		details, err = json.Marshal(event.Details)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO audit_events (created_at, action, actor_id, target_type, target_id, ip, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), event.Action, event.ActorID, event.TargetType, event.TargetID, event.IP, string(details))
+	return err
+}
+
+// GetAuditEvents returns the events matching filter, newest first.
+func (c Client) GetAuditEvents(filter AuditEventFilter) ([]AuditEvent, error) {
+	events := []AuditEvent{}
+	err := c.EachAuditEvent(filter, func(event AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	return events, err
+}
+
+// EachAuditEvent calls fn for each event matching filter, newest first,
+// without loading them all in memory. It stops at the first error from fn.
+func (c Client) EachAuditEvent(filter AuditEventFilter, fn func(AuditEvent) error) error {
+	var where []string
+	var args []any
+	if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.ActorID != nil {
+		where = append(where, "actor_id = ?")
+		args = append(args, filter.ActorID.String())
+	}
+	if filter.TargetID != "" {
+		where = append(where, "target_id = ?")
+		args = append(args, filter.TargetID)
+	}
+	if filter.Since != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+	if filter.BeforeID > 0 {
+		where = append(where, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+
+	query := `
+		SELECT id, created_at, action, actor_id, target_type, target_id, ip, details
+		FROM audit_events
+	`
+	if len(where) > 0 {
+		query += "WHERE " + strings.Join(where, " AND ") + "\n"
+	}
+	query += "ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event AuditEvent
+		var details string
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.Action,
+			&event.ActorID,
+			&event.TargetType,
+			&event.TargetID,
+			&event.IP,
+			&details,
+		)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(details), &event.Details); err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
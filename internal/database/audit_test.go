@@ -0,0 +1,94 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestAuditEventsAppendOnly(t *testing.T) {
+	c := newTestClient(t)
+	if err := c.CreateAuditEvent(AuditEvent{Action: "login", TargetType: "user", TargetID: "1"}); err != nil {
+		t.Fatalf("CreateAuditEvent: %v", err)
+	}
+
+	for _, query := range []string{
+		"UPDATE audit_events SET action = 'forged'",
+		"DELETE FROM audit_events",
+	} {
+		if _, err := c.db.Exec(query); err == nil {
+			t.Errorf("%s: no error, want the trigger to reject it", query)
+		}
+	}
+	if err := c.Reset(uuid.New()); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	events, err := c.GetAuditEvents(AuditEventFilter{})
+	if err != nil {
+		t.Fatalf("GetAuditEvents: %v", err)
+	}
+	if len(events) != 1 || events[0].Action != "login" {
+		t.Errorf("events = %+v, want the login event unchanged", events)
+	}
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	c := newTestClient(t)
+	alice, bob := uuid.New(), uuid.New()
+	for _, event := range []AuditEvent{
+		{Action: "login", ActorID: &alice, TargetType: "user", TargetID: alice.String()},
+		{Action: "login_failed", TargetType: "user", TargetID: bob.String(), Details: map[string]string{"reason": "password"}},
+		{Action: "video_delete", ActorID: &bob, TargetType: "video", TargetID: "video"},
+	} {
+		if err := c.CreateAuditEvent(event); err != nil {
+			t.Fatalf("CreateAuditEvent: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	all, err := c.GetAuditEvents(AuditEventFilter{})
+	if err != nil {
+		t.Fatalf("GetAuditEvents: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("got %d events, want 3", len(all))
+	}
+	if all[1].Details["reason"] != "password" {
+		t.Errorf("details = %v, want the reason", all[1].Details)
+	}
+	// newest first
+	videoDelete, loginFailed, login := all[0], all[1], all[2]
+	afterLogin := login.CreatedAt.Add(time.Microsecond)
+
+	tests := []struct {
+		name   string
+		filter AuditEventFilter
+		want   []AuditEvent
+	}{
+		{name: "action", filter: AuditEventFilter{Action: "login"}, want: []AuditEvent{login}},
+		{name: "actor", filter: AuditEventFilter{ActorID: &bob}, want: []AuditEvent{videoDelete}},
+		{name: "target", filter: AuditEventFilter{TargetID: bob.String()}, want: []AuditEvent{loginFailed}},
+		{name: "since", filter: AuditEventFilter{Since: &afterLogin}, want: []AuditEvent{videoDelete, loginFailed}},
+		{name: "until", filter: AuditEventFilter{Until: &afterLogin}, want: []AuditEvent{login}},
+		{name: "before", filter: AuditEventFilter{BeforeID: videoDelete.ID}, want: []AuditEvent{loginFailed, login}},
+		{name: "limit", filter: AuditEventFilter{Limit: 2}, want: []AuditEvent{videoDelete, loginFailed}},
+		{name: "combined", filter: AuditEventFilter{Action: "login", ActorID: &bob}, want: []AuditEvent{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.GetAuditEvents(tt.filter)
+			if err != nil {
+				t.Fatalf("GetAuditEvents: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i].ID {
+					t.Errorf("event %d = %s, want %s", i, got[i].Action, tt.want[i].Action)
+				}
+			}
+		})
+	}
+}
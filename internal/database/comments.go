@@ -0,0 +1,284 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Comment is feedback left on a video. Comments either start a thread or, if
+// ParentID is set, reply to the comment that started one; replies to replies
+// join the thread of their parent.
+type Comment struct {
+	ID         uuid.UUID  `json:"id"`
+	VideoID    uuid.UUID  `json:"video_id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	AuthorName string     `json:"author_name"`
+	ParentID   *uuid.UUID `json:"parent_id"`
+	Body       string     `json:"body"`
+	// TimestampSeconds anchors the comment to a moment of the video
+	TimestampSeconds *float64   `json:"timestamp_seconds"`
+	ReplyCount       int        `json:"reply_count"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	EditedAt         *time.Time `json:"edited_at"`
+	// DeletedAt is set on deleted comments kept because they have replies.
+	// Their body is empty.
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+type CreateCommentParams struct {
+	VideoID          uuid.UUID
+	UserID           uuid.UUID
+	ParentID         *uuid.UUID
+	Body             string
+	TimestampSeconds *float64
+}
+
+const commentColumns = `cm.id, cm.video_id, cm.user_id, COALESCE(u.name, ''), cm.parent_id, cm.body, cm.timestamp_seconds,
+	(SELECT COUNT(*) FROM comments r WHERE r.parent_id = cm.id), cm.created_at, cm.updated_at, cm.edited_at, cm.deleted_at`
+
+const commentFrom = `comments cm LEFT JOIN users u ON u.id = cm.user_id`
+
+func scanComment(row rowScanner, extra ...any) (Comment, error) {
+	var comment Comment
+	err := row.Scan(append([]any{
+		&comment.ID,
+		&comment.VideoID,
+		&comment.UserID,
+		&comment.AuthorName,
+		&comment.ParentID,
+		&comment.Body,
+		&comment.TimestampSeconds,
+		&comment.ReplyCount,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.EditedAt,
+		&comment.DeletedAt,
+	}, extra...)...)
+	return comment, err
+}
+
+// CreateComment adds a comment to a video. A reply to a reply is added to
+// the thread of the comment replied to.
+func (c Client) CreateComment(params CreateCommentParams) (Comment, error) {
+	id := uuid.New()
+	// with sub-second precision, so that comments posted in quick succession
+	// keep their order
+	now := time.Now().UTC()
+	_, err := c.db.Exec(`
+	INSERT INTO comments (id, video_id, user_id, parent_id, body, timestamp_seconds, created_at, updated_at)
+	VALUES (
+		?, ?, ?,
+		(SELECT COALESCE(parent_id, id) FROM comments WHERE id = ?),
+		?, ?, ?, ?
+	)
+	`, id.String(), params.VideoID.String(), params.UserID.String(), params.ParentID, params.Body, params.TimestampSeconds, now, now)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	comment, err := c.GetComment(id)
+	if err != nil {
+		return Comment{}, err
+	}
+	return *comment, nil
+}
+
+// GetComment returns the comment, or nil if there is none with that ID.
+func (c Client) GetComment(id uuid.UUID) (*Comment, error) {
+	comment, err := scanComment(c.db.QueryRow("SELECT "+commentColumns+" FROM "+commentFrom+" WHERE cm.id = ?", id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// UpdateComment saves the body and timestamp of the comment and marks it
+// edited.
+func (c Client) UpdateComment(comment Comment) error {
+	_, err := c.db.Exec(`
+	UPDATE comments
+	SET
+		body = ?,
+		timestamp_seconds = ?,
+		edited_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND deleted_at IS NULL
+	`, comment.Body, comment.TimestampSeconds, comment.ID.String())
+	return err
+}
+
+// DeleteComment deletes a comment. A comment that started a thread others
+// replied to is blanked instead, so the replies keep their context.
+func (c Client) DeleteComment(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteComments(tx, "id = ?", id.String()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteComments deletes the comments matching condition, a condition on the
+// comments table, keeping threads with replies left as blanked comments.
+func deleteComments(tx *sql.Tx, condition string, args ...any) error {
+	statements := []string{
+		"DELETE FROM comments WHERE parent_id IS NOT NULL AND (" + condition + ")",
+		`DELETE FROM comments
+		WHERE parent_id IS NULL AND (` + condition + `)
+		AND id NOT IN (SELECT parent_id FROM comments WHERE parent_id IS NOT NULL)`,
+		`UPDATE comments
+		SET body = '', timestamp_seconds = NULL, deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE deleted_at IS NULL AND (` + condition + `)`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return err
+		}
+	}
+	// blanked threads whose last reply was just deleted
+	_, err := tx.Exec(`
+	DELETE FROM comments
+	WHERE deleted_at IS NOT NULL
+	AND id NOT IN (SELECT parent_id FROM comments WHERE parent_id IS NOT NULL)
+	`)
+	return err
+}
+
+// CommentListParams selects a page of the threads of a video, newest first,
+// or with ParentID, of the replies in a thread, oldest first.
+type CommentListParams struct {
+	VideoID  uuid.UUID
+	ParentID *uuid.UUID
+	Limit    int
+	// Cursor is the NextCursor of the previous page, or empty for the first
+	Cursor string
+}
+
+type CommentPage struct {
+	Comments []Comment
+	// Total is the number of comments on all pages
+	Total int
+	// NextCursor is empty on the last page
+	NextCursor string
+}
+
+// commentCursor marks the last comment of a page. It is passed to clients as
+// base64-encoded JSON.
+type commentCursor struct {
+	Parent    *uuid.UUID `json:"p"`
+	CreatedAt float64    `json:"k"`
+	ID        uuid.UUID  `json:"id"`
+}
+
+// ListComments returns a page of comments.
+func (c Client) ListComments(params CommentListParams) (CommentPage, error) {
+	where := []string{"cm.video_id = ?"}
+	args := []any{params.VideoID.String()}
+	direction, comparison := "DESC", "<"
+	if params.ParentID != nil {
+		where = append(where, "cm.parent_id = ?")
+		args = append(args, params.ParentID.String())
+		direction, comparison = "ASC", ">"
+	} else {
+		where = append(where, "cm.parent_id IS NULL")
+	}
+
+	var page CommentPage
+	countQuery := "SELECT COUNT(*) FROM comments cm WHERE " + strings.Join(where, " AND ")
+	if err := c.db.QueryRow(countQuery, args...).Scan(&page.Total); err != nil {
+		return CommentPage{}, err
+	}
+
+	if params.Cursor != "" {
+		var cursor commentCursor
+		data, err := base64.RawURLEncoding.DecodeString(params.Cursor)
+		if err != nil {
+			return CommentPage{}, ErrInvalidCursor
+		}
+		if err := json.Unmarshal(data, &cursor); err != nil {
+			return CommentPage{}, ErrInvalidCursor
+		}
+		if (cursor.Parent == nil) != (params.ParentID == nil) || (cursor.Parent != nil && *cursor.Parent != *params.ParentID) {
+			return CommentPage{}, ErrInvalidCursor
+		}
+		where = append(where, "(julianday(cm.created_at), cm.id) "+comparison+" (?, ?)")
+		args = append(args, cursor.CreatedAt, cursor.ID.String())
+	}
+
+	query := `
+	SELECT ` + commentColumns + `, julianday(cm.created_at)
+	FROM ` + commentFrom + `
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY julianday(cm.created_at) ` + direction + `, cm.id ` + direction + `
+	LIMIT ?
+	`
+	// one more than asked for tells whether there is a next page
+	rows, err := c.db.Query(query, append(args, params.Limit+1)...)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	defer rows.Close()
+
+	page.Comments = []Comment{}
+	var lastKey float64
+	for rows.Next() {
+		var key float64
+		comment, err := scanComment(rows, &key)
+		if err != nil {
+			return CommentPage{}, err
+		}
+		if len(page.Comments) == params.Limit {
+			data, err := json.Marshal(commentCursor{
+				Parent:    params.ParentID,
+				CreatedAt: lastKey,
+				ID:        page.Comments[len(page.Comments)-1].ID,
+			})
+			if err != nil {
+				return CommentPage{}, err
+			}
+			page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+			break
+		}
+		page.Comments = append(page.Comments, comment)
+		lastKey = key
+	}
+	return page, rows.Err()
+}
+
+// GetUserComments returns every comment the user wrote, oldest first.
+func (c Client) GetUserComments(userID uuid.UUID) ([]Comment, error) {
+	rows, err := c.db.Query(`
+	SELECT `+commentColumns+`
+	FROM `+commentFrom+`
+	WHERE cm.user_id = ? AND cm.deleted_at IS NULL
+	ORDER BY cm.created_at
+	`, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestComment(t *testing.T, c Client, video Video, userID uuid.UUID, parentID *uuid.UUID, body string) Comment {
+	t.Helper()
+	comment, err := c.CreateComment(CreateCommentParams{VideoID: video.ID, UserID: userID, ParentID: parentID, Body: body})
+	if err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+	return comment
+}
+
+func TestCreateCommentFlattensThreads(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	video := newTestVideo(t, c, user.ID)
+
+	thread := newTestComment(t, c, video, user.ID, nil, "thread")
+	reply := newTestComment(t, c, video, user.ID, &thread.ID, "reply")
+	replyToReply := newTestComment(t, c, video, user.ID, &reply.ID, "reply to reply")
+
+	tests := []struct {
+		name       string
+		comment    Comment
+		wantParent *uuid.UUID
+		wantCount  int
+	}{
+		{name: "thread", comment: thread, wantParent: nil, wantCount: 2},
+		{name: "reply", comment: reply, wantParent: &thread.ID, wantCount: 0},
+		{name: "reply to a reply", comment: replyToReply, wantParent: &thread.ID, wantCount: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.GetComment(tt.comment.ID)
+			if err != nil {
+				t.Fatalf("GetComment: %v", err)
+			}
+			if (got.ParentID == nil) != (tt.wantParent == nil) || (got.ParentID != nil && *got.ParentID != *tt.wantParent) {
+				t.Errorf("parent = %v, want %v", got.ParentID, tt.wantParent)
+			}
+			if got.ReplyCount != tt.wantCount {
+				t.Errorf("reply count = %d, want %d", got.ReplyCount, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	tests := []struct {
+		name string
+		// delete returns the IDs of the comments to delete, in order, from
+		// a thread and its two replies
+		delete      func(thread, first, second Comment) []uuid.UUID
+		wantThread  string // "kept", "blanked" or "gone"
+		wantReplies int
+	}{
+		{
+			name:        "reply",
+			delete:      func(thread, first, second Comment) []uuid.UUID { return []uuid.UUID{first.ID} },
+			wantThread:  "kept",
+			wantReplies: 1,
+		},
+		{
+			name:        "thread with replies",
+			delete:      func(thread, first, second Comment) []uuid.UUID { return []uuid.UUID{thread.ID} },
+			wantThread:  "blanked",
+			wantReplies: 2,
+		},
+		{
+			name: "last reply of a blanked thread",
+			delete: func(thread, first, second Comment) []uuid.UUID {
+				return []uuid.UUID{thread.ID, first.ID, second.ID}
+			},
+			wantThread:  "gone",
+			wantReplies: 0,
+		},
+		{
+			name: "thread after its replies",
+			delete: func(thread, first, second Comment) []uuid.UUID {
+				return []uuid.UUID{first.ID, second.ID, thread.ID}
+			},
+			wantThread:  "gone",
+			wantReplies: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			user := newTestUser(t, c)
+			video := newTestVideo(t, c, user.ID)
+			thread := newTestComment(t, c, video, user.ID, nil, "thread")
+			first := newTestComment(t, c, video, user.ID, &thread.ID, "first")
+			second := newTestComment(t, c, video, user.ID, &thread.ID, "second")
+
+			for _, id := range tt.delete(thread, first, second) {
+				if err := c.DeleteComment(id); err != nil {
+					t.Fatalf("DeleteComment: %v", err)
+				}
+			}
+
+			got, err := c.GetComment(thread.ID)
+			if err != nil {
+				t.Fatalf("GetComment: %v", err)
+			}
+			state := "gone"
+			if got != nil {
+				state = "kept"
+				if got.DeletedAt != nil {
+					state = "blanked"
+					if got.Body != "" {
+						t.Errorf("blanked thread kept its body %q", got.Body)
+					}
+				}
+			}
+			if state != tt.wantThread {
+				t.Errorf("thread is %s, want %s", state, tt.wantThread)
+			}
+			page, err := c.ListComments(CommentListParams{VideoID: video.ID, ParentID: &thread.ID, Limit: 10})
+			if err != nil {
+				t.Fatalf("ListComments: %v", err)
+			}
+			if len(page.Comments) != tt.wantReplies {
+				t.Errorf("got %d replies, want %d", len(page.Comments), tt.wantReplies)
+			}
+		})
+	}
+}
+
+func TestListCommentsPages(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	video := newTestVideo(t, c, user.ID)
+
+	var threads, replies []string
+	var first Comment
+	for i := range 5 {
+		time.Sleep(time.Millisecond)
+		thread := newTestComment(t, c, video, user.ID, nil, fmt.Sprintf("thread %d", i))
+		threads = append(threads, thread.Body)
+		if i == 0 {
+			first = thread
+		}
+	}
+	for i := range 3 {
+		// comments are ordered by their creation time, which is only
+		// precise to tens of microseconds
+		time.Sleep(time.Millisecond)
+		reply := newTestComment(t, c, video, user.ID, &first.ID, fmt.Sprintf("reply %d", i))
+		replies = append(replies, reply.Body)
+	}
+
+	tests := []struct {
+		name     string
+		parentID *uuid.UUID
+		limit    int
+		want     [][]string
+	}{
+		{
+			name:  "threads, newest first",
+			limit: 2,
+			want:  [][]string{{threads[4], threads[3]}, {threads[2], threads[1]}, {threads[0]}},
+		},
+		{
+			name:     "replies, oldest first",
+			parentID: &first.ID,
+			limit:    2,
+			want:     [][]string{{replies[0], replies[1]}, {replies[2]}},
+		},
+		{
+			name:     "exact fit",
+			parentID: &first.ID,
+			limit:    3,
+			want:     [][]string{{replies[0], replies[1], replies[2]}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := ""
+			for i, want := range tt.want {
+				page, err := c.ListComments(CommentListParams{VideoID: video.ID, ParentID: tt.parentID, Limit: tt.limit, Cursor: cursor})
+				if err != nil {
+					t.Fatalf("ListComments page %d: %v", i, err)
+				}
+				var got []string
+				for _, comment := range page.Comments {
+					got = append(got, comment.Body)
+				}
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("page %d = %q, want %q", i, got, want)
+				}
+				wantLast := i == len(tt.want)-1
+				if (page.NextCursor == "") != wantLast {
+					t.Errorf("page %d next cursor = %q, want last page %v", i, page.NextCursor, wantLast)
+				}
+				cursor = page.NextCursor
+			}
+		})
+	}
+
+	t.Run("cursor of another list", func(t *testing.T) {
+		page, err := c.ListComments(CommentListParams{VideoID: video.ID, Limit: 1})
+		if err != nil {
+			t.Fatalf("ListComments: %v", err)
+		}
+		_, err = c.ListComments(CommentListParams{VideoID: video.ID, ParentID: &first.ID, Limit: 1, Cursor: page.NextCursor})
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("error = %v, want %v", err, ErrInvalidCursor)
+		}
+	})
+}
+
+func TestUpdateCommentClearsTimestamp(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	video := newTestVideo(t, c, user.ID)
+	seconds := 12.5
+	comment, err := c.CreateComment(CreateCommentParams{VideoID: video.ID, UserID: user.ID, Body: "at", TimestampSeconds: &seconds})
+	if err != nil {
+		t.Fatalf("CreateComment: %v", err)
+	}
+
+	comment.TimestampSeconds = nil
+	if err := c.UpdateComment(comment); err != nil {
+		t.Fatalf("UpdateComment: %v", err)
+	}
+	got, err := c.GetComment(comment.ID)
+	if err != nil {
+		t.Fatalf("GetComment: %v", err)
+	}
+	if got.TimestampSeconds != nil {
+		t.Errorf("timestamp = %v, want none", *got.TimestampSeconds)
+	}
+	if got.EditedAt == nil {
+		t.Errorf("comment wasn't marked edited")
+	}
+}
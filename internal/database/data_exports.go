@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DataExportStatus string
+
+const (
+	DataExportPending DataExportStatus = "pending"
+	DataExportReady   DataExportStatus = "ready"
+	DataExportFailed  DataExportStatus = "failed"
+)
+
+// DataExport is an archive of everything stored about a user, built in the
+// background. FilePath is set once the archive is ready.
+type DataExport struct {
+	ID          uuid.UUID        `json:"id"`
+	UserID      uuid.UUID        `json:"user_id"`
+	Status      DataExportStatus `json:"status"`
+	FilePath    *string          `json:"-"`
+	SizeBytes   *int64           `json:"size_bytes"`
+	CreatedAt   time.Time        `json:"created_at"`
+	CompletedAt *time.Time       `json:"completed_at"`
+	ExpiresAt   *time.Time       `json:"expires_at"`
+}
+
+const dataExportColumns = `id, user_id, status, file_path, size_bytes, created_at, completed_at, expires_at`
+
+func scanDataExport(row rowScanner) (DataExport, error) {
+	var e DataExport
+	var id, userID string
+	err := row.Scan(
+		&id,
+		&userID,
+		&e.Status,
+		&e.FilePath,
+		&e.SizeBytes,
+		&e.CreatedAt,
+		&e.CompletedAt,
+		&e.ExpiresAt,
+	)
+	if err != nil {
+		return DataExport{}, err
+	}
+	if e.ID, err = uuid.Parse(id); err != nil {
+		return DataExport{}, err
+	}
+	if e.UserID, err = uuid.Parse(userID); err != nil {
+		return DataExport{}, err
+	}
+	return e, nil
+}
+
+func (c Client) CreateDataExport(userID uuid.UUID) (DataExport, error) {
+	id := uuid.New()
+	query := `
+		INSERT INTO data_exports (id, user_id, status, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, id.String(), userID.String(), DataExportPending)
+	if err != nil {
+		return DataExport{}, err
+	}
+	export, err := c.GetDataExport(id)
+	if err != nil {
+		return DataExport{}, err
+	}
+	return *export, nil
+}
+
+// GetDataExport returns the export with the given ID, or nil if there is none.
+func (c Client) GetDataExport(id uuid.UUID) (*DataExport, error) {
+	query := `
+		SELECT ` + dataExportColumns + `
+		FROM data_exports
+		WHERE id = ?
+	`
+	e, err := scanDataExport(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (c Client) GetDataExports(userID uuid.UUID) ([]DataExport, error) {
+	query := `
+		SELECT ` + dataExportColumns + `
+		FROM data_exports
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []DataExport{}
+	for rows.Next() {
+		e, err := scanDataExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+func (c Client) CompleteDataExport(id uuid.UUID, filePath string, sizeBytes int64, expiresAt time.Time) error {
+	query := `
+		UPDATE data_exports
+		SET status = ?, file_path = ?, size_bytes = ?, completed_at = CURRENT_TIMESTAMP, expires_at = ?
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, DataExportReady, filePath, sizeBytes, expiresAt, id.String())
+	return err
+}
+
+func (c Client) FailDataExport(id uuid.UUID) error {
+	query := `
+		UPDATE data_exports
+		SET status = ?, completed_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, DataExportFailed, id.String())
+	return err
+}
+
+// FailPendingDataExports marks exports that were still being built as failed.
+// It is meant to be called on startup, since builds don't survive a restart.
+func (c Client) FailPendingDataExports() error {
+	query := `
+		UPDATE data_exports
+		SET status = ?, completed_at = CURRENT_TIMESTAMP
+		WHERE status = ?
+	`
+	_, err := c.db.Exec(query, DataExportFailed, DataExportPending)
+	return err
+}
+
+// DeleteExpiredDataExports deletes exports past their expiry and returns the
+// paths of their archives, which the caller should remove.
+func (c Client) DeleteExpiredDataExports() ([]string, error) {
+	query := `
+		DELETE FROM data_exports
+		WHERE expires_at <= ?
+		RETURNING file_path
+	`
+	rows, err := c.db.Query(query, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var path *string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		if path != nil {
+			paths = append(paths, *path)
+		}
+	}
+	return paths, rows.Err()
+}
@@ -4,11 +4,14 @@ import (
 	"database/sql"
 	"fmt"
 
+	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
 	db *sql.DB
+	// fts5 is set when SQLite was built with FTS5, which video search needs
+	fts5 bool
 }
 
 func NewClient(pathToDB string) (Client, error) {
@@ -16,7 +19,7 @@ func NewClient(pathToDB string) (Client, error) {
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
+	c := Client{db: db}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
@@ -32,13 +35,44 @@ func (c *Client) autoMigrate() error {
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
+		email TEXT UNIQUE NOT NULL,
+		role TEXT NOT NULL DEFAULT 'user',
+		disabled_at TIMESTAMP,
+		verified_at TIMESTAMP,
+		name TEXT NOT NULL DEFAULT '',
+		pending_email TEXT,
+		storage_quota_bytes INTEGER
 	);
 	`
 	_, err := c.db.Exec(userTable)
 	if err != nil {
 		return err
 	}
+	if _, err = c.addColumnIfMissing("users", "role", "TEXT NOT NULL DEFAULT 'user'"); err != nil {
+		return err
+	}
+	if _, err = c.addColumnIfMissing("users", "disabled_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	added, err := c.addColumnIfMissing("users", "verified_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	if added {
+		// accounts created before email verification existed stay usable
+		if _, err = c.db.Exec("UPDATE users SET verified_at = created_at"); err != nil {
+			return err
+		}
+	}
+	if _, err = c.addColumnIfMissing("users", "name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err = c.addColumnIfMissing("users", "pending_email", "TEXT"); err != nil {
+		return err
+	}
+	if _, err = c.addColumnIfMissing("users", "storage_quota_bytes", "INTEGER"); err != nil {
+		return err
+	}
 	refreshTokenTable := `
 	CREATE TABLE IF NOT EXISTS refresh_tokens (
 		token TEXT PRIMARY KEY,
@@ -55,6 +89,446 @@ func (c *Client) autoMigrate() error {
 		return err
 	}
 
+	passwordResetTokenTable := `
+	CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		token_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(passwordResetTokenTable)
+	if err != nil {
+		return err
+	}
+
+	userTOTPTable := `
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		confirmed_at TIMESTAMP,
+		last_used_step INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userTOTPTable)
+	if err != nil {
+		return err
+	}
+
+	totpRecoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+		code_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(totpRecoveryCodeTable)
+	if err != nil {
+		return err
+	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(issuer, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	oidcLoginStateTable := `
+	CREATE TABLE IF NOT EXISTS oidc_login_states (
+		state TEXT PRIMARY KEY,
+		code_verifier TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(oidcLoginStateTable)
+	if err != nil {
+		return err
+	}
+
+	webAuthnCredentialTable := `
+	CREATE TABLE IF NOT EXISTS webauthn_credentials (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		data BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(webAuthnCredentialTable)
+	if err != nil {
+		return err
+	}
+
+	webAuthnSessionTable := `
+	CREATE TABLE IF NOT EXISTS webauthn_sessions (
+		id TEXT PRIMARY KEY,
+		user_id TEXT,
+		data BLOB NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(webAuthnSessionTable)
+	if err != nil {
+		return err
+	}
+
+	deviceAuthorizationTable := `
+	CREATE TABLE IF NOT EXISTS device_authorizations (
+		device_code_hash TEXT PRIMARY KEY,
+		user_code TEXT UNIQUE NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		interval_seconds INTEGER NOT NULL,
+		last_polled_at TIMESTAMP,
+		user_id TEXT,
+		approved_at TIMESTAMP,
+		denied_at TIMESTAMP,
+		consumed_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(deviceAuthorizationTable)
+	if err != nil {
+		return err
+	}
+
+	videoCollaboratorTable := `
+	CREATE TABLE IF NOT EXISTS video_collaborators (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, user_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoCollaboratorTable)
+	if err != nil {
+		return err
+	}
+
+	tagTables := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_id, name),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS video_tags (
+		video_id TEXT NOT NULL,
+		tag_id TEXT NOT NULL,
+		PRIMARY KEY(video_id, tag_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(tag_id) REFERENCES tags(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_tags_tag_id ON video_tags(tag_id);
+	`
+	_, err = c.db.Exec(tagTables)
+	if err != nil {
+		return err
+	}
+
+	playlistTables := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		visibility TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS playlists_user_id ON playlists(user_id);
+	CREATE TABLE IF NOT EXISTS playlist_videos (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS playlist_videos_video_id ON playlist_videos(video_id);
+	`
+	_, err = c.db.Exec(playlistTables)
+	if err != nil {
+		return err
+	}
+
+	commentTable := `
+	CREATE TABLE IF NOT EXISTS comments (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		parent_id TEXT,
+		body TEXT NOT NULL,
+		timestamp_seconds REAL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		edited_at TIMESTAMP,
+		deleted_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(parent_id) REFERENCES comments(id)
+	);
+	CREATE INDEX IF NOT EXISTS comments_video_id ON comments(video_id, parent_id);
+	CREATE INDEX IF NOT EXISTS comments_parent_id ON comments(parent_id);
+	CREATE INDEX IF NOT EXISTS comments_user_id ON comments(user_id);
+	`
+	_, err = c.db.Exec(commentTable)
+	if err != nil {
+		return err
+	}
+
+	reviewTables := `
+	CREATE TABLE IF NOT EXISTS annotations (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		body TEXT NOT NULL,
+		start_seconds REAL NOT NULL,
+		end_seconds REAL,
+		region_x REAL,
+		region_y REAL,
+		region_width REAL,
+		region_height REAL,
+		resolved_at TIMESTAMP,
+		resolved_by TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS annotations_video_id ON annotations(video_id, start_seconds);
+	CREATE TABLE IF NOT EXISTS video_review_transitions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		from_status TEXT NOT NULL,
+		to_status TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS video_review_transitions_video_id ON video_review_transitions(video_id);
+	`
+	_, err = c.db.Exec(reviewTables)
+	if err != nil {
+		return err
+	}
+
+	playbackTables := `
+	CREATE TABLE IF NOT EXISTS playback_sessions (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		session_id TEXT NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		last_event_at TIMESTAMP NOT NULL,
+		max_quartile INTEGER NOT NULL DEFAULT 0,
+		completed BOOLEAN NOT NULL DEFAULT FALSE,
+		counted_view BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY(video_id, user_id, session_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS playback_sessions_last_event_at ON playback_sessions(last_event_at);
+	CREATE INDEX IF NOT EXISTS playback_sessions_user_id ON playback_sessions(user_id);
+	CREATE TABLE IF NOT EXISTS video_daily_stats (
+		video_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		views INTEGER NOT NULL DEFAULT 0,
+		watch_seconds REAL NOT NULL DEFAULT 0,
+		quartile_25 INTEGER NOT NULL DEFAULT 0,
+		quartile_50 INTEGER NOT NULL DEFAULT 0,
+		quartile_75 INTEGER NOT NULL DEFAULT 0,
+		completions INTEGER NOT NULL DEFAULT 0,
+		rebuffers INTEGER NOT NULL DEFAULT 0,
+		rebuffer_ms INTEGER NOT NULL DEFAULT 0,
+		playback_starts INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY(video_id, day),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(playbackTables)
+	if err != nil {
+		return err
+	}
+
+	watchProgressTable := `
+	CREATE TABLE IF NOT EXISTS watch_progress (
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position_seconds REAL NOT NULL,
+		finished BOOLEAN NOT NULL DEFAULT FALSE,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(user_id, video_id),
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS watch_progress_video_id ON watch_progress(video_id);
+	CREATE INDEX IF NOT EXISTS watch_progress_user_id_updated_at ON watch_progress(user_id, updated_at);
+	`
+	_, err = c.db.Exec(watchProgressTable)
+	if err != nil {
+		return err
+	}
+
+	dataExportTable := `
+	CREATE TABLE IF NOT EXISTS data_exports (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		file_path TEXT,
+		size_bytes INTEGER,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		completed_at TIMESTAMP,
+		expires_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(dataExportTable)
+	if err != nil {
+		return err
+	}
+
+	organizationTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err = c.db.Exec(organizationTable)
+	if err != nil {
+		return err
+	}
+
+	organizationMemberTable := `
+	CREATE TABLE IF NOT EXISTS organization_members (
+		organization_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(organization_id, user_id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(organizationMemberTable)
+	if err != nil {
+		return err
+	}
+
+	webhookTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(webhookTable)
+	if err != nil {
+		return err
+	}
+
+	webhookDeliveryTable := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL,
+		completed_at TIMESTAMP,
+		FOREIGN KEY(webhook_id) REFERENCES webhooks(id)
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at);
+	`
+	_, err = c.db.Exec(webhookDeliveryTable)
+	if err != nil {
+		return err
+	}
+
+	webhookDeliveryAttemptTable := `
+	CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		delivery_id TEXT NOT NULL,
+		attempted_at TIMESTAMP NOT NULL,
+		status_code INTEGER,
+		error TEXT NOT NULL DEFAULT '',
+		response_body TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL,
+		FOREIGN KEY(delivery_id) REFERENCES webhook_deliveries(id)
+	);
+	CREATE INDEX IF NOT EXISTS webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts(delivery_id);
+	`
+	_, err = c.db.Exec(webhookDeliveryAttemptTable)
+	if err != nil {
+		return err
+	}
+
+	auditEventTable := `
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP NOT NULL,
+		action TEXT NOT NULL,
+		actor_id TEXT,
+		target_type TEXT NOT NULL DEFAULT '',
+		target_id TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		details TEXT NOT NULL DEFAULT '{}'
+	);
+	CREATE INDEX IF NOT EXISTS audit_events_actor_id ON audit_events(actor_id);
+	CREATE INDEX IF NOT EXISTS audit_events_target_id ON audit_events(target_id);
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+	BEGIN
+		SELECT RAISE(ABORT, 'audit events are append-only');
+	END;
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+	BEGIN
+		SELECT RAISE(ABORT, 'audit events are append-only');
+	END;
+	`
+	_, err = c.db.Exec(auditEventTable)
+	if err != nil {
+		return err
+	}
+
 	videoTable := `
 	CREATE TABLE IF NOT EXISTS videos (
 		id TEXT PRIMARY KEY,
@@ -65,21 +539,191 @@ func (c *Client) autoMigrate() error {
 		thumbnail_url TEXT,
 		video_url TEXT TEXT,
 		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
+		organization_id TEXT,
+		video_size_bytes INTEGER NOT NULL DEFAULT 0,
+		thumbnail_size_bytes INTEGER NOT NULL DEFAULT 0,
+		duration_seconds REAL,
+		orientation TEXT,
+		version INTEGER NOT NULL DEFAULT 1,
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
 	);
 	`
 	_, err = c.db.Exec(videoTable)
 	if err != nil {
 		return err
 	}
+	if _, err = c.addColumnIfMissing("videos", "organization_id", "TEXT REFERENCES organizations(id)"); err != nil {
+		return err
+	}
+	if _, err = c.addColumnIfMissing("videos", "video_size_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err = c.addColumnIfMissing("videos", "thumbnail_size_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err = c.addColumnIfMissing("videos", "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if _, err = c.addColumnIfMissing("videos", "duration_seconds", "REAL"); err != nil {
+		return err
+	}
+	added, err = c.addColumnIfMissing("videos", "orientation", "TEXT")
+	if err != nil {
+		return err
+	}
+	if added {
+		// uploads have always been stored under a prefix named after their
+		// orientation
+		_, err = c.db.Exec(`
+		UPDATE videos SET orientation = CASE
+			WHEN video_url LIKE '%/landscape/%' THEN 'landscape'
+			WHEN video_url LIKE '%/portrait/%' THEN 'portrait'
+			WHEN video_url IS NOT NULL THEN 'other'
+		END
+		`)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err = c.addColumnIfMissing("videos", "review_status", "TEXT NOT NULL DEFAULT 'draft'"); err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`
+	CREATE INDEX IF NOT EXISTS videos_user_id ON videos(user_id);
+	CREATE INDEX IF NOT EXISTS videos_organization_id ON videos(organization_id);
+	`)
+	if err != nil {
+		return err
+	}
+	if err = c.migrateVideoSearch(); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
+// addColumnIfMissing adds a column to a table created by an older version of
+// autoMigrate. It reports whether the column had to be added.
+func (c *Client) addColumnIfMissing(table, column, definition string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
+
+// Reset deletes all data except the audit log, which is append-only and
+// records the reset itself, and the account of the admin keeping the user
+// with ID keepUserID able to sign in: its refresh tokens, second factors,
+// linked identities and passkeys are kept too.
+func (c Client) Reset(keepUserID uuid.UUID) error {
+	keep := keepUserID.String()
+	if _, err := c.db.Exec("DELETE FROM refresh_tokens WHERE user_id != ?", keep); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
+	if _, err := c.db.Exec("DELETE FROM password_reset_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table password_reset_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM totp_recovery_codes WHERE user_id != ?", keep); err != nil {
+		return fmt.Errorf("failed to reset table totp_recovery_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_totp WHERE user_id != ?", keep); err != nil {
+		return fmt.Errorf("failed to reset table user_totp: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities WHERE user_id != ?", keep); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM oidc_login_states"); err != nil {
+		return fmt.Errorf("failed to reset table oidc_login_states: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webauthn_credentials WHERE user_id != ?", keep); err != nil {
+		return fmt.Errorf("failed to reset table webauthn_credentials: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webauthn_sessions"); err != nil {
+		return fmt.Errorf("failed to reset table webauthn_sessions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM device_authorizations"); err != nil {
+		return fmt.Errorf("failed to reset table device_authorizations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM data_exports"); err != nil {
+		return fmt.Errorf("failed to reset table data_exports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_delivery_attempts"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_delivery_attempts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_deliveries"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_deliveries: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhooks"); err != nil {
+		return fmt.Errorf("failed to reset table webhooks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM watch_progress"); err != nil {
+		return fmt.Errorf("failed to reset table watch_progress: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playback_sessions"); err != nil {
+		return fmt.Errorf("failed to reset table playback_sessions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_daily_stats"); err != nil {
+		return fmt.Errorf("failed to reset table video_daily_stats: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM annotations"); err != nil {
+		return fmt.Errorf("failed to reset table annotations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_review_transitions"); err != nil {
+		return fmt.Errorf("failed to reset table video_review_transitions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM comments"); err != nil {
+		return fmt.Errorf("failed to reset table comments: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlist_videos"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_videos: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to reset table tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_collaborators"); err != nil {
+		return fmt.Errorf("failed to reset table video_collaborators: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organization_members"); err != nil {
+		return fmt.Errorf("failed to reset table organization_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organizations"); err != nil {
+		return fmt.Errorf("failed to reset table organizations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM users WHERE id != ?", keep); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newTestClient returns a client for a new, migrated database in a temporary
+// directory.
+func newTestClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.db.Close() })
+	return c
+}
+
+// newTestUser creates a user with a unique email.
+func newTestUser(t *testing.T, c Client) User {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{Email: uuid.NewString() + "@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return *user
+}
+
+// newTestVideo creates a personal video of the user.
+func newTestVideo(t *testing.T, c Client, userID uuid.UUID) Video {
+	t.Helper()
+	video, err := c.CreateVideo(CreateVideoParams{Title: "video", Description: "description", UserID: userID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	return video
+}
+
+func TestResetKeepsUser(t *testing.T) {
+	c := newTestClient(t)
+	admin := newTestUser(t, c)
+	other := newTestUser(t, c)
+	for _, user := range []User{admin, other} {
+		newTestVideo(t, c, user.ID)
+		_, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: user.ID.String(), UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+	}
+
+	if err := c.Reset(admin.ID); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	users, err := c.GetUsers()
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != 1 || users[0].ID != admin.ID {
+		t.Errorf("users = %v, want only %s", users, admin.ID)
+	}
+	for _, user := range []User{admin, other} {
+		token, err := c.GetRefreshToken(user.ID.String())
+		if err != nil {
+			t.Fatalf("GetRefreshToken: %v", err)
+		}
+		if kept, want := token.Token != "", user.ID == admin.ID; kept != want {
+			t.Errorf("refresh token of %s kept = %v, want %v", user.Email, kept, want)
+		}
+		videos, err := c.GetPersonalVideos(user.ID)
+		if err != nil {
+			t.Fatalf("GetPersonalVideos: %v", err)
+		}
+		if len(videos) != 0 {
+			t.Errorf("%s has %d videos left, want 0", user.Email, len(videos))
+		}
+	}
+}
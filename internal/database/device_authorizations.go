@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeviceAuthorization is a pending OAuth device authorization grant
+// (RFC 8628): a device polls with the device code while a signed-in user
+// approves the user code.
+type DeviceAuthorization struct {
+	DeviceCodeHash string
+	UserCode       string
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+	Interval       time.Duration
+	LastPolledAt   *time.Time
+	UserID         *uuid.UUID
+	ApprovedAt     *time.Time
+	DeniedAt       *time.Time
+	ConsumedAt     *time.Time
+}
+
+type CreateDeviceAuthorizationParams struct {
+	DeviceCodeHash string
+	UserCode       string
+	ExpiresAt      time.Time
+	Interval       time.Duration
+}
+
+const deviceAuthorizationColumns = `device_code_hash, user_code, created_at, expires_at, interval_seconds, last_polled_at, user_id, approved_at, denied_at, consumed_at`
+
+func scanDeviceAuthorization(row rowScanner) (DeviceAuthorization, error) {
+	var d DeviceAuthorization
+	var intervalSeconds int64
+	var userID *string
+	err := row.Scan(
+		&d.DeviceCodeHash,
+		&d.UserCode,
+		&d.CreatedAt,
+		&d.ExpiresAt,
+		&intervalSeconds,
+		&d.LastPolledAt,
+		&userID,
+		&d.ApprovedAt,
+		&d.DeniedAt,
+		&d.ConsumedAt,
+	)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	d.Interval = time.Duration(intervalSeconds) * time.Second
+	if userID != nil {
+		id, err := uuid.Parse(*userID)
+		if err != nil {
+			return DeviceAuthorization{}, err
+		}
+		d.UserID = &id
+	}
+	return d, nil
+}
+
+func (c Client) CreateDeviceAuthorization(params CreateDeviceAuthorizationParams) error {
+	// drop grants that can no longer be used so user codes can be reused
+	_, err := c.db.Exec("DELETE FROM device_authorizations WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO device_authorizations (device_code_hash, user_code, created_at, expires_at, interval_seconds)
+		VALUES (?, ?, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err = c.db.Exec(query, params.DeviceCodeHash, params.UserCode, params.ExpiresAt, int64(params.Interval/time.Second))
+	return err
+}
+
+// GetDeviceAuthorizationByDeviceCode returns the grant for the hashed device
+// code, or nil if there is none.
+func (c Client) GetDeviceAuthorizationByDeviceCode(deviceCodeHash string) (*DeviceAuthorization, error) {
+	query := `
+		SELECT ` + deviceAuthorizationColumns + `
+		FROM device_authorizations
+		WHERE device_code_hash = ?
+	`
+	d, err := scanDeviceAuthorization(c.db.QueryRow(query, deviceCodeHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetDeviceAuthorizationByUserCode returns the grant for the user code, or nil
+// if there is none.
+func (c Client) GetDeviceAuthorizationByUserCode(userCode string) (*DeviceAuthorization, error) {
+	query := `
+		SELECT ` + deviceAuthorizationColumns + `
+		FROM device_authorizations
+		WHERE user_code = ?
+	`
+	d, err := scanDeviceAuthorization(c.db.QueryRow(query, userCode))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+// DecideDeviceAuthorization records the user's approval or denial of a grant
+// that is still undecided. It reports false if the grant was already decided.
+func (c Client) DecideDeviceAuthorization(userCode string, userID uuid.UUID, approve bool) (bool, error) {
+	column := "denied_at"
+	if approve {
+		column = "approved_at"
+	}
+	query := `
+		UPDATE device_authorizations
+		SET user_id = ?, ` + column + ` = CURRENT_TIMESTAMP
+		WHERE user_code = ? AND approved_at IS NULL AND denied_at IS NULL
+	`
+	result, err := c.db.Exec(query, userID.String(), userCode)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// PollDeviceAuthorization records a poll by the device and updates the
+// polling interval, which grows when the device polls too fast.
+func (c Client) PollDeviceAuthorization(deviceCodeHash string, interval time.Duration) error {
+	query := `
+		UPDATE device_authorizations
+		SET last_polled_at = ?, interval_seconds = ?
+		WHERE device_code_hash = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), int64(interval/time.Second), deviceCodeHash)
+	return err
+}
+
+// ConsumeDeviceAuthorization marks an approved grant as used. It reports false
+// if it was already used, so a device code yields tokens only once.
+func (c Client) ConsumeDeviceAuthorization(deviceCodeHash string) (bool, error) {
+	query := `
+		UPDATE device_authorizations
+		SET consumed_at = CURRENT_TIMESTAMP
+		WHERE device_code_hash = ? AND approved_at IS NOT NULL AND consumed_at IS NULL
+	`
+	result, err := c.db.Exec(query, deviceCodeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidOIDCState = errors.New("oidc login state is invalid or expired")
+
+// OIDCLoginState is what we remember between sending the user to the identity
+// provider and the provider redirecting back.
+type OIDCLoginState struct {
+	State        string
+	CodeVerifier string
+	Nonce        string
+	ExpiresAt    time.Time
+}
+
+func (c Client) CreateOIDCLoginState(s OIDCLoginState) error {
+	query := `
+		INSERT INTO oidc_login_states (state, code_verifier, nonce, created_at, expires_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	_, err := c.db.Exec(query, s.State, s.CodeVerifier, s.Nonce, s.ExpiresAt)
+	return err
+}
+
+// UseOIDCLoginState deletes and returns the login state, so that each state
+// can complete at most one login.
+func (c Client) UseOIDCLoginState(state string) (OIDCLoginState, error) {
+	// clean up abandoned logins while we're here
+	_, err := c.db.Exec("DELETE FROM oidc_login_states WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return OIDCLoginState{}, err
+	}
+
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state = ?
+		RETURNING state, code_verifier, nonce, expires_at
+	`
+	var s OIDCLoginState
+	err = c.db.QueryRow(query, state).Scan(&s.State, &s.CodeVerifier, &s.Nonce, &s.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OIDCLoginState{}, ErrInvalidOIDCState
+		}
+		return OIDCLoginState{}, err
+	}
+	return s, nil
+}
+
+// GetUserByIdentity returns the user linked to the subject at the given
+// identity provider, or nil if there is none.
+func (c Client) GetUserByIdentity(issuer, subject string) (*User, error) {
+	query := `
+		SELECT ` + prefixedUserColumns("u") + `
+		FROM users u
+		JOIN user_identities ui ON u.id = ui.user_id
+		WHERE ui.issuer = ? AND ui.subject = ?
+	`
+	user, err := scanUser(c.db.QueryRow(query, issuer, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c Client) LinkUserIdentity(userID uuid.UUID, issuer, subject string) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, issuer, subject, userID.String())
+	return err
+}
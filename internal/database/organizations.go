@@ -0,0 +1,387 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OrganizationRole is what a member may do in an organization. Owners manage
+// the organization and its members, editors create and change videos, and
+// viewers only watch them.
+type OrganizationRole string
+
+const (
+	OrganizationRoleOwner  OrganizationRole = "owner"
+	OrganizationRoleEditor OrganizationRole = "editor"
+	OrganizationRoleViewer OrganizationRole = "viewer"
+)
+
+var organizationRoleRanks = map[OrganizationRole]int{
+	OrganizationRoleViewer: 1,
+	OrganizationRoleEditor: 2,
+	OrganizationRoleOwner:  3,
+}
+
+func (r OrganizationRole) Valid() bool {
+	_, ok := organizationRoleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether r grants everything min grants.
+func (r OrganizationRole) AtLeast(min OrganizationRole) bool {
+	return organizationRoleRanks[r] >= organizationRoleRanks[min]
+}
+
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserOrganization is an organization as seen by one of its members.
+type UserOrganization struct {
+	Organization
+	Role OrganizationRole `json:"role"`
+}
+
+type OrganizationMember struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	UserID         uuid.UUID        `json:"user_id"`
+	Email          string           `json:"email"`
+	Name           string           `json:"name"`
+	Role           OrganizationRole `json:"role"`
+	CreatedAt      time.Time        `json:"created_at"`
+}
+
+const organizationColumns = `o.id, o.name, o.created_at, o.updated_at`
+
+func scanOrganization(row rowScanner, extra ...any) (Organization, error) {
+	var org Organization
+	var id string
+	err := row.Scan(append([]any{&id, &org.Name, &org.CreatedAt, &org.UpdatedAt}, extra...)...)
+	if err != nil {
+		return Organization{}, err
+	}
+	org.ID, err = uuid.Parse(id)
+	if err != nil {
+		return Organization{}, err
+	}
+	return org, nil
+}
+
+const organizationMemberColumns = `m.organization_id, m.user_id, u.email, u.name, m.role, m.created_at`
+
+func scanOrganizationMember(row rowScanner) (OrganizationMember, error) {
+	var m OrganizationMember
+	var orgID, userID string
+	err := row.Scan(&orgID, &userID, &m.Email, &m.Name, &m.Role, &m.CreatedAt)
+	if err != nil {
+		return OrganizationMember{}, err
+	}
+	if m.OrganizationID, err = uuid.Parse(orgID); err != nil {
+		return OrganizationMember{}, err
+	}
+	if m.UserID, err = uuid.Parse(userID); err != nil {
+		return OrganizationMember{}, err
+	}
+	return m, nil
+}
+
+// CreateOrganization creates an organization with the given user as its
+// first owner.
+func (c Client) CreateOrganization(name string, ownerID uuid.UUID) (Organization, error) {
+	id := uuid.New()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO organizations (id, name, created_at, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, id.String(), name)
+	if err != nil {
+		return Organization{}, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO organization_members (organization_id, user_id, role, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`, id.String(), ownerID.String(), OrganizationRoleOwner)
+	if err != nil {
+		return Organization{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Organization{}, err
+	}
+
+	org, err := c.GetOrganization(id)
+	if err != nil {
+		return Organization{}, err
+	}
+	return *org, nil
+}
+
+// GetOrganization returns the organization with the given ID, or nil if there
+// is none.
+func (c Client) GetOrganization(id uuid.UUID) (*Organization, error) {
+	query := `
+		SELECT ` + organizationColumns + `
+		FROM organizations o
+		WHERE o.id = ?
+	`
+	org, err := scanOrganization(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetUserOrganizations returns the organizations the user is a member of.
+func (c Client) GetUserOrganizations(userID uuid.UUID) ([]UserOrganization, error) {
+	query := `
+		SELECT ` + organizationColumns + `, m.role
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = ?
+		ORDER BY o.name
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []UserOrganization{}
+	for rows.Next() {
+		var org UserOrganization
+		org.Organization, err = scanOrganization(rows, &org.Role)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// GetSoleOwnedOrganizations returns the organizations in which the user is
+// the only owner, which would be left unmanaged without it.
+func (c Client) GetSoleOwnedOrganizations(userID uuid.UUID) ([]Organization, error) {
+	query := `
+		SELECT ` + organizationColumns + `
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = ? AND m.role = ? AND NOT EXISTS (
+			SELECT 1 FROM organization_members other
+			WHERE other.organization_id = o.id AND other.role = ? AND other.user_id != m.user_id
+		)
+		ORDER BY o.name
+	`
+	rows, err := c.db.Query(query, userID.String(), OrganizationRoleOwner, OrganizationRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []Organization{}
+	for rows.Next() {
+		org, err := scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+func (c Client) RenameOrganization(id uuid.UUID, name string) error {
+	query := `
+		UPDATE organizations
+		SET name = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, name, id.String())
+	return err
+}
+
+// DeleteOrganization deletes the organization and its memberships. Callers
+// must make sure it owns no videos first.
+func (c Client) DeleteOrganization(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM organization_members WHERE organization_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM organizations WHERE id = ?", id.String()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) GetOrganizationMembers(organizationID uuid.UUID) ([]OrganizationMember, error) {
+	query := `
+		SELECT ` + organizationMemberColumns + `
+		FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = ?
+		ORDER BY m.created_at
+	`
+	rows, err := c.db.Query(query, organizationID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrganizationMember{}
+	for rows.Next() {
+		m, err := scanOrganizationMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// GetOrganizationMember returns the user's membership in the organization, or
+// nil if it isn't a member.
+func (c Client) GetOrganizationMember(organizationID, userID uuid.UUID) (*OrganizationMember, error) {
+	query := `
+		SELECT ` + organizationMemberColumns + `
+		FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = ? AND m.user_id = ?
+	`
+	m, err := scanOrganizationMember(c.db.QueryRow(query, organizationID.String(), userID.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+// AddOrganizationMember adds the user to the organization. It reports false
+// if the user already was a member.
+func (c Client) AddOrganizationMember(organizationID, userID uuid.UUID, role OrganizationRole) (bool, error) {
+	query := `
+		INSERT INTO organization_members (organization_id, user_id, role, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (organization_id, user_id) DO NOTHING
+	`
+	result, err := c.db.Exec(query, organizationID.String(), userID.String(), role)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (c Client) SetOrganizationMemberRole(organizationID, userID uuid.UUID, role OrganizationRole) error {
+	query := `
+		UPDATE organization_members
+		SET role = ?
+		WHERE organization_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, role, organizationID.String(), userID.String())
+	return err
+}
+
+func (c Client) RemoveOrganizationMember(organizationID, userID uuid.UUID) error {
+	query := `
+		DELETE FROM organization_members
+		WHERE organization_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, organizationID.String(), userID.String())
+	return err
+}
+
+// CountOrganizationOwners returns how many owners the organization has.
+func (c Client) CountOrganizationOwners(organizationID uuid.UUID) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM organization_members
+		WHERE organization_id = ? AND role = ?
+	`
+	var n int
+	err := c.db.QueryRow(query, organizationID.String(), OrganizationRoleOwner).Scan(&n)
+	return n, err
+}
+
+// CountOrganizationVideos returns how many videos the organization owns.
+func (c Client) CountOrganizationVideos(organizationID uuid.UUID) (int, error) {
+	var n int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM videos WHERE organization_id = ?", organizationID).Scan(&n)
+	return n, err
+}
+
+// reassignOrganizationVideos hands the videos the user created for
+// organizations over to the longest-standing other owner of each
+// organization, tags included, so that they don't keep pointing to the user
+// once it is deleted.
+func reassignOrganizationVideos(tx *sql.Tx, userID uuid.UUID) error {
+	rows, err := tx.Query(`
+	SELECT v.id, (
+		SELECT m.user_id FROM organization_members m
+		WHERE m.organization_id = v.organization_id AND m.role = ? AND m.user_id != ?
+		ORDER BY m.created_at, m.user_id
+		LIMIT 1
+	)
+	FROM videos v
+	WHERE v.user_id = ? AND v.organization_id IS NOT NULL
+	`, OrganizationRoleOwner, userID.String(), userID.String())
+	if err != nil {
+		return err
+	}
+	type handover struct {
+		videoID uuid.UUID
+		ownerID uuid.NullUUID
+	}
+	var handovers []handover
+	for rows.Next() {
+		var h handover
+		if err := rows.Scan(&h.videoID, &h.ownerID); err != nil {
+			rows.Close()
+			return err
+		}
+		handovers = append(handovers, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, h := range handovers {
+		if !h.ownerID.Valid {
+			return fmt.Errorf("no owner left to take over organization video %s", h.videoID)
+		}
+		names, err := videoTagNameList(tx, h.videoID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE videos SET user_id = ? WHERE id = ?", h.ownerID.UUID, h.videoID); err != nil {
+			return err
+		}
+		if err := setVideoTags(tx, h.videoID, h.ownerID.UUID, names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
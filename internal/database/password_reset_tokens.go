@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidPasswordResetToken = errors.New("password reset token is invalid, expired or already used")
+
+type PasswordResetToken struct {
+	TokenHash string     `json:"-"`
+	UserID    uuid.UUID  `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+type CreatePasswordResetTokenParams struct {
+	TokenHash string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+func (c Client) CreatePasswordResetToken(params CreatePasswordResetTokenParams) error {
+	query := `
+		INSERT INTO password_reset_tokens (
+			token_hash,
+			user_id,
+			created_at,
+			expires_at
+		) VALUES (?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	_, err := c.db.Exec(query, params.TokenHash, params.UserID.String(), params.ExpiresAt)
+	return err
+}
+
+// ResetPasswordWithToken uses the token to set the password of the user it
+// was issued for, signs that user out everywhere and returns their ID. A token
+// can only be used once, and only before it expires. The token is only used up
+// if the password is changed.
+func (c Client) ResetPasswordWithToken(tokenHash, hashedPassword string) (uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE password_reset_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id
+	`
+	var userID uuid.UUID
+	err = tx.QueryRow(query, tokenHash, time.Now().UTC()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidPasswordResetToken
+		}
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, hashedPassword, userID.String())
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := revokeRefreshTokens(tx, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResetPasswordWithToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		used      bool
+		wantErr   error
+	}{
+		{name: "valid", expiresIn: time.Hour},
+		{name: "expired", expiresIn: -time.Minute, wantErr: ErrInvalidPasswordResetToken},
+		{name: "already used", expiresIn: time.Hour, used: true, wantErr: ErrInvalidPasswordResetToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			user := newTestUser(t, c)
+			err := c.CreatePasswordResetToken(CreatePasswordResetTokenParams{
+				TokenHash: "token",
+				UserID:    user.ID,
+				ExpiresAt: time.Now().UTC().Add(tt.expiresIn),
+			})
+			if err != nil {
+				t.Fatalf("CreatePasswordResetToken: %v", err)
+			}
+			_, err = c.CreateRefreshToken(CreateRefreshTokenParams{Token: "refresh", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+			if err != nil {
+				t.Fatalf("CreateRefreshToken: %v", err)
+			}
+			if tt.used {
+				if _, err := c.ResetPasswordWithToken("token", "first"); err != nil {
+					t.Fatalf("first ResetPasswordWithToken: %v", err)
+				}
+			}
+
+			userID, err := c.ResetPasswordWithToken("token", "new")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResetPasswordWithToken error = %v, want %v", err, tt.wantErr)
+			}
+			got, err := c.GetUser(user.ID)
+			if err != nil {
+				t.Fatalf("GetUser: %v", err)
+			}
+			if tt.wantErr != nil {
+				if got.Password == "new" {
+					t.Errorf("password changed by a rejected token")
+				}
+				return
+			}
+			if userID != user.ID {
+				t.Errorf("user ID = %v, want %v", userID, user.ID)
+			}
+			if got.Password != "new" {
+				t.Errorf("password = %q, want %q", got.Password, "new")
+			}
+			refresh, err := c.GetRefreshToken("refresh")
+			if err != nil {
+				t.Fatalf("GetRefreshToken: %v", err)
+			}
+			if refresh.RevokedAt == nil {
+				t.Errorf("refresh token wasn't revoked")
+			}
+		})
+	}
+}
+
+func TestResetPasswordWithTokenKeepsTokenOnFailure(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	err := c.CreatePasswordResetToken(CreatePasswordResetTokenParams{
+		TokenHash: "token",
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreatePasswordResetToken: %v", err)
+	}
+
+	// make changing the password fail after the token was used
+	_, err = c.db.Exec(`
+	CREATE TRIGGER fail_password_update BEFORE UPDATE OF password ON users
+	BEGIN SELECT RAISE(ABORT, 'password update failed'); END
+	`)
+	if err != nil {
+		t.Fatalf("creating trigger: %v", err)
+	}
+	if _, err := c.ResetPasswordWithToken("token", "new"); err == nil {
+		t.Fatalf("ResetPasswordWithToken succeeded despite the failing update")
+	}
+
+	if _, err := c.db.Exec("DROP TRIGGER fail_password_update"); err != nil {
+		t.Fatalf("dropping trigger: %v", err)
+	}
+	if _, err := c.ResetPasswordWithToken("token", "new"); err != nil {
+		t.Fatalf("token was used up by the failed reset: %v", err)
+	}
+}
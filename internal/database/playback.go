@@ -0,0 +1,300 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PlaybackEventType is what a player reports in a playback beacon.
+type PlaybackEventType string
+
+const (
+	PlaybackEventStart    PlaybackEventType = "start"
+	PlaybackEventProgress PlaybackEventType = "progress"
+	PlaybackEventComplete PlaybackEventType = "complete"
+	PlaybackEventRebuffer PlaybackEventType = "rebuffer"
+)
+
+func (t PlaybackEventType) Valid() bool {
+	switch t {
+	case PlaybackEventStart, PlaybackEventProgress, PlaybackEventComplete, PlaybackEventRebuffer:
+		return true
+	}
+	return false
+}
+
+const (
+	// viewDedupWindow is how long after a counted view the same viewer
+	// starting the video again doesn't count as another view
+	viewDedupWindow = 30 * time.Minute
+	// watchTimeSlack is how much more watch time than the time passed since
+	// the previous beacon of a session a beacon may report
+	watchTimeSlack = 5 * time.Second
+	// playbackSessionRetention is how long sessions are kept after their
+	// last beacon. Only the daily stats are kept for longer.
+	playbackSessionRetention = 24 * time.Hour
+	// maxPlaybackSessions is how many sessions a viewer may start on a video
+	// within playbackSessionWindow. Every session gets its own watch time
+	// slack and counts its quartiles and completion, so this bounds how much
+	// a player making up session IDs can inflate the stats.
+	maxPlaybackSessions   = 10
+	playbackSessionWindow = time.Hour
+)
+
+// ErrTooManyPlaybackSessions is returned for events starting a session when
+// the viewer already started maxPlaybackSessions on the video within
+// playbackSessionWindow.
+var ErrTooManyPlaybackSessions = errors.New("too many playback sessions started")
+
+// PlaybackEvent is a beacon sent by a player. SessionID identifies one
+// playback of the video, chosen by the player.
+type PlaybackEvent struct {
+	VideoID   uuid.UUID
+	UserID    uuid.UUID
+	SessionID string
+	Type      PlaybackEventType
+	// Quartile is 25, 50 or 75 for progress events
+	Quartile int
+	// WatchedSeconds is how much of the video was played since the previous
+	// beacon of the session
+	WatchedSeconds float64
+	// RebufferMS is how long playback stalled, for rebuffer events
+	RebufferMS int64
+	At         time.Time
+}
+
+// PlaybackStats count what happened while a video was played. Each quartile
+// and completion is counted at most once per playback session.
+type PlaybackStats struct {
+	Views          int     `json:"views"`
+	WatchSeconds   float64 `json:"watch_seconds"`
+	Quartile25     int     `json:"quartile_25"`
+	Quartile50     int     `json:"quartile_50"`
+	Quartile75     int     `json:"quartile_75"`
+	Completions    int     `json:"completions"`
+	Rebuffers      int     `json:"rebuffers"`
+	RebufferMS     int64   `json:"rebuffer_ms"`
+	PlaybackStarts int     `json:"playback_starts"`
+}
+
+// Add adds the counts of other to s.
+func (s *PlaybackStats) Add(other PlaybackStats) {
+	s.Views += other.Views
+	s.WatchSeconds += other.WatchSeconds
+	s.Quartile25 += other.Quartile25
+	s.Quartile50 += other.Quartile50
+	s.Quartile75 += other.Quartile75
+	s.Completions += other.Completions
+	s.Rebuffers += other.Rebuffers
+	s.RebufferMS += other.RebufferMS
+	s.PlaybackStarts += other.PlaybackStarts
+}
+
+// VideoDailyStats are the playback stats of a video on one day (UTC).
+type VideoDailyStats struct {
+	Day string `json:"day"`
+	PlaybackStats
+}
+
+// VideoStatsSummary are the playback stats of a video over several days.
+type VideoStatsSummary struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Title   string    `json:"title"`
+	PlaybackStats
+}
+
+const playbackStatsSums = `COALESCE(SUM(ds.views), 0), COALESCE(SUM(ds.watch_seconds), 0),
+	COALESCE(SUM(ds.quartile_25), 0), COALESCE(SUM(ds.quartile_50), 0), COALESCE(SUM(ds.quartile_75), 0),
+	COALESCE(SUM(ds.completions), 0), COALESCE(SUM(ds.rebuffers), 0), COALESCE(SUM(ds.rebuffer_ms), 0),
+	COALESCE(SUM(ds.playback_starts), 0)`
+
+func playbackStatsDest(s *PlaybackStats) []any {
+	return []any{&s.Views, &s.WatchSeconds, &s.Quartile25, &s.Quartile50, &s.Quartile75,
+		&s.Completions, &s.Rebuffers, &s.RebufferMS, &s.PlaybackStarts}
+}
+
+// RecordPlaybackEvent updates the session the event belongs to and adds what
+// it changed to the video's stats for the day. The first event of a session
+// starts it, whatever its type, since beacons can get lost. Starting a
+// session counts a view unless the viewer's last view of the video was
+// counted less than viewDedupWindow ago, and fails with
+// ErrTooManyPlaybackSessions when the viewer started too many sessions on the
+// video lately. Watch time is capped to the time passed since the session's
+// previous event.
+func (c Client) RecordPlaybackEvent(event PlaybackEvent) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	at := event.At.UTC()
+	var stats PlaybackStats
+	var maxQuartile int
+	var completed bool
+	var lastEventAt time.Time
+	err = tx.QueryRow(`
+	SELECT max_quartile, completed, last_event_at
+	FROM playback_sessions
+	WHERE video_id = ? AND user_id = ? AND session_id = ?
+	`, event.VideoID.String(), event.UserID.String(), event.SessionID).Scan(&maxQuartile, &completed, &lastEventAt)
+	newSession := errors.Is(err, sql.ErrNoRows)
+	if err != nil && !newSession {
+		return err
+	}
+
+	if newSession {
+		_, err = tx.Exec("DELETE FROM playback_sessions WHERE last_event_at < ?", at.Add(-playbackSessionRetention))
+		if err != nil {
+			return err
+		}
+		var recentSessions, recentViews int
+		err = tx.QueryRow(`
+		SELECT
+			COUNT(*) FILTER (WHERE started_at > ?),
+			COUNT(*) FILTER (WHERE counted_view AND started_at > ?)
+		FROM playback_sessions
+		WHERE video_id = ? AND user_id = ?
+		`, at.Add(-playbackSessionWindow), at.Add(-viewDedupWindow), event.VideoID.String(), event.UserID.String()).Scan(&recentSessions, &recentViews)
+		if err != nil {
+			return err
+		}
+		if recentSessions >= maxPlaybackSessions {
+			return ErrTooManyPlaybackSessions
+		}
+		stats.PlaybackStarts = 1
+		if recentViews == 0 {
+			stats.Views = 1
+		}
+		_, err = tx.Exec(`
+		INSERT INTO playback_sessions (video_id, user_id, session_id, started_at, last_event_at, counted_view)
+		VALUES (?, ?, ?, ?, ?, ?)
+		`, event.VideoID.String(), event.UserID.String(), event.SessionID, at, at, stats.Views == 1)
+		if err != nil {
+			return err
+		}
+		lastEventAt = at
+	}
+
+	if event.WatchedSeconds > 0 {
+		elapsed := max(at.Sub(lastEventAt), 0) + watchTimeSlack
+		stats.WatchSeconds = min(event.WatchedSeconds, elapsed.Seconds())
+	}
+	quartile := 0
+	switch event.Type {
+	case PlaybackEventProgress:
+		quartile = event.Quartile
+	case PlaybackEventComplete:
+		quartile = 100
+		if !completed {
+			stats.Completions = 1
+		}
+	case PlaybackEventRebuffer:
+		stats.Rebuffers = 1
+		stats.RebufferMS = event.RebufferMS
+	}
+	// a session passing several quartiles at once reached each of them
+	if maxQuartile < 25 && quartile >= 25 {
+		stats.Quartile25 = 1
+	}
+	if maxQuartile < 50 && quartile >= 50 {
+		stats.Quartile50 = 1
+	}
+	if maxQuartile < 75 && quartile >= 75 {
+		stats.Quartile75 = 1
+	}
+
+	_, err = tx.Exec(`
+	UPDATE playback_sessions
+	SET
+		last_event_at = MAX(last_event_at, ?),
+		max_quartile = MAX(max_quartile, ?),
+		completed = completed OR ?
+	WHERE video_id = ? AND user_id = ? AND session_id = ?
+	`, at, quartile, event.Type == PlaybackEventComplete, event.VideoID.String(), event.UserID.String(), event.SessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO video_daily_stats (
+		video_id, day, views, watch_seconds, quartile_25, quartile_50, quartile_75,
+		completions, rebuffers, rebuffer_ms, playback_starts
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (video_id, day) DO UPDATE SET
+		views = views + excluded.views,
+		watch_seconds = watch_seconds + excluded.watch_seconds,
+		quartile_25 = quartile_25 + excluded.quartile_25,
+		quartile_50 = quartile_50 + excluded.quartile_50,
+		quartile_75 = quartile_75 + excluded.quartile_75,
+		completions = completions + excluded.completions,
+		rebuffers = rebuffers + excluded.rebuffers,
+		rebuffer_ms = rebuffer_ms + excluded.rebuffer_ms,
+		playback_starts = playback_starts + excluded.playback_starts
+	`,
+		event.VideoID.String(), at.Format(time.DateOnly), stats.Views, stats.WatchSeconds,
+		stats.Quartile25, stats.Quartile50, stats.Quartile75,
+		stats.Completions, stats.Rebuffers, stats.RebufferMS, stats.PlaybackStarts,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetVideoDailyStats returns the stats of the video for the days from from
+// to to, both included and formatted as time.DateOnly. Days without any
+// playback are left out.
+func (c Client) GetVideoDailyStats(videoID uuid.UUID, from, to string) ([]VideoDailyStats, error) {
+	rows, err := c.db.Query(`
+	SELECT ds.day, `+playbackStatsSums+`
+	FROM video_daily_stats ds
+	WHERE ds.video_id = ? AND ds.day BETWEEN ? AND ?
+	GROUP BY ds.day
+	ORDER BY ds.day
+	`, videoID.String(), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []VideoDailyStats{}
+	for rows.Next() {
+		var s VideoDailyStats
+		if err := rows.Scan(append([]any{&s.Day}, playbackStatsDest(&s.PlaybackStats)...)...); err != nil {
+			return nil, err
+		}
+		days = append(days, s)
+	}
+	return days, rows.Err()
+}
+
+// GetUserVideoStats returns the stats of every video the user created, over
+// the days from from to to, most viewed first.
+func (c Client) GetUserVideoStats(userID uuid.UUID, from, to string) ([]VideoStatsSummary, error) {
+	rows, err := c.db.Query(`
+	SELECT v.id, v.title, `+playbackStatsSums+`
+	FROM videos v
+	LEFT JOIN video_daily_stats ds ON ds.video_id = v.id AND ds.day BETWEEN ? AND ?
+	WHERE v.user_id = ?
+	GROUP BY v.id
+	ORDER BY 3 DESC, v.created_at DESC, v.id
+	`, from, to, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []VideoStatsSummary{}
+	for rows.Next() {
+		var s VideoStatsSummary
+		if err := rows.Scan(append([]any{&s.VideoID, &s.Title}, playbackStatsDest(&s.PlaybackStats)...)...); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecordPlaybackEvent(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// beacon describes an event relative to base
+	type beacon struct {
+		session  string
+		after    time.Duration
+		typ      PlaybackEventType
+		quartile int
+		watched  float64
+		wantErr  error
+	}
+	tests := []struct {
+		name    string
+		beacons []beacon
+		want    PlaybackStats
+	}{
+		{
+			name: "views within the dedup window count once",
+			beacons: []beacon{
+				{session: "a", typ: PlaybackEventStart},
+				{session: "b", after: 10 * time.Minute, typ: PlaybackEventStart},
+			},
+			want: PlaybackStats{Views: 1, PlaybackStarts: 2},
+		},
+		{
+			name: "views after the dedup window count again",
+			beacons: []beacon{
+				{session: "a", typ: PlaybackEventStart},
+				{session: "b", after: 31 * time.Minute, typ: PlaybackEventStart},
+			},
+			want: PlaybackStats{Views: 2, PlaybackStarts: 2},
+		},
+		{
+			name: "the first beacon of a session starts it",
+			beacons: []beacon{
+				{session: "a", typ: PlaybackEventProgress, quartile: 25},
+			},
+			want: PlaybackStats{Views: 1, PlaybackStarts: 1, Quartile25: 1},
+		},
+		{
+			name: "skipped quartiles are reached too",
+			beacons: []beacon{
+				{session: "a", typ: PlaybackEventStart},
+				{session: "a", after: time.Minute, typ: PlaybackEventProgress, quartile: 75},
+			},
+			want: PlaybackStats{Views: 1, PlaybackStarts: 1, Quartile25: 1, Quartile50: 1, Quartile75: 1},
+		},
+		{
+			name: "quartiles and completions count once per session",
+			beacons: []beacon{
+				{session: "a", typ: PlaybackEventStart},
+				{session: "a", after: time.Minute, typ: PlaybackEventProgress, quartile: 50},
+				{session: "a", after: 2 * time.Minute, typ: PlaybackEventProgress, quartile: 25},
+				{session: "a", after: 3 * time.Minute, typ: PlaybackEventComplete},
+				{session: "a", after: 4 * time.Minute, typ: PlaybackEventComplete},
+			},
+			want: PlaybackStats{Views: 1, PlaybackStarts: 1, Quartile25: 1, Quartile50: 1, Quartile75: 1, Completions: 1},
+		},
+		{
+			name: "watch time is capped to the time passed",
+			beacons: []beacon{
+				{session: "a", typ: PlaybackEventStart, watched: 100},
+				{session: "a", after: 10 * time.Second, typ: PlaybackEventProgress, watched: 100},
+				{session: "a", after: 70 * time.Second, typ: PlaybackEventProgress, watched: 30},
+			},
+			// 5s of slack, then 10s plus slack, then all 30s
+			want: PlaybackStats{Views: 1, PlaybackStarts: 1, WatchSeconds: 5 + 15 + 30},
+		},
+		{
+			name: "too many sessions",
+			beacons: func() []beacon {
+				var beacons []beacon
+				for i := range maxPlaybackSessions {
+					beacons = append(beacons, beacon{session: fmt.Sprint(i), after: time.Duration(i) * time.Minute, typ: PlaybackEventStart, watched: 1})
+				}
+				beacons = append(beacons,
+					beacon{session: "one too many", after: 30 * time.Minute, typ: PlaybackEventComplete, watched: 1, wantErr: ErrTooManyPlaybackSessions},
+					// existing sessions go on
+					beacon{session: "0", after: 31 * time.Minute, typ: PlaybackEventComplete},
+					// and new ones may start once the first ones are old enough
+					beacon{session: "later", after: playbackSessionWindow + time.Minute, typ: PlaybackEventStart},
+				)
+				return beacons
+			}(),
+			want: PlaybackStats{Views: 2, PlaybackStarts: maxPlaybackSessions + 1, WatchSeconds: maxPlaybackSessions, Completions: 1, Quartile25: 1, Quartile50: 1, Quartile75: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			user := newTestUser(t, c)
+			video := newTestVideo(t, c, user.ID)
+
+			for i, b := range tt.beacons {
+				err := c.RecordPlaybackEvent(PlaybackEvent{
+					VideoID:        video.ID,
+					UserID:         user.ID,
+					SessionID:      b.session,
+					Type:           b.typ,
+					Quartile:       b.quartile,
+					WatchedSeconds: b.watched,
+					At:             base.Add(b.after),
+				})
+				if !errors.Is(err, b.wantErr) {
+					t.Fatalf("beacon %d: error = %v, want %v", i, err, b.wantErr)
+				}
+			}
+
+			days, err := c.GetVideoDailyStats(video.ID, "2026-01-01", "2026-12-31")
+			if err != nil {
+				t.Fatalf("GetVideoDailyStats: %v", err)
+			}
+			var got PlaybackStats
+			for _, day := range days {
+				got.Add(day.PlaybackStats)
+			}
+			if got != tt.want {
+				t.Errorf("stats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUserVideoStats(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	viewer := newTestUser(t, c)
+	quiet := newTestVideo(t, c, user.ID)
+	popular := newTestVideo(t, c, user.ID)
+	newTestVideo(t, c, viewer.ID)
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, at := range []time.Time{base, base.Add(time.Hour), base.AddDate(0, 0, 1)} {
+		err := c.RecordPlaybackEvent(PlaybackEvent{
+			VideoID:   popular.ID,
+			UserID:    viewer.ID,
+			SessionID: fmt.Sprint(i),
+			Type:      PlaybackEventStart,
+			At:        at,
+		})
+		if err != nil {
+			t.Fatalf("RecordPlaybackEvent: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		from, to  string
+		wantViews []int
+	}{
+		{name: "all days", from: "2026-03-01", to: "2026-03-02", wantViews: []int{3, 0}},
+		{name: "one day", from: "2026-03-02", to: "2026-03-02", wantViews: []int{1, 0}},
+		{name: "no views", from: "2026-04-01", to: "2026-04-30", wantViews: []int{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.GetUserVideoStats(user.ID, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("GetUserVideoStats: %v", err)
+			}
+			if len(got) != 2 {
+				t.Fatalf("got %d videos, want the user's 2", len(got))
+			}
+			if tt.wantViews[0] > 0 && got[0].VideoID != popular.ID {
+				t.Errorf("first video = %s, want the most viewed %s", got[0].VideoID, popular.ID)
+			}
+			for i, s := range got {
+				if s.VideoID != quiet.ID && s.VideoID != popular.ID {
+					t.Errorf("got stats of %s, which the user didn't create", s.VideoID)
+				}
+				if s.Views != tt.wantViews[i] {
+					t.Errorf("video %d: views = %d, want %d", i, s.Views, tt.wantViews[i])
+				}
+			}
+		})
+	}
+}
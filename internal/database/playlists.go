@@ -0,0 +1,335 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PlaylistVisibility is who can see a playlist besides its owner. Unlisted
+// playlists can be opened by anyone who knows their ID, public ones are also
+// listed on the owner's profile.
+type PlaylistVisibility string
+
+const (
+	PlaylistVisibilityPrivate  PlaylistVisibility = "private"
+	PlaylistVisibilityUnlisted PlaylistVisibility = "unlisted"
+	PlaylistVisibilityPublic   PlaylistVisibility = "public"
+)
+
+func (v PlaylistVisibility) Valid() bool {
+	switch v {
+	case PlaylistVisibilityPrivate, PlaylistVisibilityUnlisted, PlaylistVisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// Playlist is an ordered collection of videos, such as the lessons of a
+// course.
+type Playlist struct {
+	ID          uuid.UUID          `json:"id"`
+	UserID      uuid.UUID          `json:"user_id"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Visibility  PlaylistVisibility `json:"visibility"`
+	// VideoCount includes videos the viewer may not be allowed to watch
+	VideoCount int       `json:"video_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type CreatePlaylistParams struct {
+	UserID      uuid.UUID
+	Title       string
+	Description string
+	Visibility  PlaylistVisibility
+}
+
+// ErrNotInPlaylist is returned when reordering a playlist by videos it
+// doesn't contain.
+var ErrNotInPlaylist = errors.New("video is not in the playlist")
+
+const playlistColumns = `id, user_id, title, description, visibility, (SELECT COUNT(*) FROM playlist_videos WHERE playlist_id = playlists.id), created_at, updated_at`
+
+func scanPlaylist(row rowScanner) (Playlist, error) {
+	var p Playlist
+	err := row.Scan(&p.ID, &p.UserID, &p.Title, &p.Description, &p.Visibility, &p.VideoCount, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+func (c Client) queryPlaylists(query string, args ...any) ([]Playlist, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		playlist, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	_, err := c.db.Exec(`
+	INSERT INTO playlists (id, user_id, title, description, visibility, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, id.String(), params.UserID.String(), params.Title, params.Description, params.Visibility)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	playlist, err := c.GetPlaylist(id)
+	if err != nil {
+		return Playlist{}, err
+	}
+	return *playlist, nil
+}
+
+// GetPlaylist returns the playlist, or nil if there is none with that ID.
+func (c Client) GetPlaylist(id uuid.UUID) (*Playlist, error) {
+	playlist, err := scanPlaylist(c.db.QueryRow("SELECT "+playlistColumns+" FROM playlists WHERE id = ?", id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+// GetPlaylists returns every playlist of the user, most recently changed
+// first.
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	return c.queryPlaylists(`
+	SELECT `+playlistColumns+`
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY updated_at DESC
+	`, userID.String())
+}
+
+// GetPublicPlaylists is GetPlaylists for the user's public playlists only.
+func (c Client) GetPublicPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	return c.queryPlaylists(`
+	SELECT `+playlistColumns+`
+	FROM playlists
+	WHERE user_id = ? AND visibility = ?
+	ORDER BY updated_at DESC
+	`, userID.String(), PlaylistVisibilityPublic)
+}
+
+// UpdatePlaylist saves the title, description and visibility of the playlist.
+func (c Client) UpdatePlaylist(playlist Playlist) error {
+	_, err := c.db.Exec(`
+	UPDATE playlists
+	SET title = ?, description = ?, visibility = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, playlist.Title, playlist.Description, playlist.Visibility, playlist.ID.String())
+	return err
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM playlist_videos WHERE playlist_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM playlists WHERE id = ?", id.String()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPlaylistVideos returns the videos of the playlist in order.
+func (c Client) GetPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	return c.queryVideos(`
+	SELECT `+videoColumns+`
+	FROM playlist_videos
+	JOIN videos ON videos.id = playlist_videos.video_id
+	WHERE playlist_videos.playlist_id = ?
+	ORDER BY playlist_videos.position
+	`, playlistID.String())
+}
+
+// AddPlaylistVideo inserts the video into the playlist at position, counted
+// from 0, or at the end if position is nil or past the end. It reports false
+// if the video already is in the playlist.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID, position *int) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM playlist_videos WHERE playlist_id = ?", playlistID.String()).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	at := count
+	if position != nil {
+		at = max(min(*position, count), 0)
+	}
+
+	// positions are only unique between transactions, so shifting the videos
+	// after the new one doesn't collide with it
+	_, err = tx.Exec(`
+	UPDATE playlist_videos
+	SET position = position + 1
+	WHERE playlist_id = ? AND position >= ?
+	`, playlistID.String(), at)
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(`
+	INSERT INTO playlist_videos (playlist_id, video_id, position, added_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (playlist_id, video_id) DO NOTHING
+	`, playlistID.String(), videoID.String(), at)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := touchPlaylist(tx, playlistID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RemovePlaylistVideo removes the video from the playlist. It reports false
+// if the video wasn't in the playlist.
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM playlist_videos WHERE playlist_id = ? AND video_id = ?", playlistID.String(), videoID.String())
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := renumberPlaylist(tx, playlistID); err != nil {
+		return false, err
+	}
+	if err := touchPlaylist(tx, playlistID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ReorderPlaylist moves the given videos of the playlist to its start, in
+// the given order. Videos left out keep their order after them. It returns
+// ErrNotInPlaylist if any of the videos isn't in the playlist.
+func (c Client) ReorderPlaylist(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// push every video past the end first, so those left out sort after the
+	// reordered ones
+	_, err = tx.Exec(`
+	UPDATE playlist_videos
+	SET position = position + ?
+	WHERE playlist_id = ?
+	`, len(videoIDs), playlistID.String())
+	if err != nil {
+		return err
+	}
+	for i, videoID := range videoIDs {
+		result, err := tx.Exec(`
+		UPDATE playlist_videos
+		SET position = ?
+		WHERE playlist_id = ? AND video_id = ?
+		`, i, playlistID.String(), videoID.String())
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrNotInPlaylist
+		}
+	}
+	if err := renumberPlaylist(tx, playlistID); err != nil {
+		return err
+	}
+	if err := touchPlaylist(tx, playlistID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// renumberPlaylist closes the gaps left in the positions of the playlist's
+// videos, so they run from 0 without gaps.
+func renumberPlaylist(tx *sql.Tx, playlistID uuid.UUID) error {
+	_, err := tx.Exec(`
+	UPDATE playlist_videos
+	SET position = ranked.position
+	FROM (
+		SELECT video_id, ROW_NUMBER() OVER (ORDER BY position) - 1 AS position
+		FROM playlist_videos
+		WHERE playlist_id = ?
+	) AS ranked
+	WHERE playlist_videos.playlist_id = ? AND playlist_videos.video_id = ranked.video_id
+	`, playlistID.String(), playlistID.String())
+	return err
+}
+
+func touchPlaylist(tx *sql.Tx, playlistID uuid.UUID) error {
+	_, err := tx.Exec("UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", playlistID.String())
+	return err
+}
+
+// deletePlaylistVideos removes the videos matching condition, a condition on
+// video_id, from every playlist.
+func deletePlaylistVideos(tx *sql.Tx, condition string, args ...any) error {
+	rows, err := tx.Query("SELECT DISTINCT playlist_id FROM playlist_videos WHERE "+condition, args...)
+	if err != nil {
+		return err
+	}
+	var playlistIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		playlistIDs = append(playlistIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM playlist_videos WHERE "+condition, args...); err != nil {
+		return err
+	}
+	for _, id := range playlistIDs {
+		if err := renumberPlaylist(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newTestPlaylist creates a playlist holding count new videos, in order.
+func newTestPlaylist(t *testing.T, c Client, count int) (Playlist, []uuid.UUID) {
+	t.Helper()
+	user := newTestUser(t, c)
+	playlist, err := c.CreatePlaylist(CreatePlaylistParams{UserID: user.ID, Title: "playlist", Visibility: PlaylistVisibilityPrivate})
+	if err != nil {
+		t.Fatalf("CreatePlaylist: %v", err)
+	}
+	var videoIDs []uuid.UUID
+	for range count {
+		video := newTestVideo(t, c, user.ID)
+		if _, err := c.AddPlaylistVideo(playlist.ID, video.ID, nil); err != nil {
+			t.Fatalf("AddPlaylistVideo: %v", err)
+		}
+		videoIDs = append(videoIDs, video.ID)
+	}
+	return playlist, videoIDs
+}
+
+// playlistOrder returns the videos of the playlist as indexes into videoIDs,
+// and checks that their positions run from 0 without gaps.
+func playlistOrder(t *testing.T, c Client, playlistID uuid.UUID, videoIDs []uuid.UUID) []int {
+	t.Helper()
+	rows, err := c.db.Query("SELECT video_id, position FROM playlist_videos WHERE playlist_id = ? ORDER BY position", playlistID.String())
+	if err != nil {
+		t.Fatalf("querying positions: %v", err)
+	}
+	defer rows.Close()
+	order := []int{}
+	for rows.Next() {
+		var videoID uuid.UUID
+		var position int
+		if err := rows.Scan(&videoID, &position); err != nil {
+			t.Fatalf("scanning position: %v", err)
+		}
+		if position != len(order) {
+			t.Errorf("video %d has position %d", len(order), position)
+		}
+		order = append(order, slices.Index(videoIDs, videoID))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("querying positions: %v", err)
+	}
+	return order
+}
+
+func TestReorderPlaylist(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   []int
+		foreign bool
+		want    []int
+		wantErr error
+	}{
+		{name: "every video", order: []int{3, 1, 0, 2}, want: []int{3, 1, 0, 2}},
+		{name: "same order", order: []int{0, 1, 2, 3}, want: []int{0, 1, 2, 3}},
+		{name: "some left out", order: []int{2, 0}, want: []int{2, 0, 1, 3}},
+		{name: "none", order: []int{}, want: []int{0, 1, 2, 3}},
+		{name: "video not in playlist", order: []int{1, 0}, foreign: true, want: []int{0, 1, 2, 3}, wantErr: ErrNotInPlaylist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			playlist, videoIDs := newTestPlaylist(t, c, 4)
+
+			var ids []uuid.UUID
+			for _, i := range tt.order {
+				ids = append(ids, videoIDs[i])
+			}
+			if tt.foreign {
+				other := newTestVideo(t, c, playlist.UserID)
+				ids = append(ids, other.ID)
+			}
+
+			err := c.ReorderPlaylist(playlist.ID, ids)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReorderPlaylist error = %v, want %v", err, tt.wantErr)
+			}
+			if got := playlistOrder(t, c, playlist.ID, videoIDs); !slices.Equal(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddAndRemovePlaylistVideo(t *testing.T) {
+	c := newTestClient(t)
+	playlist, videoIDs := newTestPlaylist(t, c, 3)
+
+	video := newTestVideo(t, c, playlist.UserID)
+	videoIDs = append(videoIDs, video.ID)
+	if added, err := c.AddPlaylistVideo(playlist.ID, video.ID, ptr(1)); err != nil || !added {
+		t.Fatalf("AddPlaylistVideo = %v, %v", added, err)
+	}
+	if got, want := playlistOrder(t, c, playlist.ID, videoIDs), []int{0, 3, 1, 2}; !slices.Equal(got, want) {
+		t.Errorf("order after insert = %v, want %v", got, want)
+	}
+	if added, err := c.AddPlaylistVideo(playlist.ID, video.ID, nil); err != nil || added {
+		t.Errorf("adding the video again = %v, %v, want false", added, err)
+	}
+
+	if removed, err := c.RemovePlaylistVideo(playlist.ID, videoIDs[1]); err != nil || !removed {
+		t.Fatalf("RemovePlaylistVideo = %v, %v", removed, err)
+	}
+	if got, want := playlistOrder(t, c, playlist.ID, videoIDs), []int{0, 3, 2}; !slices.Equal(got, want) {
+		t.Errorf("order after removal = %v, want %v", got, want)
+	}
+	if removed, err := c.RemovePlaylistVideo(playlist.ID, videoIDs[1]); err != nil || removed {
+		t.Errorf("removing the video again = %v, %v, want false", removed, err)
+	}
+}
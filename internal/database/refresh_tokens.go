@@ -48,6 +48,18 @@ func (c Client) RevokeRefreshToken(token string) error {
 	return err
 }
 
+// revokeRefreshTokens revokes every refresh token of the user that hasn't
+// been revoked yet, signing it out of all sessions.
+func revokeRefreshTokens(tx *sql.Tx, userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := tx.Exec(query, userID.String())
+	return err
+}
+
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
 		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReviewStatus is where a video stands in being signed off. Videos start as
+// drafts, are submitted for review, and are then either approved or sent back
+// with changes requested.
+type ReviewStatus string
+
+const (
+	ReviewStatusDraft            ReviewStatus = "draft"
+	ReviewStatusInReview         ReviewStatus = "in_review"
+	ReviewStatusChangesRequested ReviewStatus = "changes_requested"
+	ReviewStatusApproved         ReviewStatus = "approved"
+)
+
+// reviewTransitions are the statuses each status can move to.
+var reviewTransitions = map[ReviewStatus][]ReviewStatus{
+	ReviewStatusDraft:            {ReviewStatusInReview},
+	ReviewStatusInReview:         {ReviewStatusDraft, ReviewStatusChangesRequested, ReviewStatusApproved},
+	ReviewStatusChangesRequested: {ReviewStatusDraft, ReviewStatusInReview},
+	ReviewStatusApproved:         {ReviewStatusDraft, ReviewStatusInReview},
+}
+
+func (s ReviewStatus) Valid() bool {
+	_, ok := reviewTransitions[s]
+	return ok
+}
+
+// CanMoveTo reports whether a video can go from s to status.
+func (s ReviewStatus) CanMoveTo(status ReviewStatus) bool {
+	return slices.Contains(reviewTransitions[s], status)
+}
+
+// ReviewTransition records a change of a video's review status.
+type ReviewTransition struct {
+	ID        int64        `json:"id"`
+	VideoID   uuid.UUID    `json:"video_id"`
+	UserID    uuid.UUID    `json:"user_id"`
+	From      ReviewStatus `json:"from"`
+	To        ReviewStatus `json:"to"`
+	Note      string       `json:"note"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// SetVideoReviewStatus moves the video from one review status to another and
+// records the transition. It returns nil if the video isn't in the from
+// status anymore, or doesn't exist.
+func (c Client) SetVideoReviewStatus(videoID uuid.UUID, from, to ReviewStatus, userID uuid.UUID, note string) (*Video, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	UPDATE videos
+	SET review_status = ?, updated_at = CURRENT_TIMESTAMP, version = version + 1
+	WHERE id = ? AND review_status = ?
+	`, to, videoID, from)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO video_review_transitions (video_id, user_id, from_status, to_status, note, created_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, videoID.String(), userID.String(), from, to, note)
+	if err != nil {
+		return nil, err
+	}
+
+	video, err := scanVideo(tx.QueryRow("SELECT "+videoColumns+" FROM videos WHERE id = ?", videoID))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &video, nil
+}
+
+// GetVideoReviewTransitions returns the review history of the video, oldest
+// first.
+func (c Client) GetVideoReviewTransitions(videoID uuid.UUID) ([]ReviewTransition, error) {
+	rows, err := c.db.Query(`
+	SELECT id, video_id, user_id, from_status, to_status, note, created_at
+	FROM video_review_transitions
+	WHERE video_id = ?
+	ORDER BY id
+	`, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []ReviewTransition{}
+	for rows.Next() {
+		var t ReviewTransition
+		if err := rows.Scan(&t.ID, &t.VideoID, &t.UserID, &t.From, &t.To, &t.Note, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, rows.Err()
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReviewStatusCanMoveTo(t *testing.T) {
+	statuses := []ReviewStatus{ReviewStatusDraft, ReviewStatusInReview, ReviewStatusChangesRequested, ReviewStatusApproved}
+	allowed := map[[2]ReviewStatus]bool{
+		{ReviewStatusDraft, ReviewStatusInReview}:            true,
+		{ReviewStatusInReview, ReviewStatusDraft}:            true,
+		{ReviewStatusInReview, ReviewStatusChangesRequested}: true,
+		{ReviewStatusInReview, ReviewStatusApproved}:         true,
+		{ReviewStatusChangesRequested, ReviewStatusDraft}:    true,
+		{ReviewStatusChangesRequested, ReviewStatusInReview}: true,
+		{ReviewStatusApproved, ReviewStatusDraft}:            true,
+		{ReviewStatusApproved, ReviewStatusInReview}:         true,
+	}
+	for _, from := range statuses {
+		if !from.Valid() {
+			t.Errorf("%s isn't valid", from)
+		}
+		for _, to := range statuses {
+			if got, want := from.CanMoveTo(to), allowed[[2]ReviewStatus{from, to}]; got != want {
+				t.Errorf("%s.CanMoveTo(%s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if ReviewStatus("published").Valid() {
+		t.Errorf("unknown status is valid")
+	}
+}
+
+func TestSetVideoReviewStatus(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	video := newTestVideo(t, c, user.ID)
+	if video.ReviewStatus != ReviewStatusDraft {
+		t.Fatalf("new video status = %s, want %s", video.ReviewStatus, ReviewStatusDraft)
+	}
+
+	steps := []struct {
+		from, to ReviewStatus
+		moved    bool
+	}{
+		{ReviewStatusDraft, ReviewStatusInReview, true},
+		{ReviewStatusInReview, ReviewStatusChangesRequested, true},
+		// read before the last move
+		{ReviewStatusInReview, ReviewStatusApproved, false},
+		{ReviewStatusChangesRequested, ReviewStatusInReview, true},
+		{ReviewStatusInReview, ReviewStatusApproved, true},
+	}
+	var want []ReviewTransition
+	for _, step := range steps {
+		updated, err := c.SetVideoReviewStatus(video.ID, step.from, step.to, user.ID, "note")
+		if err != nil {
+			t.Fatalf("SetVideoReviewStatus(%s, %s): %v", step.from, step.to, err)
+		}
+		if (updated != nil) != step.moved {
+			t.Fatalf("SetVideoReviewStatus(%s, %s) moved = %v, want %v", step.from, step.to, updated != nil, step.moved)
+		}
+		if !step.moved {
+			continue
+		}
+		if updated.ReviewStatus != step.to {
+			t.Errorf("status = %s, want %s", updated.ReviewStatus, step.to)
+		}
+		if updated.Version != video.Version+1 {
+			t.Errorf("version = %d, want %d", updated.Version, video.Version+1)
+		}
+		video = *updated
+		want = append(want, ReviewTransition{VideoID: video.ID, UserID: user.ID, From: step.from, To: step.to, Note: "note"})
+	}
+
+	got, err := c.GetVideoReviewTransitions(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideoReviewTransitions: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d transitions, want %d", len(got), len(want))
+	}
+	for i := range got {
+		got[i].ID, got[i].CreatedAt = 0, want[i].CreatedAt
+		if got[i] != want[i] {
+			t.Errorf("transition %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	missing, err := c.SetVideoReviewStatus(uuid.New(), ReviewStatusDraft, ReviewStatusInReview, user.ID, "")
+	if err != nil || missing != nil {
+		t.Errorf("moving a missing video = %v, %v, want nil", missing, err)
+	}
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tag is a label a user puts on videos. Tags belong to the user who created
+// the videos they are on, so each user has their own set to pick from.
+type Tag struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// VideoCount is how many videos have the tag
+	VideoCount int `json:"video_count"`
+}
+
+// videoTagNames selects the names of the tags of the video with the given ID,
+// separated by spaces, as they are indexed for search.
+func videoTagNames(videoID string) string {
+	return `(
+		SELECT COALESCE(group_concat(t.name, ' '), '')
+		FROM video_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		WHERE vt.video_id = ` + videoID + `
+	)`
+}
+
+// videoTagNameList returns the names of the tags of the video.
+func videoTagNameList(tx *sql.Tx, videoID uuid.UUID) ([]string, error) {
+	rows, err := tx.Query(`
+	SELECT t.name
+	FROM video_tags vt
+	JOIN tags t ON t.id = vt.tag_id
+	WHERE vt.video_id = ?
+	ORDER BY t.name
+	`, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// setVideoTags replaces the tags of a video with the named tags of the user,
+// creating those the user doesn't have yet.
+func setVideoTags(tx *sql.Tx, videoID, userID uuid.UUID, names []string) error {
+	if _, err := tx.Exec("DELETE FROM video_tags WHERE video_id = ?", videoID.String()); err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, err := tx.Exec(`
+		INSERT INTO tags (id, user_id, name, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id, name) DO NOTHING
+		`, uuid.New(), userID.String(), name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+		INSERT INTO video_tags (video_id, tag_id)
+		SELECT ?, id FROM tags WHERE user_id = ? AND name = ?
+		`, videoID.String(), userID.String(), name)
+		if err != nil {
+			return err
+		}
+	}
+	return deleteUnusedTags(tx, userID)
+}
+
+// deleteUnusedTags deletes the tags of the user that are on no video, so they
+// stop being suggested.
+func deleteUnusedTags(tx *sql.Tx, userID uuid.UUID) error {
+	_, err := tx.Exec(`
+	DELETE FROM tags
+	WHERE user_id = ? AND id NOT IN (SELECT tag_id FROM video_tags)
+	`, userID.String())
+	return err
+}
+
+// GetTags returns the user's tags starting with prefix, most used first, for
+// autocompletion.
+func (c Client) GetTags(userID uuid.UUID, prefix string, limit int) ([]Tag, error) {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	rows, err := c.db.Query(`
+	SELECT t.id, t.user_id, t.name, t.created_at, COUNT(*)
+	FROM tags t
+	JOIN video_tags vt ON vt.tag_id = t.id
+	WHERE t.user_id = ? AND t.name LIKE ? ESCAPE '\'
+	GROUP BY t.id
+	ORDER BY COUNT(*) DESC, t.name
+	LIMIT ?
+	`, userID.String(), escaper.Replace(prefix)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.CreatedAt, &tag.VideoCount); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
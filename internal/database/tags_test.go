@@ -0,0 +1,57 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestGetTags(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	other := newTestUser(t, c)
+
+	setTags := func(video Video, tags ...string) {
+		t.Helper()
+		_, err := c.UpdateVideoMetadata(video.ID, UpdateVideoMetadataParams{Tags: &tags}, 0)
+		if err != nil {
+			t.Fatalf("UpdateVideoMetadata: %v", err)
+		}
+	}
+	first := newTestVideo(t, c, user.ID)
+	second := newTestVideo(t, c, user.ID)
+	setTags(first, "go", "golang", "100%", "old")
+	setTags(second, "golang", "1000")
+	setTags(first, "go", "golang", "100%")
+	setTags(newTestVideo(t, c, other.ID), "gopher")
+
+	tests := []struct {
+		name   string
+		prefix string
+		limit  int
+		want   []string
+		counts []int
+	}{
+		{name: "all", prefix: "", limit: 10, want: []string{"golang", "100%", "1000", "go"}, counts: []int{2, 1, 1, 1}},
+		{name: "prefix", prefix: "go", limit: 10, want: []string{"golang", "go"}, counts: []int{2, 1}},
+		{name: "limit", prefix: "go", limit: 1, want: []string{"golang"}, counts: []int{2}},
+		{name: "percent is literal", prefix: "100%", limit: 10, want: []string{"100%"}, counts: []int{1}},
+		{name: "underscore is literal", prefix: "10_", limit: 10, want: []string{}},
+		{name: "unused tags are gone", prefix: "old", limit: 10, want: []string{}},
+		{name: "other users' tags", prefix: "gopher", limit: 10, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := c.GetTags(user.ID, tt.prefix, tt.limit)
+			if err != nil {
+				t.Fatalf("GetTags: %v", err)
+			}
+			if len(tags) != len(tt.want) {
+				t.Fatalf("got %d tags %v, want %v", len(tags), tags, tt.want)
+			}
+			for i, tag := range tags {
+				if tag.Name != tt.want[i] || tag.VideoCount != tt.counts[i] {
+					t.Errorf("tag %d = %q (%d videos), want %q (%d videos)", i, tag.Name, tag.VideoCount, tt.want[i], tt.counts[i])
+				}
+			}
+		})
+	}
+}
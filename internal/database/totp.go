@@ -0,0 +1,140 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserTOTP struct {
+	UserID       uuid.UUID  `json:"user_id"`
+	Secret       string     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	LastUsedStep int64      `json:"-"`
+}
+
+// GetUserTOTP returns the TOTP enrollment of the user, or nil if it has none.
+func (c Client) GetUserTOTP(userID uuid.UUID) (*UserTOTP, error) {
+	query := `
+		SELECT user_id, secret, created_at, confirmed_at, last_used_step
+		FROM user_totp
+		WHERE user_id = ?
+	`
+	var t UserTOTP
+	var id string
+	err := c.db.QueryRow(query, userID.String()).Scan(&id, &t.Secret, &t.CreatedAt, &t.ConfirmedAt, &t.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	t.UserID, err = uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// StartUserTOTPEnrollment stores a new, unconfirmed secret for the user,
+// replacing any previous unconfirmed one.
+func (c Client) StartUserTOTPEnrollment(userID uuid.UUID, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, created_at, last_used_step)
+		VALUES (?, ?, CURRENT_TIMESTAMP, 0)
+		ON CONFLICT(user_id) DO UPDATE SET
+			secret = excluded.secret,
+			created_at = excluded.created_at,
+			confirmed_at = NULL,
+			last_used_step = 0
+		WHERE user_totp.confirmed_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), secret)
+	return err
+}
+
+// ConfirmUserTOTP enables TOTP for the user and replaces its recovery codes.
+func (c Client) ConfirmUserTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE user_totp
+		SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = ?
+		WHERE user_id = ?
+	`, step, userID.String())
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID.String()); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(`
+			INSERT INTO totp_recovery_codes (code_hash, user_id, created_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+		`, hash, userID.String())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for step was accepted. It reports false if
+// that step (or a later one) was already used, so codes can't be replayed.
+func (c Client) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	result, err := c.db.Exec(`
+		UPDATE user_totp
+		SET last_used_step = ?
+		WHERE user_id = ? AND last_used_step < ?
+	`, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// UseTOTPRecoveryCode marks an unused recovery code of the user as used. It
+// reports false if there is no such code.
+func (c Client) UseTOTPRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	result, err := c.db.Exec(`
+		UPDATE totp_recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, userID.String(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (c Client) DeleteUserTOTP(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", userID.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID.String()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -3,29 +3,90 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Role       Role       `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
+	VerifiedAt *time.Time `json:"verified_at"`
+	Name       string     `json:"name"`
+	// PendingEmail is the new email of a requested email change, until the
+	// user verifies it.
+	PendingEmail *string `json:"pending_email"`
+	// StorageQuotaBytes overrides the default storage quota when set.
+	StorageQuotaBytes *int64 `json:"storage_quota_bytes"`
 	CreateUserParams
 }
 
 type CreateUserParams struct {
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"-"`
+}
+
+const userColumns = `id, created_at, updated_at, email, password, role, disabled_at, verified_at, name, pending_email, storage_quota_bytes`
+
+// prefixedUserColumns returns userColumns qualified with a table alias, for
+// queries joining users with other tables.
+func prefixedUserColumns(alias string) string {
+	return prefixColumns(userColumns, alias)
+}
+
+// prefixColumns qualifies a comma-separated list of columns with a table
+// alias.
+func prefixColumns(columns, alias string) string {
+	names := strings.Split(columns, ", ")
+	for i, name := range names {
+		names[i] = alias + "." + name
+	}
+	return strings.Join(names, ", ")
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var id string
+	err := row.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.Role, &user.DisabledAt, &user.VerifiedAt, &user.Name, &user.PendingEmail, &user.StorageQuotaBytes)
+	if err != nil {
+		return User{}, err
+	}
+	user.ID, err = uuid.Parse(id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
 }
 
 func (c Client) GetUsers() ([]User, error) {
 	query := `
-		SELECT
-			id,
-			email
+		SELECT ` + userColumns + `
 		FROM users
+		ORDER BY created_at
 	`
 
 	rows, err := c.db.Query(query)
@@ -36,78 +97,65 @@ func (c Client) GetUsers() ([]User, error) {
 
 	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE email = ?
 	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
 		}
 		return User{}, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return User{}, err
-	}
 	return user, nil
 }
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT ` + prefixedUserColumns("u") + `
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
+		WHERE rt.token = ? AND rt.revoked_at IS NULL AND rt.expires_at > ?
 	`
 
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, token, time.Now().UTC()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
 
 	return &user, nil
 }
 
 func (c Client) CreateUser(params CreateUserParams) (*User, error) {
+	return c.CreateUserWithRole(params, RoleUser)
+}
+
+func (c Client) CreateUserWithRole(params CreateUserParams, role Role) (*User, error) {
 	id := uuid.New()
 
 	query := `
 		INSERT INTO users
-		    (id, created_at, updated_at, email, password)
+		    (id, created_at, updated_at, email, password, role)
 		VALUES
-		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	_, err := c.db.Exec(query, id.String(), params.Email, params.Password, role)
 	if err != nil {
 		return nil, err
 	}
@@ -117,31 +165,295 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE id = ?
 	`
-	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, id.String()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
+	return &user, nil
+}
+
+func (c Client) SetUserRole(id uuid.UUID, role Role) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, role, id.String())
+	return err
+}
+
+// UpdateUserPassword sets the user's password and signs the user out of all
+// sessions, both or neither.
+func (c Client) UpdateUserPassword(id uuid.UUID, hashedPassword string) error {
+	tx, err := c.db.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &user, nil
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	if _, err := tx.Exec(query, hashedPassword, id.String()); err != nil {
+		return err
+	}
+	if err := revokeRefreshTokens(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (c Client) DeleteUser(id uuid.UUID) error {
+func (c Client) UpdateUserName(id uuid.UUID, name string) error {
+	query := `
+		UPDATE users
+		SET name = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, name, id.String())
+	return err
+}
+
+// SetUserStorageQuota sets the user's storage quota in bytes. A nil quota
+// makes the user fall back to the default quota.
+func (c Client) SetUserStorageQuota(id uuid.UUID, quotaBytes *int64) error {
 	query := `
-		DELETE FROM users
+		UPDATE users
+		SET storage_quota_bytes = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	_, err := c.db.Exec(query, quotaBytes, id.String())
 	return err
 }
+
+// SetUserPendingEmail records an email change that takes effect once the user
+// verifies the new address with ConfirmUserPendingEmail.
+func (c Client) SetUserPendingEmail(id uuid.UUID, email string) error {
+	query := `
+		UPDATE users
+		SET pending_email = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, email, id.String())
+	return err
+}
+
+// ConfirmUserPendingEmail makes the pending email the user's email, as long
+// as the pending email is still the given one. It reports whether the user
+// was updated.
+func (c Client) ConfirmUserPendingEmail(id uuid.UUID, email string) (bool, error) {
+	query := `
+		UPDATE users
+		SET email = pending_email, pending_email = NULL, verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND pending_email = ?
+	`
+	result, err := c.db.Exec(query, id.String(), email)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// MarkUserVerified marks the user's email as verified, as long as it is still
+// the given email. It reports whether the user was updated.
+func (c Client) MarkUserVerified(id uuid.UUID, email string) (bool, error) {
+	query := `
+		UPDATE users
+		SET verified_at = COALESCE(verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND email = ?
+	`
+	result, err := c.db.Exec(query, id.String(), email)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// SetUserDisabled disables the user (or re-enables it when disabled is false).
+// Disabling also revokes every outstanding refresh token of the user.
+func (c Client) SetUserDisabled(id uuid.UUID, disabled bool) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if disabled {
+		_, err = tx.Exec(`
+			UPDATE users
+			SET disabled_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, id.String())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE refresh_tokens
+			SET revoked_at = CURRENT_TIMESTAMP
+			WHERE user_id = ? AND revoked_at IS NULL
+		`, id.String())
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err = tx.Exec(`
+			UPDATE users
+			SET disabled_at = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, id.String())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteUser removes the user together with its tokens, memberships,
+// webhooks, playlists, comments, annotations, playback sessions, watch
+// progress, personal videos and their tags and stats.
+func (c Client) DeleteUser(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM refresh_tokens WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM totp_recovery_codes WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_identities WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webauthn_credentials WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM device_authorizations WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM data_exports WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM webhook_delivery_attempts
+		WHERE delivery_id IN (
+			SELECT d.id FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id WHERE w.user_id = ?
+		)
+	`, id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM webhook_deliveries
+		WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)
+	`, id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM video_collaborators
+		WHERE user_id = ? OR video_id IN (SELECT id FROM videos WHERE user_id = ? AND organization_id IS NULL)
+	`, id.String(), id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM video_tags
+		WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND organization_id IS NULL)
+	`, id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM comments
+		WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND organization_id IS NULL)
+	`, id.String()); err != nil {
+		return err
+	}
+	if err := deleteComments(tx, "user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM annotations
+		WHERE user_id = ? OR video_id IN (SELECT id FROM videos WHERE user_id = ? AND organization_id IS NULL)
+	`, id.String(), id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM video_review_transitions
+		WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND organization_id IS NULL)
+	`, id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM watch_progress
+		WHERE user_id = ? OR video_id IN (SELECT id FROM videos WHERE user_id = ? AND organization_id IS NULL)
+	`, id.String(), id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM playback_sessions
+		WHERE user_id = ? OR video_id IN (SELECT id FROM videos WHERE user_id = ? AND organization_id IS NULL)
+	`, id.String(), id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM video_daily_stats
+		WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND organization_id IS NULL)
+	`, id.String()); err != nil {
+		return err
+	}
+	if err := deletePlaylistVideos(tx, "video_id IN (SELECT id FROM videos WHERE user_id = ? AND organization_id IS NULL)", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM playlist_videos
+		WHERE playlist_id IN (SELECT id FROM playlists WHERE user_id = ?)
+	`, id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM playlists WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	// videos owned by organizations stay with the organization, passed on to
+	// another of its owners
+	if err := reassignOrganizationVideos(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM organization_members WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM videos WHERE user_id = ? AND organization_id IS NULL", id.String()); err != nil {
+		return err
+	}
+	if err := deleteUnusedTags(tx, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id.String()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDeleteUserReassignsOrganizationVideos(t *testing.T) {
+	tests := []struct {
+		name string
+		// deleted picks the member to delete, and wantOwners who may own
+		// their organization video afterwards
+		deleted    func(first, second, editor User) User
+		wantOwners func(first, second, editor User) []User
+	}{
+		{
+			name:       "editor",
+			deleted:    func(first, second, editor User) User { return editor },
+			wantOwners: func(first, second, editor User) []User { return []User{first, second} },
+		},
+		{
+			name:       "owner",
+			deleted:    func(first, second, editor User) User { return first },
+			wantOwners: func(first, second, editor User) []User { return []User{second} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			first := newTestUser(t, c)
+			second := newTestUser(t, c)
+			editor := newTestUser(t, c)
+			org, err := c.CreateOrganization("org", first.ID)
+			if err != nil {
+				t.Fatalf("CreateOrganization: %v", err)
+			}
+			for _, m := range []struct {
+				user User
+				role OrganizationRole
+			}{{second, OrganizationRoleOwner}, {editor, OrganizationRoleEditor}} {
+				if _, err := c.AddOrganizationMember(org.ID, m.user.ID, m.role); err != nil {
+					t.Fatalf("AddOrganizationMember: %v", err)
+				}
+			}
+
+			deleted := tt.deleted(first, second, editor)
+			wantOwners := tt.wantOwners(first, second, editor)
+			orgVideo, err := c.CreateVideo(CreateVideoParams{
+				Title:          "org video",
+				UserID:         deleted.ID,
+				OrganizationID: &org.ID,
+				Tags:           []string{"shared"},
+			})
+			if err != nil {
+				t.Fatalf("CreateVideo: %v", err)
+			}
+			personal := newTestVideo(t, c, deleted.ID)
+
+			if err := c.DeleteUser(deleted.ID); err != nil {
+				t.Fatalf("DeleteUser: %v", err)
+			}
+
+			got, err := c.GetVideo(orgVideo.ID)
+			if err != nil {
+				t.Fatalf("GetVideo: %v", err)
+			}
+			i := slices.IndexFunc(wantOwners, func(u User) bool { return u.ID == got.UserID })
+			if i < 0 {
+				t.Fatalf("organization video owner = %v, want one of the other owners", got.UserID)
+			}
+			newOwner := wantOwners[i]
+			if !slices.Equal(got.Tags, []string{"shared"}) {
+				t.Errorf("organization video tags = %q, want %q", got.Tags, []string{"shared"})
+			}
+			tags, err := c.GetTags(newOwner.ID, "", 10)
+			if err != nil {
+				t.Fatalf("GetTags: %v", err)
+			}
+			if len(tags) != 1 || tags[0].Name != "shared" {
+				t.Errorf("new owner's tags = %v, want the video's tag", tags)
+			}
+			var leftover int
+			if err := c.db.QueryRow("SELECT COUNT(*) FROM tags WHERE user_id = ?", deleted.ID.String()).Scan(&leftover); err != nil {
+				t.Fatalf("counting tags: %v", err)
+			}
+			if leftover != 0 {
+				t.Errorf("deleted user still has %d tags", leftover)
+			}
+
+			gone, err := c.GetVideo(personal.ID)
+			if err != nil {
+				t.Fatalf("GetVideo: %v", err)
+			}
+			if gone.ID != uuid.Nil {
+				t.Errorf("personal video wasn't deleted")
+			}
+		})
+	}
+}
+
+func TestUpdateUserPassword(t *testing.T) {
+	tests := []struct {
+		name       string
+		failRevoke bool
+	}{
+		{name: "changed"},
+		{name: "revoking sessions fails", failRevoke: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			user := newTestUser(t, c)
+			_, err := c.CreateRefreshToken(CreateRefreshTokenParams{Token: "refresh", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+			if err != nil {
+				t.Fatalf("CreateRefreshToken: %v", err)
+			}
+			if tt.failRevoke {
+				_, err := c.db.Exec(`
+				CREATE TRIGGER fail_revoke BEFORE UPDATE OF revoked_at ON refresh_tokens
+				BEGIN SELECT RAISE(ABORT, 'revoke failed'); END
+				`)
+				if err != nil {
+					t.Fatalf("creating trigger: %v", err)
+				}
+			}
+
+			err = c.UpdateUserPassword(user.ID, "new")
+			if (err != nil) != tt.failRevoke {
+				t.Fatalf("UpdateUserPassword error = %v, want failure %v", err, tt.failRevoke)
+			}
+			got, err := c.GetUser(user.ID)
+			if err != nil {
+				t.Fatalf("GetUser: %v", err)
+			}
+			refresh, err := c.GetRefreshToken("refresh")
+			if err != nil {
+				t.Fatalf("GetRefreshToken: %v", err)
+			}
+			if changed := got.Password == "new"; changed != !tt.failRevoke {
+				t.Errorf("password changed = %v, want %v", changed, !tt.failRevoke)
+			}
+			if revoked := refresh.RevokedAt != nil; revoked != !tt.failRevoke {
+				t.Errorf("session revoked = %v, want %v", revoked, !tt.failRevoke)
+			}
+		})
+	}
+}
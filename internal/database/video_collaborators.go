@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CollaboratorRole is what a collaborator may do with a single video shared
+// with it. Editors replace its thumbnail and video file, viewers only watch it.
+type CollaboratorRole string
+
+const (
+	CollaboratorRoleEditor CollaboratorRole = "editor"
+	CollaboratorRoleViewer CollaboratorRole = "viewer"
+)
+
+func (r CollaboratorRole) Valid() bool {
+	switch r {
+	case CollaboratorRoleEditor, CollaboratorRoleViewer:
+		return true
+	}
+	return false
+}
+
+// AtLeast reports whether r grants everything min grants.
+func (r CollaboratorRole) AtLeast(min CollaboratorRole) bool {
+	return r == min || r == CollaboratorRoleEditor
+}
+
+type VideoCollaborator struct {
+	VideoID   uuid.UUID        `json:"video_id"`
+	UserID    uuid.UUID        `json:"user_id"`
+	Email     string           `json:"email"`
+	Name      string           `json:"name"`
+	Role      CollaboratorRole `json:"role"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+const videoCollaboratorColumns = `vc.video_id, vc.user_id, u.email, u.name, vc.role, vc.created_at`
+
+func scanVideoCollaborator(row rowScanner) (VideoCollaborator, error) {
+	var vc VideoCollaborator
+	var videoID, userID string
+	err := row.Scan(&videoID, &userID, &vc.Email, &vc.Name, &vc.Role, &vc.CreatedAt)
+	if err != nil {
+		return VideoCollaborator{}, err
+	}
+	if vc.VideoID, err = uuid.Parse(videoID); err != nil {
+		return VideoCollaborator{}, err
+	}
+	if vc.UserID, err = uuid.Parse(userID); err != nil {
+		return VideoCollaborator{}, err
+	}
+	return vc, nil
+}
+
+func (c Client) GetVideoCollaborators(videoID uuid.UUID) ([]VideoCollaborator, error) {
+	query := `
+		SELECT ` + videoCollaboratorColumns + `
+		FROM video_collaborators vc
+		JOIN users u ON u.id = vc.user_id
+		WHERE vc.video_id = ?
+		ORDER BY vc.created_at
+	`
+	rows, err := c.db.Query(query, videoID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []VideoCollaborator{}
+	for rows.Next() {
+		vc, err := scanVideoCollaborator(rows)
+		if err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, vc)
+	}
+	return collaborators, rows.Err()
+}
+
+// GetVideoCollaborator returns the user's role on the video, or nil if the
+// video isn't shared with it.
+func (c Client) GetVideoCollaborator(videoID, userID uuid.UUID) (*VideoCollaborator, error) {
+	query := `
+		SELECT ` + videoCollaboratorColumns + `
+		FROM video_collaborators vc
+		JOIN users u ON u.id = vc.user_id
+		WHERE vc.video_id = ? AND vc.user_id = ?
+	`
+	vc, err := scanVideoCollaborator(c.db.QueryRow(query, videoID.String(), userID.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &vc, nil
+}
+
+// SetVideoCollaborator shares the video with the user, or changes the role of
+// an existing collaborator.
+func (c Client) SetVideoCollaborator(videoID, userID uuid.UUID, role CollaboratorRole) error {
+	query := `
+		INSERT INTO video_collaborators (video_id, user_id, role, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (video_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	_, err := c.db.Exec(query, videoID.String(), userID.String(), role)
+	return err
+}
+
+// RemoveVideoCollaborator stops sharing the video with the user. It reports
+// false if it wasn't shared with it.
+func (c Client) RemoveVideoCollaborator(videoID, userID uuid.UUID) (bool, error) {
+	query := `
+		DELETE FROM video_collaborators
+		WHERE video_id = ? AND user_id = ?
+	`
+	result, err := c.db.Exec(query, videoID.String(), userID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
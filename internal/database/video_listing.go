@@ -0,0 +1,234 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoSort is a field video listings can be sorted by.
+type VideoSort string
+
+const (
+	VideoSortCreatedAt VideoSort = "created_at"
+	VideoSortUpdatedAt VideoSort = "updated_at"
+	VideoSortTitle     VideoSort = "title"
+	VideoSortDuration  VideoSort = "duration"
+)
+
+// videoSortKeys are the expressions listings are ordered by. Timestamps are
+// compared as Julian days, since their text form depends on how they were
+// written, and videos without a file sort as if they were 0 seconds long.
+var videoSortKeys = map[VideoSort]string{
+	VideoSortCreatedAt: "julianday(created_at)",
+	VideoSortUpdatedAt: "julianday(updated_at)",
+	VideoSortTitle:     "lower(title)",
+	VideoSortDuration:  "COALESCE(duration_seconds, 0)",
+}
+
+func (s VideoSort) Valid() bool {
+	_, ok := videoSortKeys[s]
+	return ok
+}
+
+// ErrInvalidCursor is returned for cursors that weren't returned by a listing
+// with the same sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// VideoListParams selects a page of videos. Either UserID, for the user's
+// personal videos, or OrganizationID must be set. Nil filters don't filter.
+type VideoListParams struct {
+	UserID         *uuid.UUID
+	OrganizationID *uuid.UUID
+
+	HasVideo      *bool
+	HasThumbnail  *bool
+	Orientation   Orientation
+	CreatedSince  *time.Time
+	CreatedBefore *time.Time
+	// Tags selects videos with all of the named tags, or with any of them if
+	// AnyTag is set
+	Tags   []string
+	AnyTag bool
+
+	Sort       VideoSort
+	Descending bool
+	Limit      int
+	// Cursor is the NextCursor of the previous page, or empty for the first
+	Cursor string
+}
+
+type VideoPage struct {
+	Videos []Video
+	// Total is the number of videos matching the filters, on all pages
+	Total int
+	// NextCursor is empty on the last page
+	NextCursor string
+}
+
+// videoCursor marks the last video of a page by its sort key and ID, which
+// breaks ties. It is passed to clients as base64-encoded JSON.
+type videoCursor struct {
+	Sort       VideoSort `json:"s"`
+	Descending bool      `json:"d"`
+	Key        any       `json:"k"`
+	ID         uuid.UUID `json:"id"`
+}
+
+func (c videoCursor) encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeVideoCursor(s string) (videoCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return videoCursor{}, ErrInvalidCursor
+	}
+	var cursor videoCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return videoCursor{}, ErrInvalidCursor
+	}
+	switch cursor.Key.(type) {
+	case float64, string:
+	default:
+		return videoCursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// ListVideos returns a page of videos matching params, ordered by the sort
+// key and then by ID. Paging by cursor rather than offset keeps pages stable
+// while videos are added or removed.
+func (c Client) ListVideos(params VideoListParams) (VideoPage, error) {
+	sortKey, ok := videoSortKeys[params.Sort]
+	if !ok {
+		return VideoPage{}, errors.New("invalid sort")
+	}
+
+	var where []string
+	var args []any
+	switch {
+	case params.OrganizationID != nil:
+		where = append(where, "organization_id = ?")
+		args = append(args, *params.OrganizationID)
+	case params.UserID != nil:
+		where = append(where, "user_id = ? AND organization_id IS NULL")
+		args = append(args, *params.UserID)
+	default:
+		return VideoPage{}, errors.New("either a user or an organization is required")
+	}
+	if params.HasVideo != nil {
+		where = append(where, nullCondition("video_url", *params.HasVideo))
+	}
+	if params.HasThumbnail != nil {
+		where = append(where, nullCondition("thumbnail_url", *params.HasThumbnail))
+	}
+	if params.Orientation != "" {
+		where = append(where, "orientation = ?")
+		args = append(args, params.Orientation)
+	}
+	if params.CreatedSince != nil {
+		where = append(where, "julianday(created_at) >= julianday(?)")
+		args = append(args, params.CreatedSince.UTC().Format(time.DateTime))
+	}
+	if params.CreatedBefore != nil {
+		where = append(where, "julianday(created_at) < julianday(?)")
+		args = append(args, params.CreatedBefore.UTC().Format(time.DateTime))
+	}
+	if len(params.Tags) > 0 {
+		taggedWith := `id IN (
+			SELECT vt.video_id
+			FROM video_tags vt
+			JOIN tags t ON t.id = vt.tag_id
+			WHERE t.name %s
+		)`
+		if params.AnyTag {
+			where = append(where, fmt.Sprintf(taggedWith, "IN (?"+strings.Repeat(", ?", len(params.Tags)-1)+")"))
+			for _, tag := range params.Tags {
+				args = append(args, tag)
+			}
+		} else {
+			for _, tag := range params.Tags {
+				where = append(where, fmt.Sprintf(taggedWith, "= ?"))
+				args = append(args, tag)
+			}
+		}
+	}
+
+	var page VideoPage
+	countQuery := "SELECT COUNT(*) FROM videos WHERE " + strings.Join(where, " AND ")
+	if err := c.db.QueryRow(countQuery, args...).Scan(&page.Total); err != nil {
+		return VideoPage{}, err
+	}
+
+	direction, comparison := "ASC", ">"
+	if params.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if params.Cursor != "" {
+		cursor, err := decodeVideoCursor(params.Cursor)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		if cursor.Sort != params.Sort || cursor.Descending != params.Descending {
+			return VideoPage{}, ErrInvalidCursor
+		}
+		where = append(where, "("+sortKey+", id) "+comparison+" (?, ?)")
+		args = append(args, cursor.Key, cursor.ID)
+	}
+
+	query := `
+	SELECT ` + videoColumns + `, ` + sortKey + `
+	FROM videos
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + sortKey + ` ` + direction + `, id ` + direction + `
+	LIMIT ?
+	`
+	// one more than asked for tells whether there is a next page
+	rows, err := c.db.Query(query, append(args, params.Limit+1)...)
+	if err != nil {
+		return VideoPage{}, err
+	}
+	defer rows.Close()
+
+	page.Videos = []Video{}
+	var lastKey any
+	for rows.Next() {
+		var key any
+		video, err := scanVideo(rows, &key)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		if len(page.Videos) == params.Limit {
+			cursor := videoCursor{
+				Sort:       params.Sort,
+				Descending: params.Descending,
+				Key:        lastKey,
+				ID:         page.Videos[len(page.Videos)-1].ID,
+			}
+			if page.NextCursor, err = cursor.encode(); err != nil {
+				return VideoPage{}, err
+			}
+			break
+		}
+		page.Videos = append(page.Videos, video)
+		lastKey = key
+	}
+	return page, rows.Err()
+}
+
+func nullCondition(column string, notNull bool) string {
+	if notNull {
+		return column + " IS NOT NULL"
+	}
+	return column + " IS NULL"
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestListVideosPages(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+
+	// repeated titles and durations, and videos without a duration, make
+	// for ties that only the ID breaks
+	for _, v := range []struct {
+		title    string
+		duration *float64
+	}{
+		{"b", ptr(30.0)},
+		{"a", nil},
+		{"B", ptr(10.0)},
+		{"c", ptr(30.0)},
+		{"a", ptr(10.0)},
+		{"d", nil},
+		{"b", ptr(20.5)},
+	} {
+		video := newTestVideo(t, c, user.ID)
+		video.Title = v.title
+		if err := c.UpdateVideo(video); err != nil {
+			t.Fatalf("UpdateVideo: %v", err)
+		}
+		if v.duration != nil {
+			_, err := c.UpdateVideoFile(video.ID, UpdateVideoFileParams{VideoURL: "url", DurationSeconds: v.duration, Orientation: OrientationLandscape})
+			if err != nil {
+				t.Fatalf("UpdateVideoFile: %v", err)
+			}
+		}
+	}
+
+	for _, sort := range []VideoSort{VideoSortCreatedAt, VideoSortUpdatedAt, VideoSortTitle, VideoSortDuration} {
+		for _, descending := range []bool{false, true} {
+			name := string(sort)
+			if descending {
+				name += " descending"
+			}
+			t.Run(name, func(t *testing.T) {
+				params := VideoListParams{UserID: &user.ID, Sort: sort, Descending: descending, Limit: 100}
+				all, err := c.ListVideos(params)
+				if err != nil {
+					t.Fatalf("ListVideos: %v", err)
+				}
+				if len(all.Videos) != 7 || all.Total != 7 || all.NextCursor != "" {
+					t.Fatalf("single page: %d videos, total %d, cursor %q", len(all.Videos), all.Total, all.NextCursor)
+				}
+
+				for _, limit := range []int{1, 2, 3, 7} {
+					params.Limit = limit
+					params.Cursor = ""
+					var paged []uuid.UUID
+					for {
+						page, err := c.ListVideos(params)
+						if err != nil {
+							t.Fatalf("limit %d: ListVideos: %v", limit, err)
+						}
+						if page.Total != 7 {
+							t.Errorf("limit %d: total = %d, want 7", limit, page.Total)
+						}
+						for _, video := range page.Videos {
+							paged = append(paged, video.ID)
+						}
+						if page.NextCursor == "" {
+							break
+						}
+						if len(paged) > 7 {
+							t.Fatalf("limit %d: paging doesn't end", limit)
+						}
+						params.Cursor = page.NextCursor
+					}
+					var want []uuid.UUID
+					for _, video := range all.Videos {
+						want = append(want, video.ID)
+					}
+					if !slices.Equal(paged, want) {
+						t.Errorf("limit %d: paged order differs from the single page", limit)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestListVideosInvalidCursor(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	for range 3 {
+		newTestVideo(t, c, user.ID)
+	}
+	first, err := c.ListVideos(VideoListParams{UserID: &user.ID, Sort: VideoSortTitle, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListVideos: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		cursor     string
+		sort       VideoSort
+		descending bool
+	}{
+		{name: "not base64", cursor: "%%%", sort: VideoSortTitle},
+		{name: "not JSON", cursor: base64.RawURLEncoding.EncodeToString([]byte("{")), sort: VideoSortTitle},
+		{name: "key of the wrong type", cursor: base64.RawURLEncoding.EncodeToString([]byte(`{"s":"title","k":[1]}`)), sort: VideoSortTitle},
+		{name: "other sort", cursor: first.NextCursor, sort: VideoSortCreatedAt},
+		{name: "other direction", cursor: first.NextCursor, sort: VideoSortTitle, descending: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.ListVideos(VideoListParams{UserID: &user.ID, Sort: tt.sort, Descending: tt.descending, Limit: 1, Cursor: tt.cursor})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
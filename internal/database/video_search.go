@@ -0,0 +1,231 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// The search index is the videos_fts table, an FTS5 table kept in sync with
+// videos by triggers that find rows by video_id: rowids of videos aren't
+// stable, as VACUUM may renumber them. go-sqlite3 only has FTS5 when built
+// with -tags sqlite_fts5. Without it there is no search index, and the server
+// refuses to start.
+
+// HighlightStart and HighlightEnd surround the matched terms in search
+// highlights and snippets. They are private use characters, so they can't
+// clash with text users entered.
+const (
+	HighlightStart = "\ue000"
+	HighlightEnd   = "\ue001"
+)
+
+// ErrEmptySearch is returned for search queries without any term.
+var ErrEmptySearch = errors.New("search query has no terms")
+
+// ErrSearchUnavailable is returned when searching without FTS5.
+var ErrSearchUnavailable = errors.New("video search needs SQLite built with FTS5")
+
+var videoSearchTriggers = `
+	CREATE TRIGGER IF NOT EXISTS videos_fts_insert AFTER INSERT ON videos
+	BEGIN
+		INSERT INTO videos_fts (video_id, title, description, tags)
+		VALUES (new.id, new.title, COALESCE(new.description, ''), ` + videoTagNames("new.id") + `);
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_update AFTER UPDATE OF title, description ON videos
+	BEGIN
+		UPDATE videos_fts
+		SET title = new.title, description = COALESCE(new.description, '')
+		WHERE video_id = old.id;
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_delete AFTER DELETE ON videos
+	BEGIN
+		DELETE FROM videos_fts WHERE video_id = old.id;
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_tag_insert AFTER INSERT ON video_tags
+	BEGIN
+		UPDATE videos_fts SET tags = ` + videoTagNames("new.video_id") + `
+		WHERE video_id = new.video_id;
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_fts_tag_delete AFTER DELETE ON video_tags
+	BEGIN
+		UPDATE videos_fts SET tags = ` + videoTagNames("old.video_id") + `
+		WHERE video_id = old.video_id;
+	END;
+`
+
+// HasFTS5 reports whether SQLite was built with FTS5, which video search
+// needs.
+func (c Client) HasFTS5() bool {
+	return c.fts5
+}
+
+// migrateVideoSearch creates the search index, or rebuilds it when it
+// predates FTS5 or its triggers still match rows by rowid, as they used to.
+// Without FTS5 it leaves the database alone.
+func (c *Client) migrateVideoSearch() error {
+	_, err := c.db.Exec("CREATE VIRTUAL TABLE temp.fts5_probe USING fts5(x)")
+	c.fts5 = err == nil
+	if !c.fts5 {
+		return nil
+	}
+	if _, err := c.db.Exec("DROP TABLE temp.fts5_probe"); err != nil {
+		return err
+	}
+
+	var existing string
+	err = c.db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'videos_fts'").Scan(&existing)
+	var rowidTriggers int
+	if err := c.db.QueryRow(`
+	SELECT COUNT(*) FROM sqlite_master
+	WHERE type = 'trigger' AND name LIKE 'videos_fts_%' AND sql LIKE '%rowid%'
+	`).Scan(&rowidTriggers); err != nil {
+		return err
+	}
+	if err == nil && rowidTriggers == 0 && strings.Contains(strings.ToLower(existing), "using fts5") {
+		_, err = c.db.Exec(videoSearchTriggers)
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DROP TRIGGER IF EXISTS videos_fts_insert",
+		"DROP TRIGGER IF EXISTS videos_fts_update",
+		"DROP TRIGGER IF EXISTS videos_fts_delete",
+		"DROP TRIGGER IF EXISTS videos_fts_tag_insert",
+		"DROP TRIGGER IF EXISTS videos_fts_tag_delete",
+		"DROP TABLE IF EXISTS videos_fts",
+		`CREATE VIRTUAL TABLE videos_fts USING fts5(
+			video_id UNINDEXED, title, description, tags,
+			tokenize = 'unicode61 remove_diacritics 2'
+		)`,
+		videoSearchTriggers,
+		`INSERT INTO videos_fts (video_id, title, description, tags)
+		SELECT id, title, COALESCE(description, ''), ` + videoTagNames("videos.id") + ` FROM videos`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create search index: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// searchTerms splits a query into terms, dropping the characters FTS query
+// syntax gives a meaning to. Every term is matched as a prefix.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		word = strings.Map(func(r rune) rune {
+			switch r {
+			case '"', '*', '^', ':', '(', ')', '{', '}', '+', '-':
+				return ' '
+			}
+			return r
+		}, word)
+		terms = append(terms, strings.Fields(word)...)
+	}
+	return terms
+}
+
+// matchExpression turns terms into an FTS query matching rows that contain
+// every term as a prefix.
+func matchExpression(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = `"` + term + `"*`
+	}
+	return strings.Join(parts, " ")
+}
+
+// VideoSearchParams selects the videos to search: those UserID can see, or
+// only those of OrganizationID if it is set.
+type VideoSearchParams struct {
+	UserID         uuid.UUID
+	OrganizationID *uuid.UUID
+	Query          string
+	Limit          int
+	Offset         int
+}
+
+// VideoSearchResult is a video matching a search. TitleHighlight is the title
+// and Snippet the best matching excerpt, with matched terms between
+// HighlightStart and HighlightEnd.
+type VideoSearchResult struct {
+	Video
+	TitleHighlight string `json:"title_highlight"`
+	Snippet        string `json:"snippet"`
+}
+
+// SearchVideos returns the videos matching every term of the query, best
+// matches first, and how many match in total. Matches in titles rank above
+// matches in tags, which rank above matches in descriptions.
+func (c Client) SearchVideos(params VideoSearchParams) ([]VideoSearchResult, int, error) {
+	if !c.fts5 {
+		return nil, 0, ErrSearchUnavailable
+	}
+	terms := searchTerms(params.Query)
+	if len(terms) == 0 {
+		return nil, 0, ErrEmptySearch
+	}
+	match := matchExpression(terms)
+
+	scope := `
+		((v.organization_id IS NULL AND v.user_id = ?)
+		OR v.organization_id IN (SELECT organization_id FROM organization_members WHERE user_id = ?)
+		OR v.id IN (SELECT video_id FROM video_collaborators WHERE user_id = ?))
+	`
+	scopeArgs := []any{params.UserID, params.UserID.String(), params.UserID.String()}
+	if params.OrganizationID != nil {
+		scope += " AND v.organization_id = ?"
+		scopeArgs = append(scopeArgs, *params.OrganizationID)
+	}
+
+	var total int
+	countQuery := `
+	SELECT COUNT(*)
+	FROM videos_fts
+	JOIN videos v ON v.id = videos_fts.video_id
+	WHERE videos_fts MATCH ? AND ` + scope
+	if err := c.db.QueryRow(countQuery, append([]any{match}, scopeArgs...)...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+	SELECT ` + prefixColumns(videoTableColumns, "v") + ", " + videoComputedColumns("v") + `,
+		highlight(videos_fts, 1, ?, ?),
+		snippet(videos_fts, -1, ?, ?, '…', 16)
+	FROM videos_fts
+	JOIN videos v ON v.id = videos_fts.video_id
+	WHERE videos_fts MATCH ? AND ` + scope + `
+	ORDER BY bm25(videos_fts, 0.0, 10.0, 1.0, 5.0), v.id
+	LIMIT ? OFFSET ?
+	`
+	args := []any{HighlightStart, HighlightEnd, HighlightStart, HighlightEnd, match}
+	args = append(args, scopeArgs...)
+	args = append(args, params.Limit, params.Offset)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		result.Video, err = scanVideo(rows, &result.TitleHighlight, &result.Snippet)
+		if err != nil {
+			return nil, 0, err
+		}
+		results = append(results, result)
+	}
+	return results, total, rows.Err()
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// newSearchTestClient is newTestClient for tests of video search, which only
+// run when built with FTS5 (make test).
+func newSearchTestClient(t *testing.T) Client {
+	t.Helper()
+	c := newTestClient(t)
+	if !c.HasFTS5() {
+		t.Skip("video search needs FTS5: run with -tags sqlite_fts5")
+	}
+	return c
+}
+
+func searchTitles(t *testing.T, c Client, userID uuid.UUID, query string) []string {
+	t.Helper()
+	results, total, err := c.SearchVideos(VideoSearchParams{UserID: userID, Query: query, Limit: 10})
+	if err != nil {
+		t.Fatalf("SearchVideos(%q): %v", query, err)
+	}
+	if total != len(results) {
+		t.Errorf("SearchVideos(%q) total = %d, want %d", query, total, len(results))
+	}
+	titles := []string{}
+	for _, r := range results {
+		titles = append(titles, r.Title)
+	}
+	return titles
+}
+
+func TestSearchVideosAfterRowidsChanged(t *testing.T) {
+	c := newSearchTestClient(t)
+	user := newTestUser(t, c)
+
+	var videos []Video
+	for _, title := range []string{"alpha", "bravo", "charlie", "delta"} {
+		video := newTestVideo(t, c, user.ID)
+		video.Title = title
+		if err := c.UpdateVideo(video); err != nil {
+			t.Fatalf("UpdateVideo: %v", err)
+		}
+		videos = append(videos, video)
+	}
+
+	if err := c.DeleteVideo(videos[1].ID); err != nil {
+		t.Fatalf("DeleteVideo: %v", err)
+	}
+	// renumber the rowids, as VACUUM is allowed to for tables without an
+	// INTEGER PRIMARY KEY
+	if _, err := c.db.Exec("UPDATE videos SET rowid = rowid - 1 WHERE rowid > 2"); err != nil {
+		t.Fatalf("renumbering rowids: %v", err)
+	}
+
+	videos[3].Title = "echo"
+	if err := c.UpdateVideo(videos[3]); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "alpha", want: []string{"alpha"}},
+		{query: "bravo", want: []string{}},
+		{query: "charlie", want: []string{"charlie"}},
+		{query: "delta", want: []string{}},
+		{query: "echo", want: []string{"echo"}},
+	}
+	for _, tt := range tests {
+		got := searchTitles(t, c, user.ID, tt.query)
+		if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+			t.Errorf("search %q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateVideoSearchReplacesRowidTriggers(t *testing.T) {
+	c := newSearchTestClient(t)
+	user := newTestUser(t, c)
+	video := newTestVideo(t, c, user.ID)
+
+	// put back a trigger in the old style, which looked rows up by rowid
+	_, err := c.db.Exec(`
+	DROP TRIGGER videos_fts_update;
+	CREATE TRIGGER videos_fts_update AFTER UPDATE OF title, description ON videos
+	BEGIN
+		UPDATE videos_fts SET title = new.title WHERE rowid = old.rowid;
+	END;
+	`)
+	if err != nil {
+		t.Fatalf("creating old trigger: %v", err)
+	}
+
+	if err := c.migrateVideoSearch(); err != nil {
+		t.Fatalf("migrateVideoSearch: %v", err)
+	}
+	var oldTriggers int
+	err = c.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND sql LIKE '%rowid%'").Scan(&oldTriggers)
+	if err != nil {
+		t.Fatalf("counting triggers: %v", err)
+	}
+	if oldTriggers != 0 {
+		t.Errorf("%d triggers still use rowids", oldTriggers)
+	}
+
+	video.Title = "renamed"
+	if err := c.UpdateVideo(video); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	if got := searchTitles(t, c, user.ID, "renamed"); len(got) != 1 {
+		t.Errorf("search after migration = %v, want the renamed video", got)
+	}
+}
+
+func TestSearchVideosWithoutFTS5(t *testing.T) {
+	c := newTestClient(t)
+	if c.HasFTS5() {
+		t.Skip("built with FTS5")
+	}
+	var tables int
+	if err := c.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'videos_fts%'").Scan(&tables); err != nil {
+		t.Fatalf("counting search tables: %v", err)
+	}
+	if tables != 0 {
+		t.Errorf("%d search tables and triggers created without FTS5", tables)
+	}
+	_, _, err := c.SearchVideos(VideoSearchParams{UserID: uuid.New(), Query: "video", Limit: 10})
+	if !errors.Is(err, ErrSearchUnavailable) {
+		t.Errorf("SearchVideos error = %v, want %v", err, ErrSearchUnavailable)
+	}
+}
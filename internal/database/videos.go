@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -14,32 +15,113 @@ type Video struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
 	VideoURL     *string   `json:"video_url"`
+	// VideoSizeBytes and ThumbnailSizeBytes are the sizes of the stored files,
+	// counted against the storage quota of the video's creator.
+	VideoSizeBytes     int64 `json:"video_size_bytes"`
+	ThumbnailSizeBytes int64 `json:"thumbnail_size_bytes"`
+	// DurationSeconds and Orientation describe the video file. They are nil
+	// until one is uploaded.
+	DurationSeconds *float64     `json:"duration_seconds"`
+	Orientation     *Orientation `json:"orientation"`
+	// Version is bumped on every change, so clients can tell whether the
+	// video changed since they read it.
+	Version int64 `json:"version"`
+	// ReviewStatus is how far the video got in being signed off
+	ReviewStatus ReviewStatus `json:"review_status"`
+	// CommentCount doesn't count deleted comments kept for their replies
+	CommentCount int `json:"comment_count"`
+	ViewCount    int `json:"view_count"`
+	// ResumeAt is where the user the video is returned to left off watching
+	// it, or null if there is nothing to resume
+	ResumeAt *float64 `json:"resume_at"`
 	CreateVideoParams
 }
 
+// Orientation is the shape of a video: 16:9, 9:16 or anything else.
+type Orientation string
+
+const (
+	OrientationLandscape Orientation = "landscape"
+	OrientationPortrait  Orientation = "portrait"
+	OrientationOther     Orientation = "other"
+)
+
+func (o Orientation) Valid() bool {
+	switch o {
+	case OrientationLandscape, OrientationPortrait, OrientationOther:
+		return true
+	}
+	return false
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// OrganizationID is set for videos owned by an organization rather than
+	// by the user who created them.
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	// Tags are the names of the video's tags, in alphabetical order
+	Tags []string `json:"tags"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+const videoTableColumns = `id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id, organization_id, video_size_bytes, thumbnail_size_bytes, duration_seconds, orientation, version, review_status`
+
+// videoColumns are the columns scanVideo expects: those of the videos table
+// and the ones computed from related tables.
+var videoColumns = videoTableColumns + ", " + videoComputedColumns("videos")
+
+// videoComputedColumns selects, for the video in table, the video's tags as a
+// JSON array and how many comments it has.
+func videoComputedColumns(table string) string {
+	return `(
+		SELECT json_group_array(t.name ORDER BY t.name)
+		FROM video_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		WHERE vt.video_id = ` + table + `.id
+	), (
+		SELECT COUNT(*)
+		FROM comments cm
+		WHERE cm.video_id = ` + table + `.id AND cm.deleted_at IS NULL
+	), (
+		SELECT COALESCE(SUM(ds.views), 0)
+		FROM video_daily_stats ds
+		WHERE ds.video_id = ` + table + `.id
+	)`
+}
 
-	rows, err := c.db.Query(query, userID)
+func scanVideo(row rowScanner, extra ...any) (Video, error) {
+	var video Video
+	var tags string
+	err := row.Scan(append([]any{
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.OrganizationID,
+		&video.VideoSizeBytes,
+		&video.ThumbnailSizeBytes,
+		&video.DurationSeconds,
+		&video.Orientation,
+		&video.Version,
+		&video.ReviewStatus,
+		&tags,
+		&video.CommentCount,
+		&video.ViewCount,
+	}, extra...)...)
+	if err != nil {
+		return Video{}, err
+	}
+	err = json.Unmarshal([]byte(tags), &video.Tags)
+	return video, err
+}
+
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,23 +129,48 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
+}
+
+// GetVideos returns every video created by the user, including those owned
+// by its organizations.
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+// GetPersonalVideos returns the videos the user owns itself, leaving out
+// those owned by organizations.
+func (c Client) GetPersonalVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND organization_id IS NULL
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+func (c Client) GetOrganizationVideos(organizationID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	WHERE organization_id = ?
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, organizationID)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,42 +182,38 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		organization_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Video{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(query, id, params.Title, params.Description, params.UserID, params.OrganizationID)
 	if err != nil {
 		return Video{}, err
 	}
+	if err := setVideoTags(tx, id, params.UserID, params.Tags); err != nil {
+		return Video{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Video{}, err
+	}
 
 	return c.GetVideo(id)
 }
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT ` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,6 +224,7 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// UpdateVideo saves every field of the video and bumps its version.
 func (c Client) UpdateVideo(video Video) error {
 	query := `
 	UPDATE videos
@@ -129,7 +233,14 @@ func (c Client) UpdateVideo(video Video) error {
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
+		user_id = ?,
+		organization_id = ?,
+		video_size_bytes = ?,
+		thumbnail_size_bytes = ?,
+		duration_seconds = ?,
+		orientation = ?,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
 	WHERE id = ?
 	`
 
@@ -140,16 +251,253 @@ func (c Client) UpdateVideo(video Video) error {
 		&video.ThumbnailURL,
 		&video.VideoURL,
 		video.UserID,
+		video.OrganizationID,
+		video.VideoSizeBytes,
+		video.ThumbnailSizeBytes,
+		video.DurationSeconds,
+		video.Orientation,
 		video.ID,
 	)
 	return err
 }
 
+// UpdateVideoMetadataParams holds the fields users edit directly. Nil fields
+// are left as they are.
+type UpdateVideoMetadataParams struct {
+	Title       *string
+	Description *string
+	// Tags replace all of the video's tags
+	Tags *[]string
+}
+
+// UpdateVideoMetadata saves the fields users edit directly and returns the
+// updated video. If version isn't 0, the video is only updated while it is
+// still at that version; otherwise, or if there is no such video, it returns
+// nil.
+func (c Client) UpdateVideoMetadata(id uuid.UUID, params UpdateVideoMetadataParams, version int64) (*Video, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET
+		title = COALESCE(?, title),
+		description = COALESCE(?, description),
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
+	WHERE id = ? AND (? = 0 OR version = ?)
+	RETURNING user_id
+	`
+	var userID uuid.UUID
+	err = tx.QueryRow(query, params.Title, params.Description, id, version, version).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if params.Tags != nil {
+		if err := setVideoTags(tx, id, userID, *params.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	video, err := scanVideo(tx.QueryRow("SELECT "+videoColumns+" FROM videos WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &video, nil
+}
+
+type UpdateVideoFileParams struct {
+	VideoURL        string
+	SizeBytes       int64
+	DurationSeconds *float64
+	Orientation     Orientation
+	// QuotaBytes is the storage quota of the video's creator, or 0 if it is
+	// unlimited
+	QuotaBytes int64
+}
+
+// ErrStorageQuotaExceeded is returned when recording the size of a newly
+// stored file would take the creator of its video past their quota.
+var ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+
+// withinQuota is a condition on the video being updated that holds if its
+// creator's media stays within a quota once sizeColumn is set to a new size.
+// It takes the quota, the new size and the quota again as arguments.
+func withinQuota(sizeColumn string) string {
+	return `(? = 0 OR (
+		SELECT SUM(o.video_size_bytes + o.thumbnail_size_bytes)
+		FROM videos o
+		WHERE o.user_id = videos.user_id
+	) - videos.` + sizeColumn + ` + ? <= ?)`
+}
+
+// UpdateVideoFile points the video at a newly stored video file and returns
+// the updated video. Unlike UpdateVideo it leaves the other fields alone, so
+// it doesn't undo edits made while the file was being processed. The quota is
+// checked in the same statement that records the size, so concurrent uploads
+// can't together exceed it; if it would be, ErrStorageQuotaExceeded is
+// returned and nothing changes.
+func (c Client) UpdateVideoFile(id uuid.UUID, params UpdateVideoFileParams) (Video, error) {
+	query := `
+	UPDATE videos
+	SET
+		video_url = ?,
+		video_size_bytes = ?,
+		duration_seconds = ?,
+		orientation = ?,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
+	WHERE id = ? AND ` + withinQuota("video_size_bytes") + `
+	RETURNING ` + videoColumns
+	video, err := scanVideo(c.db.QueryRow(query,
+		params.VideoURL, params.SizeBytes, params.DurationSeconds, params.Orientation, id,
+		params.QuotaBytes, params.SizeBytes, params.QuotaBytes,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Video{}, c.quotaUpdateError(id)
+	}
+	return video, err
+}
+
+// UpdateVideoThumbnail is UpdateVideoFile for the thumbnail.
+func (c Client) UpdateVideoThumbnail(id uuid.UUID, thumbnailURL string, sizeBytes, quotaBytes int64) (Video, error) {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		thumbnail_size_bytes = ?,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
+	WHERE id = ? AND ` + withinQuota("thumbnail_size_bytes") + `
+	RETURNING ` + videoColumns
+	video, err := scanVideo(c.db.QueryRow(query, thumbnailURL, sizeBytes, id, quotaBytes, sizeBytes, quotaBytes))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Video{}, c.quotaUpdateError(id)
+	}
+	return video, err
+}
+
+// quotaUpdateError tells why an update conditioned on withinQuota didn't
+// change the video: ErrStorageQuotaExceeded, or sql.ErrNoRows if there is no
+// such video.
+func (c Client) quotaUpdateError(id uuid.UUID) error {
+	var exists bool
+	if err := c.db.QueryRow("SELECT EXISTS (SELECT 1 FROM videos WHERE id = ?)", id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return ErrStorageQuotaExceeded
+}
+
+// GetVideosWithoutMediaSizes returns the videos with a stored video or
+// thumbnail of unknown size, such as those uploaded before sizes were
+// tracked.
+func (c Client) GetVideosWithoutMediaSizes() ([]Video, error) {
+	return c.queryVideos(`
+	SELECT ` + videoColumns + `
+	FROM videos
+	WHERE (video_url IS NOT NULL AND video_size_bytes = 0)
+	OR (thumbnail_url IS NOT NULL AND thumbnail_size_bytes = 0)
+	`)
+}
+
+// SetVideoMediaSizes records the sizes of the stored media of the video. It
+// leaves its version alone, since the video itself didn't change.
+func (c Client) SetVideoMediaSizes(id uuid.UUID, videoBytes, thumbnailBytes int64) error {
+	_, err := c.db.Exec(`
+	UPDATE videos
+	SET video_size_bytes = ?, thumbnail_size_bytes = ?
+	WHERE id = ?
+	`, videoBytes, thumbnailBytes, id)
+	return err
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM video_collaborators WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM video_tags WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if err := deletePlaylistVideos(tx, "video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM comments WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM annotations WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM video_review_transitions WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM watch_progress WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM playback_sessions WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM video_daily_stats WHERE video_id = ?", id.String()); err != nil {
+		return err
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
+	RETURNING user_id
 	`
-	_, err := c.db.Exec(query, id)
-	return err
+	var userID uuid.UUID
+	if err := tx.QueryRow(query, id).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if err := deleteUnusedTags(tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StorageUsage is how many bytes of media a user stores.
+type StorageUsage struct {
+	VideoBytes     int64 `json:"video_bytes"`
+	ThumbnailBytes int64 `json:"thumbnail_bytes"`
+	VideoCount     int64 `json:"video_count"`
+}
+
+func (u StorageUsage) TotalBytes() int64 {
+	return u.VideoBytes + u.ThumbnailBytes
+}
+
+// GetUserStorageUsage sums the sizes of the media of every video created by
+// the user, including those owned by its organizations.
+func (c Client) GetUserStorageUsage(userID uuid.UUID) (StorageUsage, error) {
+	query := `
+	SELECT
+		COALESCE(SUM(video_size_bytes), 0),
+		COALESCE(SUM(thumbnail_size_bytes), 0),
+		COUNT(*)
+	FROM videos
+	WHERE user_id = ?
+	`
+	var usage StorageUsage
+	err := c.db.QueryRow(query, userID).Scan(&usage.VideoBytes, &usage.ThumbnailBytes, &usage.VideoCount)
+	return usage, err
 }
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestUpdateVideoFileQuota(t *testing.T) {
+	tests := []struct {
+		name string
+		// stored is the size of the video file the other video already has,
+		// replaced the size of the one being replaced
+		stored, replaced, size, quota int64
+		wantErr                       error
+	}{
+		{name: "unlimited", stored: 900, size: 900, quota: 0},
+		{name: "within quota", stored: 400, size: 500, quota: 1000},
+		{name: "exactly at quota", stored: 400, size: 600, quota: 1000},
+		{name: "over quota", stored: 400, size: 601, quota: 1000, wantErr: ErrStorageQuotaExceeded},
+		{name: "replaced file frees its room", stored: 400, replaced: 500, size: 600, quota: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			user := newTestUser(t, c)
+			other := newTestVideo(t, c, user.ID)
+			if _, err := c.UpdateVideoFile(other.ID, UpdateVideoFileParams{VideoURL: "other", SizeBytes: tt.stored}); err != nil {
+				t.Fatalf("storing other video: %v", err)
+			}
+			video := newTestVideo(t, c, user.ID)
+			if _, err := c.UpdateVideoFile(video.ID, UpdateVideoFileParams{VideoURL: "old", SizeBytes: tt.replaced}); err != nil {
+				t.Fatalf("storing replaced video: %v", err)
+			}
+
+			updated, err := c.UpdateVideoFile(video.ID, UpdateVideoFileParams{VideoURL: "new", SizeBytes: tt.size, QuotaBytes: tt.quota})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateVideoFile error = %v, want %v", err, tt.wantErr)
+			}
+			got, err := c.GetVideo(video.ID)
+			if err != nil {
+				t.Fatalf("GetVideo: %v", err)
+			}
+			if tt.wantErr != nil {
+				if *got.VideoURL != "old" || got.VideoSizeBytes != tt.replaced || got.Version != video.Version+1 {
+					t.Errorf("rejected update changed the video: %+v", got)
+				}
+				return
+			}
+			if *updated.VideoURL != "new" || updated.VideoSizeBytes != tt.size {
+				t.Errorf("updated video = %+v", updated)
+			}
+		})
+	}
+}
+
+func TestUpdateVideoThumbnailQuota(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	first := newTestVideo(t, c, user.ID)
+	second := newTestVideo(t, c, user.ID)
+
+	// both uploads passed the check made before their bodies were read, but
+	// only one of them fits
+	if _, err := c.UpdateVideoThumbnail(first.ID, "first", 600, 1000); err != nil {
+		t.Fatalf("first UpdateVideoThumbnail: %v", err)
+	}
+	if _, err := c.UpdateVideoThumbnail(second.ID, "second", 600, 1000); !errors.Is(err, ErrStorageQuotaExceeded) {
+		t.Fatalf("second UpdateVideoThumbnail error = %v, want %v", err, ErrStorageQuotaExceeded)
+	}
+	usage, err := c.GetUserStorageUsage(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserStorageUsage: %v", err)
+	}
+	if usage.TotalBytes() != 600 {
+		t.Errorf("usage = %d bytes, want 600", usage.TotalBytes())
+	}
+
+	if _, err := c.UpdateVideoThumbnail(uuid.New(), "missing", 1, 1000); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("updating a missing video: error = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestGetVideosWithoutMediaSizes(t *testing.T) {
+	c := newTestClient(t)
+	user := newTestUser(t, c)
+	// a video without media has no sizes to look up
+	newTestVideo(t, c, user.ID)
+	sized := newTestVideo(t, c, user.ID)
+	if _, err := c.UpdateVideoFile(sized.ID, UpdateVideoFileParams{VideoURL: "sized", SizeBytes: 10}); err != nil {
+		t.Fatalf("UpdateVideoFile: %v", err)
+	}
+	// uploaded before sizes were tracked
+	unsized := newTestVideo(t, c, user.ID)
+	if _, err := c.db.Exec("UPDATE videos SET video_url = 'video', thumbnail_url = 'thumbnail' WHERE id = ?", unsized.ID); err != nil {
+		t.Fatalf("storing unsized media: %v", err)
+	}
+
+	videos, err := c.GetVideosWithoutMediaSizes()
+	if err != nil {
+		t.Fatalf("GetVideosWithoutMediaSizes: %v", err)
+	}
+	if len(videos) != 1 || videos[0].ID != unsized.ID {
+		t.Fatalf("got %d videos, want only the unsized one", len(videos))
+	}
+
+	if err := c.SetVideoMediaSizes(unsized.ID, 100, 20); err != nil {
+		t.Fatalf("SetVideoMediaSizes: %v", err)
+	}
+	got, err := c.GetVideo(unsized.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if got.VideoSizeBytes != 100 || got.ThumbnailSizeBytes != 20 || got.Version != videos[0].Version {
+		t.Errorf("video after backfill = %+v", got)
+	}
+	if videos, err := c.GetVideosWithoutMediaSizes(); err != nil || len(videos) != 0 {
+		t.Errorf("after backfill got %d videos, %v", len(videos), err)
+	}
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WatchProgress is how far a user got in watching a video. A video is
+// finished once the user watched almost all of it, and then has nothing to
+// resume.
+type WatchProgress struct {
+	VideoID         uuid.UUID `json:"video_id"`
+	UserID          uuid.UUID `json:"user_id"`
+	PositionSeconds float64   `json:"position_seconds"`
+	Finished        bool      `json:"finished"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// ResumeAt is where playback should resume, or nil if it should start from
+// the beginning.
+func (p WatchProgress) ResumeAt() *float64 {
+	if p.Finished || p.PositionSeconds < minResumeSeconds {
+		return nil
+	}
+	position := p.PositionSeconds
+	return &position
+}
+
+const watchProgressColumns = `video_id, user_id, position_seconds, finished, updated_at`
+
+func scanWatchProgress(row rowScanner) (WatchProgress, error) {
+	var p WatchProgress
+	err := row.Scan(&p.VideoID, &p.UserID, &p.PositionSeconds, &p.Finished, &p.UpdatedAt)
+	return p, err
+}
+
+const (
+	// minResumeSeconds is how far into a video a user must get for it to be
+	// resumed rather than started over
+	minResumeSeconds = 5
+	// finishedFraction is how much of a video a user must watch for it to
+	// count as finished
+	finishedFraction = 0.95
+)
+
+// SaveWatchProgress records the position the user got to in the video, whose
+// duration is used to tell whether they finished it, if it's known.
+func (c Client) SaveWatchProgress(userID, videoID uuid.UUID, positionSeconds float64, durationSeconds *float64) (WatchProgress, error) {
+	progress := WatchProgress{
+		VideoID:         videoID,
+		UserID:          userID,
+		PositionSeconds: positionSeconds,
+		Finished:        durationSeconds != nil && positionSeconds >= *durationSeconds*finishedFraction,
+		UpdatedAt:       time.Now().UTC(),
+	}
+	_, err := c.db.Exec(`
+	INSERT INTO watch_progress (user_id, video_id, position_seconds, finished, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (user_id, video_id) DO UPDATE SET
+		position_seconds = excluded.position_seconds,
+		finished = excluded.finished,
+		updated_at = excluded.updated_at
+	`, userID.String(), videoID.String(), progress.PositionSeconds, progress.Finished, progress.UpdatedAt)
+	if err != nil {
+		return WatchProgress{}, err
+	}
+	return progress, nil
+}
+
+// GetWatchProgress returns the user's progress in each of the videos they
+// started watching, by video ID.
+func (c Client) GetWatchProgress(userID uuid.UUID, videoIDs []uuid.UUID) (map[uuid.UUID]WatchProgress, error) {
+	progress := make(map[uuid.UUID]WatchProgress, len(videoIDs))
+	if len(videoIDs) == 0 {
+		return progress, nil
+	}
+
+	args := []any{userID.String()}
+	for _, id := range videoIDs {
+		args = append(args, id.String())
+	}
+	rows, err := c.db.Query(`
+	SELECT `+watchProgressColumns+`
+	FROM watch_progress
+	WHERE user_id = ? AND video_id IN (?`+strings.Repeat(", ?", len(videoIDs)-1)+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanWatchProgress(rows)
+		if err != nil {
+			return nil, err
+		}
+		progress[p.VideoID] = p
+	}
+	return progress, rows.Err()
+}
+
+// GetUserWatchProgress returns the user's progress in every video they
+// started watching, most recently watched first.
+func (c Client) GetUserWatchProgress(userID uuid.UUID) ([]WatchProgress, error) {
+	rows, err := c.db.Query(`
+	SELECT `+watchProgressColumns+`
+	FROM watch_progress
+	WHERE user_id = ?
+	ORDER BY updated_at DESC, video_id
+	`, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	progress := []WatchProgress{}
+	for rows.Next() {
+		p, err := scanWatchProgress(rows)
+		if err != nil {
+			return nil, err
+		}
+		progress = append(progress, p)
+	}
+	return progress, rows.Err()
+}
+
+// GetUnfinishedVideos returns up to limit videos the user started watching
+// but didn't finish, most recently watched first, after skipping offset of
+// them, with their progress in them.
+func (c Client) GetUnfinishedVideos(userID uuid.UUID, limit, offset int) ([]Video, []WatchProgress, error) {
+	rows, err := c.db.Query(`
+	SELECT `+prefixColumns(videoTableColumns, "v")+`, `+videoComputedColumns("v")+`, wp.position_seconds, wp.updated_at
+	FROM watch_progress wp
+	JOIN videos v ON v.id = wp.video_id
+	WHERE wp.user_id = ? AND NOT wp.finished AND wp.position_seconds >= ?
+	ORDER BY wp.updated_at DESC, wp.video_id
+	LIMIT ? OFFSET ?
+	`, userID.String(), minResumeSeconds, limit, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	progress := []WatchProgress{}
+	for rows.Next() {
+		p := WatchProgress{UserID: userID}
+		video, err := scanVideo(rows, &p.PositionSeconds, &p.UpdatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.VideoID = video.ID
+		videos = append(videos, video)
+		progress = append(progress, p)
+	}
+	return videos, progress, rows.Err()
+}
+
+// DeleteWatchProgress forgets how far the user got in the video.
+func (c Client) DeleteWatchProgress(userID, videoID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM watch_progress WHERE user_id = ? AND video_id = ?", userID.String(), videoID.String())
+	return err
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidWebAuthnSession = errors.New("webauthn session is invalid or expired")
+
+// WebAuthnCredential is a passkey registered by a user. Data holds the
+// credential as serialized by the webauthn library.
+type WebAuthnCredential struct {
+	ID         string     `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Data       []byte     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// WebAuthnSession holds the challenge of a registration or login ceremony
+// between its begin and finish steps. UserID is nil for passwordless logins,
+// where the user isn't known until the authenticator answers.
+type WebAuthnSession struct {
+	ID        string
+	UserID    *uuid.UUID
+	Data      []byte
+	ExpiresAt time.Time
+}
+
+func (c Client) CreateWebAuthnCredential(cred WebAuthnCredential) error {
+	query := `
+		INSERT INTO webauthn_credentials (id, user_id, name, data, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, cred.ID, cred.UserID.String(), cred.Name, cred.Data)
+	return err
+}
+
+func (c Client) GetWebAuthnCredentials(userID uuid.UUID) ([]WebAuthnCredential, error) {
+	query := `
+		SELECT id, user_id, name, data, created_at, last_used_at
+		FROM webauthn_credentials
+		WHERE user_id = ?
+		ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := []WebAuthnCredential{}
+	for rows.Next() {
+		var cred WebAuthnCredential
+		var uid string
+		if err := rows.Scan(&cred.ID, &uid, &cred.Name, &cred.Data, &cred.CreatedAt, &cred.LastUsedAt); err != nil {
+			return nil, err
+		}
+		cred.UserID, err = uuid.Parse(uid)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	return creds, rows.Err()
+}
+
+// UpdateWebAuthnCredential stores the credential data after a login, which
+// carries the new signature counter.
+func (c Client) UpdateWebAuthnCredential(id string, data []byte) error {
+	query := `
+		UPDATE webauthn_credentials
+		SET data = ?, last_used_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, data, id)
+	return err
+}
+
+// DeleteWebAuthnCredential deletes a credential of the user. It reports false
+// if the user has no such credential.
+func (c Client) DeleteWebAuthnCredential(userID uuid.UUID, id string) (bool, error) {
+	result, err := c.db.Exec("DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, userID.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (c Client) CreateWebAuthnSession(s WebAuthnSession) error {
+	var userID *string
+	if s.UserID != nil {
+		id := s.UserID.String()
+		userID = &id
+	}
+	query := `
+		INSERT INTO webauthn_sessions (id, user_id, data, created_at, expires_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	_, err := c.db.Exec(query, s.ID, userID, s.Data, s.ExpiresAt)
+	return err
+}
+
+// UseWebAuthnSession deletes and returns an unexpired session, so that each
+// challenge can be answered at most once.
+func (c Client) UseWebAuthnSession(id string) (WebAuthnSession, error) {
+	_, err := c.db.Exec("DELETE FROM webauthn_sessions WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return WebAuthnSession{}, err
+	}
+
+	query := `
+		DELETE FROM webauthn_sessions
+		WHERE id = ?
+		RETURNING id, user_id, data, expires_at
+	`
+	var s WebAuthnSession
+	var userID *string
+	err = c.db.QueryRow(query, id).Scan(&s.ID, &userID, &s.Data, &s.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WebAuthnSession{}, ErrInvalidWebAuthnSession
+		}
+		return WebAuthnSession{}, err
+	}
+	if userID != nil {
+		id, err := uuid.Parse(*userID)
+		if err != nil {
+			return WebAuthnSession{}, err
+		}
+		s.UserID = &id
+	}
+	return s, nil
+}
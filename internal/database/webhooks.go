@@ -0,0 +1,374 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is an endpoint a user registered to be told about events on the
+// videos it created. Secret signs every payload sent to it.
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subscribed reports whether the webhook wants to be told about event.
+func (w Webhook) Subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateWebhookParams struct {
+	UserID uuid.UUID
+	URL    string
+	Secret string
+	Events []string
+}
+
+const webhookColumns = `id, user_id, url, secret, events, active, created_at, updated_at`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var w Webhook
+	var id, userID, events string
+	err := row.Scan(&id, &userID, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt, &w.UpdatedAt)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if w.ID, err = uuid.Parse(id); err != nil {
+		return Webhook{}, err
+	}
+	if w.UserID, err = uuid.Parse(userID); err != nil {
+		return Webhook{}, err
+	}
+	w.Events = strings.Split(events, ",")
+	return w, nil
+}
+
+func (c Client) CreateWebhook(params CreateWebhookParams) (Webhook, error) {
+	id := uuid.New()
+	query := `
+		INSERT INTO webhooks (id, user_id, url, secret, events, active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, TRUE, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, id.String(), params.UserID.String(), params.URL, params.Secret, strings.Join(params.Events, ","))
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook, err := c.GetWebhook(id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return *webhook, nil
+}
+
+// GetWebhook returns the webhook with the given ID, or nil if there is none.
+func (c Client) GetWebhook(id uuid.UUID) (*Webhook, error) {
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhooks
+		WHERE id = ?
+	`
+	w, err := scanWebhook(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (c Client) GetWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM webhooks
+		WHERE user_id = ?
+		ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// UpdateWebhook saves the URL, events and active flag of the webhook.
+func (c Client) UpdateWebhook(webhook Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = ?, events = ?, active = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, webhook.URL, strings.Join(webhook.Events, ","), webhook.Active, webhook.ID.String())
+	return err
+}
+
+// DeleteWebhook removes the webhook together with its deliveries.
+func (c Client) DeleteWebhook(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM webhook_delivery_attempts
+		WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = ?)
+	`, id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id.String()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event queued for one webhook. Pending deliveries are
+// attempted once NextAttemptAt has passed.
+type WebhookDelivery struct {
+	ID            uuid.UUID             `json:"id"`
+	WebhookID     uuid.UUID             `json:"webhook_id"`
+	Event         string                `json:"event"`
+	Payload       []byte                `json:"-"`
+	Status        WebhookDeliveryStatus `json:"status"`
+	Attempts      int                   `json:"attempts"`
+	NextAttemptAt *time.Time            `json:"next_attempt_at"`
+	CreatedAt     time.Time             `json:"created_at"`
+	CompletedAt   *time.Time            `json:"completed_at"`
+}
+
+// WebhookDeliveryAttempt logs one request made for a delivery. StatusCode is
+// nil when no response was received, in which case Error says why.
+type WebhookDeliveryAttempt struct {
+	ID           int64     `json:"id"`
+	DeliveryID   uuid.UUID `json:"delivery_id"`
+	AttemptedAt  time.Time `json:"attempted_at"`
+	StatusCode   *int      `json:"status_code"`
+	Error        string    `json:"error"`
+	ResponseBody string    `json:"response_body"`
+	DurationMS   int64     `json:"duration_ms"`
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at, completed_at`
+const prefixedWebhookDeliveryColumns = `d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at, d.created_at, d.completed_at`
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var id, webhookID, payload string
+	err := row.Scan(&id, &webhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.CreatedAt, &d.CompletedAt)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if d.ID, err = uuid.Parse(id); err != nil {
+		return WebhookDelivery{}, err
+	}
+	if d.WebhookID, err = uuid.Parse(webhookID); err != nil {
+		return WebhookDelivery{}, err
+	}
+	d.Payload = []byte(payload)
+	return d, nil
+}
+
+func (c Client) queryWebhookDeliveries(query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// CreateWebhookDelivery queues payload for the webhook, to be attempted right
+// away.
+func (c Client) CreateWebhookDelivery(webhookID uuid.UUID, event string, payload []byte) (WebhookDelivery, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+		INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.db.Exec(query, id.String(), webhookID.String(), event, string(payload), WebhookDeliveryPending, now, now)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	delivery, err := c.GetWebhookDelivery(id)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	return *delivery, nil
+}
+
+// GetWebhookDelivery returns the delivery with the given ID, or nil if there
+// is none.
+func (c Client) GetWebhookDelivery(id uuid.UUID) (*WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE id = ?
+	`
+	d, err := scanWebhookDelivery(c.db.QueryRow(query, id.String()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetWebhookDeliveries returns the latest deliveries of the webhook, newest
+// first.
+func (c Client) GetWebhookDeliveries(webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY created_at DESC
+		LIMIT ?
+	`
+	return c.queryWebhookDeliveries(query, webhookID.String(), limit)
+}
+
+// GetDueWebhookDeliveries returns pending deliveries of active webhooks whose
+// next attempt is due, oldest first.
+func (c Client) GetDueWebhookDeliveries(limit int) ([]WebhookDelivery, error) {
+	query := `
+		SELECT ` + prefixedWebhookDeliveryColumns + `
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= ? AND w.active
+		ORDER BY d.next_attempt_at
+		LIMIT ?
+	`
+	return c.queryWebhookDeliveries(query, WebhookDeliveryPending, time.Now().UTC(), limit)
+}
+
+// RecordWebhookDeliveryAttempt logs an attempt and moves the delivery to
+// status. Pending deliveries are attempted again at nextAttemptAt.
+func (c Client) RecordWebhookDeliveryAttempt(attempt WebhookDeliveryAttempt, status WebhookDeliveryStatus, nextAttemptAt *time.Time) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, status_code, error, response_body, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, attempt.DeliveryID.String(), attempt.AttemptedAt.UTC(), attempt.StatusCode, attempt.Error, attempt.ResponseBody, attempt.DurationMS)
+	if err != nil {
+		return err
+	}
+
+	var completedAt *time.Time
+	if status != WebhookDeliveryPending {
+		now := time.Now().UTC()
+		completedAt = &now
+	}
+	_, err = tx.Exec(`
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = attempts + 1, next_attempt_at = ?, completed_at = ?
+		WHERE id = ?
+	`, status, nextAttemptAt, completedAt, attempt.DeliveryID.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) GetWebhookDeliveryAttempts(deliveryID uuid.UUID) ([]WebhookDeliveryAttempt, error) {
+	query := `
+		SELECT id, delivery_id, attempted_at, status_code, error, response_body, duration_ms
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = ?
+		ORDER BY id
+	`
+	rows, err := c.db.Query(query, deliveryID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []WebhookDeliveryAttempt{}
+	for rows.Next() {
+		var a WebhookDeliveryAttempt
+		var deliveryID string
+		err := rows.Scan(&a.ID, &deliveryID, &a.AttemptedAt, &a.StatusCode, &a.Error, &a.ResponseBody, &a.DurationMS)
+		if err != nil {
+			return nil, err
+		}
+		if a.DeliveryID, err = uuid.Parse(deliveryID); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// DeleteWebhookDeliveriesBefore deletes finished deliveries, and their
+// attempts, created before the given time.
+func (c Client) DeleteWebhookDeliveriesBefore(before time.Time) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM webhook_delivery_attempts
+		WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE status != ? AND created_at < ?)
+	`, WebhookDeliveryPending, before.UTC()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM webhook_deliveries
+		WHERE status != ? AND created_at < ?
+	`, WebhookDeliveryPending, before.UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails such as password reset links.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer sends emails through an SMTP server. Authentication is skipped
+// when Username is empty.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := net.JoinHostPort(m.Host, m.Port)
+	return smtp.SendMail(addr, auth, m.From, []string{msg.To}, formatMessage(m.From, msg))
+}
+
+// WriterMailer writes emails to w instead of sending them. It is meant for
+// local development: point it at a file or os.Stdout and copy links from there.
+type WriterMailer struct {
+	From string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterMailer(from string, w io.Writer) *WriterMailer {
+	return &WriterMailer{From: from, w: w}
+}
+
+func (m *WriterMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.w, "%s\n", formatMessage(m.From, msg))
+	return err
+}
+
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,170 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwksRefreshInterval limits how often an unknown key ID makes us refetch the
+// provider's keys, e.g. after a key rotation.
+const jwksRefreshInterval = time.Minute
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type keySet struct {
+	keys []parsedKey
+}
+
+type parsedKey struct {
+	kid string
+	alg string
+	key any
+}
+
+// key returns the provider's public key for kid that can verify alg.
+func (p *Provider) key(ctx context.Context, kid, alg string) (any, error) {
+	if key := p.lookupKey(kid, alg); key != nil {
+		return key, nil
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	if key := p.lookupKey(kid, alg); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key %q for %s in provider JWKS", kid, alg)
+}
+
+func (p *Provider) lookupKey(kid, alg string) any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys == nil {
+		return nil
+	}
+	for _, k := range p.keys.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		switch k.key.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(alg, "RS") {
+				return k.key
+			}
+		case *ecdsa.PublicKey:
+			if strings.HasPrefix(alg, "ES") {
+				return k.key
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Provider) refreshKeys(ctx context.Context) error {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if p.keys != nil && time.Since(p.keysFetch) < jwksRefreshInterval {
+		p.mu.Unlock()
+		return nil
+	}
+	p.mu.Unlock()
+
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, m.JWKSURI, &doc); err != nil {
+		return fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	set := &keySet{}
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// skip keys we don't understand rather than failing every login
+			continue
+		}
+		set.keys = append(set.keys, parsedKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+	}
+
+	p.mu.Lock()
+	p.keys = set
+	p.keysFetch = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, fmt.Errorf("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginGuardPolicy decides how long a key (an account or an IP) is blocked
+// after repeated failed logins. The first freeAttempts failures are free, then
+// each failure doubles the delay starting at baseDelay, and from lockoutAfter
+// failures on the key is locked for lockoutDuration. Failures are forgotten
+// after resetAfter without any new failure.
+type loginGuardPolicy struct {
+	freeAttempts    int
+	baseDelay       time.Duration
+	lockoutAfter    int
+	lockoutDuration time.Duration
+	resetAfter      time.Duration
+}
+
+var (
+	accountLoginPolicy = loginGuardPolicy{
+		freeAttempts:    3,
+		baseDelay:       time.Second,
+		lockoutAfter:    10,
+		lockoutDuration: 15 * time.Minute,
+		resetAfter:      time.Hour,
+	}
+	ipLoginPolicy = loginGuardPolicy{
+		freeAttempts:    10,
+		baseDelay:       time.Second,
+		lockoutAfter:    50,
+		lockoutDuration: 15 * time.Minute,
+		resetAfter:      time.Hour,
+	}
+)
+
+func (p loginGuardPolicy) blockFor(failures int) time.Duration {
+	if failures >= p.lockoutAfter {
+		return p.lockoutDuration
+	}
+	if failures <= p.freeAttempts {
+		return 0
+	}
+	delay := float64(p.baseDelay) * math.Pow(2, float64(failures-p.freeAttempts-1))
+	return min(time.Duration(delay), p.lockoutDuration)
+}
+
+type loginFailures struct {
+	count        int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// loginGuard tracks failed login attempts per account and per client IP in
+// memory.
+type loginGuard struct {
+	mu        sync.Mutex
+	accounts  map[string]*loginFailures
+	ips       map[string]*loginFailures
+	lastPrune time.Time
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{
+		accounts: map[string]*loginFailures{},
+		ips:      map[string]*loginFailures{},
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// retryAfter returns how long the client must wait before it may try to log in
+// to email from ip again, or 0 if it may try now.
+func (g *loginGuard) retryAfter(email, ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(0)
+	for _, f := range []*loginFailures{
+		g.lookup(g.accounts, normalizeEmail(email), accountLoginPolicy, now),
+		g.lookup(g.ips, ip, ipLoginPolicy, now),
+	} {
+		if f != nil && f.blockedUntil.After(now) {
+			wait = max(wait, f.blockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+func (g *loginGuard) recordFailure(email, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.fail(g.accounts, normalizeEmail(email), accountLoginPolicy, now)
+	g.fail(g.ips, ip, ipLoginPolicy, now)
+	g.prune(now)
+}
+
+// recordSuccess forgets the failures of the account. The IP keeps its
+// failures so that an attacker can't reset them by logging in to its own
+// account in between guesses.
+func (g *loginGuard) recordSuccess(email string) {
+	g.unlockAccount(email)
+}
+
+func (g *loginGuard) unlockAccount(email string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.accounts, normalizeEmail(email))
+}
+
+func (g *loginGuard) lookup(m map[string]*loginFailures, key string, p loginGuardPolicy, now time.Time) *loginFailures {
+	f, ok := m[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(f.lastFailure) > p.resetAfter && now.After(f.blockedUntil) {
+		delete(m, key)
+		return nil
+	}
+	return f
+}
+
+func (g *loginGuard) fail(m map[string]*loginFailures, key string, p loginGuardPolicy, now time.Time) {
+	f := g.lookup(m, key, p, now)
+	if f == nil {
+		f = &loginFailures{}
+		m[key] = f
+	}
+	f.count++
+	f.lastFailure = now
+	f.blockedUntil = now.Add(p.blockFor(f.count))
+}
+
+// prune drops expired entries, at most once a minute, so the maps don't grow
+// without bound.
+func (g *loginGuard) prune(now time.Time) {
+	if now.Sub(g.lastPrune) < time.Minute {
+		return
+	}
+	g.lastPrune = now
+	for key := range g.accounts {
+		g.lookup(g.accounts, key, accountLoginPolicy, now)
+	}
+	for key := range g.ips {
+		g.lookup(g.ips, key, ipLoginPolicy, now)
+	}
+}
+
+// parseTrustedProxies parses a comma-separated list of the IPs or CIDR
+// ranges of the proxies in front of the server.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (cfg *apiConfig) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range cfg.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client that sent r. When the server runs
+// behind a proxy, CLIENT_IP_HEADER names the header the proxy puts it in.
+// Clients can send that header themselves, so it is only read from the
+// proxies in TRUSTED_PROXIES, and only the addresses those proxies appended
+// to it are believed: the client IP is the rightmost one that isn't a
+// trusted proxy.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if cfg.clientIPHeader == "" || !cfg.isTrustedProxy(remote) {
+		return remote
+	}
+
+	// proxies append to X-Forwarded-For style headers, and may send them
+	// as several header lines
+	var hops []string
+	for _, v := range r.Header.Values(cfg.clientIPHeader) {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// anything left of a hop we can't parse could be forged
+			break
+		}
+		if !cfg.isTrustedProxy(hop) {
+			return hop
+		}
+	}
+	return remote
+}
+
+func respondTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later", nil)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		header         string
+		trustedProxies string
+		remoteAddr     string
+		forwardedFor   []string
+		want           string
+	}{
+		{
+			name:         "header not configured",
+			remoteAddr:   "203.0.113.1:1234",
+			forwardedFor: []string{"198.51.100.7"},
+			want:         "203.0.113.1",
+		},
+		{
+			name:       "no header sent",
+			header:     "X-Forwarded-For",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name:         "header ignored without trusted proxies",
+			header:       "X-Forwarded-For",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"1.2.3.4, 198.51.100.7"},
+			want:         "10.0.0.1",
+		},
+		{
+			name:           "untrusted peer",
+			header:         "X-Forwarded-For",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "203.0.113.1:1234",
+			forwardedFor:   []string{"198.51.100.7"},
+			want:           "203.0.113.1",
+		},
+		{
+			name:           "trusted proxy",
+			header:         "X-Forwarded-For",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"1.2.3.4, 198.51.100.7"},
+			want:           "198.51.100.7",
+		},
+		{
+			name:           "chain of trusted proxies",
+			header:         "X-Forwarded-For",
+			trustedProxies: "10.0.0.0/8, 192.0.2.10",
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"1.2.3.4, 198.51.100.7", "192.0.2.10, 10.0.0.2"},
+			want:           "198.51.100.7",
+		},
+		{
+			name:           "garbage left of the proxy",
+			header:         "X-Forwarded-For",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"1.2.3.4, not-an-ip, 10.0.0.2"},
+			want:           "10.0.0.1",
+		},
+		{
+			name:           "only trusted proxies",
+			header:         "X-Forwarded-For",
+			trustedProxies: "10.0.0.0/8",
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"10.0.0.3, 10.0.0.2"},
+			want:           "10.0.0.1",
+		},
+		{
+			name:           "IPv6",
+			header:         "X-Forwarded-For",
+			trustedProxies: "fd00::/8",
+			remoteAddr:     "[fd00::1]:1234",
+			forwardedFor:   []string{"2001:db8::7"},
+			want:           "2001:db8::7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxies, err := parseTrustedProxies(tt.trustedProxies)
+			if err != nil {
+				t.Fatalf("parseTrustedProxies: %v", err)
+			}
+			cfg := &apiConfig{clientIPHeader: tt.header, trustedProxies: proxies}
+			r := httptest.NewRequest("POST", "/api/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := cfg.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{input: "", want: 0},
+		{input: "10.0.0.1", want: 1},
+		{input: "10.0.0.0/8, fd00::/8", want: 2},
+		{input: "10.0.0.0/33", wantErr: true},
+		{input: "proxy.internal", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseTrustedProxies(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTrustedProxies error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d proxies, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginGuardPolicyBlockFor(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: 0},
+		{failures: 3, want: 0},
+		{failures: 4, want: time.Second},
+		{failures: 5, want: 2 * time.Second},
+		{failures: 9, want: 32 * time.Second},
+		{failures: 10, want: 15 * time.Minute},
+		{failures: 100, want: 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := accountLoginPolicy.blockFor(tt.failures); got != tt.want {
+			t.Errorf("blockFor(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
@@ -4,11 +4,18 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -20,11 +27,29 @@ type apiConfig struct {
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
+	exportsRoot      string
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
 	s3Client         *s3.Client
 	port             string
+	publicURL        string
+	mailer           mailer.Mailer
+	oidcProvider     *oidc.Provider
+	webAuthn         *webauthn.WebAuthn
+	loginGuard       *loginGuard
+	clientIPHeader   string
+	trustedProxies   []netip.Prefix
+	webhooks         *webhookDispatcher
+	videoProgress    *videoProgressBroker
+
+	defaultStorageQuota int64
+
+	// dummyPasswordHash is checked against when logging in with an unknown
+	// email, so that it takes as long as with a known one
+	dummyPasswordHash string
+
+	unverifiedRestrictions map[unverifiedAction]bool
 }
 
 type thumbnail struct {
@@ -46,6 +71,16 @@ func main() {
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	if !db.HasFTS5() {
+		log.Fatal("SQLite was built without FTS5, which video search needs: build with -tags sqlite_fts5, e.g. make build")
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCommand(db, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
@@ -67,6 +102,12 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	// data exports hold personal data, so they must not live under assetsRoot
+	exportsRoot := os.Getenv("EXPORTS_ROOT")
+	if exportsRoot == "" {
+		exportsRoot = "./exports"
+	}
+
 	s3Bucket := os.Getenv("S3_BUCKET")
 	if s3Bucket == "" {
 		log.Fatal("S3_BUCKET environment variable is not set")
@@ -87,16 +128,115 @@ func main() {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
+
+	restrictions := "upload_video"
+	if v, ok := os.LookupEnv("UNVERIFIED_RESTRICTIONS"); ok {
+		restrictions = v
+	}
+	unverifiedRestrictions, err := parseUnverifiedRestrictions(restrictions)
+	if err != nil {
+		log.Fatalf("Invalid UNVERIFIED_RESTRICTIONS: %v", err)
+	}
+
+	storageQuota := int64(defaultStorageQuota)
+	if v := os.Getenv("DEFAULT_STORAGE_QUOTA_BYTES"); v != "" {
+		storageQuota, err = parseStorageQuota(v)
+		if err != nil {
+			log.Fatalf("Invalid DEFAULT_STORAGE_QUOTA_BYTES: %v", err)
+		}
+	}
+
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	// without a proxy that appends to it, clients could send any IP in the
+	// header and dodge the per-IP login backoff
+	clientIPHeader := os.Getenv("CLIENT_IP_HEADER")
+	if clientIPHeader != "" && len(trustedProxies) == 0 {
+		log.Fatal("CLIENT_IP_HEADER is set but TRUSTED_PROXIES is empty: list the proxies that set the header")
+	}
+
+	dummyPasswordHash, err := auth.HashPassword("tubely-dummy-password")
+	if err != nil {
+		log.Fatalf("Couldn't hash dummy password: %v", err)
+	}
+
+	mail, err := newMailer()
+	if err != nil {
+		log.Fatalf("Couldn't set up mailer: %v", err)
+	}
+
 	cfg := apiConfig{
 		db:               db,
 		jwtSecret:        jwtSecret,
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
+		exportsRoot:      exportsRoot,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
 		port:             port,
+		publicURL:        strings.TrimSuffix(publicURL, "/"),
+		mailer:           mail,
+		loginGuard:       newLoginGuard(),
+		clientIPHeader:   clientIPHeader,
+		trustedProxies:   trustedProxies,
+
+		dummyPasswordHash: dummyPasswordHash,
+		// webhooks may only reach private networks when explicitly allowed,
+		// e.g. to test against a local receiver
+		webhooks:      newWebhookDispatcher(os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true"),
+		videoProgress: newVideoProgressBroker(),
+
+		defaultStorageQuota: storageQuota,
+
+		unverifiedRestrictions: unverifiedRestrictions,
+	}
+
+	// OpenID Connect login is only enabled when an issuer is configured
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		clientID := os.Getenv("OIDC_CLIENT_ID")
+		if clientID == "" {
+			log.Fatal("OIDC_CLIENT_ID environment variable is not set")
+		}
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = cfg.publicURL + "/api/oidc/callback"
+		}
+		cfg.oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+		})
+	}
+
+	// passkeys are bound to the host name users see in their browser
+	parsedPublicURL, err := url.Parse(cfg.publicURL)
+	if err != nil {
+		log.Fatalf("Invalid PUBLIC_URL: %v", err)
+	}
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = parsedPublicURL.Hostname()
+	}
+	rpOrigins := []string{cfg.publicURL}
+	if origins := os.Getenv("WEBAUTHN_RP_ORIGINS"); origins != "" {
+		rpOrigins = strings.Split(origins, ",")
+	}
+	cfg.webAuthn, err = webauthn.New(&webauthn.Config{
+		RPDisplayName: "Tubely",
+		RPID:          rpID,
+		RPOrigins:     rpOrigins,
+	})
+	if err != nil {
+		log.Fatalf("Couldn't set up WebAuthn: %v", err)
 	}
 
 	// S3 client
@@ -111,6 +251,24 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	// media uploaded before sizes were tracked would otherwise count as empty
+	err = cfg.backfillMediaSizes(context.TODO())
+	if err != nil {
+		log.Fatalf("Couldn't backfill media sizes: %v", err)
+	}
+
+	err = os.MkdirAll(cfg.exportsRoot, 0700)
+	if err != nil {
+		log.Fatalf("Couldn't create exports directory: %v", err)
+	}
+	// exports being built when the server stopped will never finish
+	err = cfg.db.FailPendingDataExports()
+	if err != nil {
+		log.Fatalf("Couldn't clean up data exports: %v", err)
+	}
+
+	go cfg.runWebhookDispatcher()
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
@@ -119,20 +277,115 @@ func main() {
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/totp", cfg.handlerLoginTOTP)
+	mux.HandleFunc("POST /api/webauthn/login/begin", cfg.handlerWebAuthnLoginBegin)
+	mux.HandleFunc("POST /api/webauthn/login/finish", cfg.handlerWebAuthnLoginFinish)
+	mux.HandleFunc("POST /api/device/code", cfg.handlerDeviceCode)
+	mux.HandleFunc("POST /api/device/token", cfg.handlerDeviceToken)
+	mux.HandleFunc("POST /api/device/approve", cfg.handlerDeviceApprove)
+	if cfg.oidcProvider != nil {
+		mux.HandleFunc("GET /api/oidc/login", cfg.handlerOIDCLogin)
+		mux.HandleFunc("GET /api/oidc/callback", cfg.handlerOIDCCallback)
+	}
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
+	mux.HandleFunc("POST /api/password_reset", cfg.handlerPasswordResetRequest)
+	mux.HandleFunc("POST /api/password_reset/confirm", cfg.handlerPasswordResetConfirm)
+
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/totp/enroll", cfg.handlerTOTPEnroll)
+	mux.HandleFunc("POST /api/totp/confirm", cfg.handlerTOTPConfirm)
+	mux.HandleFunc("DELETE /api/totp", cfg.handlerTOTPDisable)
+	mux.HandleFunc("POST /api/webauthn/register/begin", cfg.handlerWebAuthnRegisterBegin)
+	mux.HandleFunc("POST /api/webauthn/register/finish", cfg.handlerWebAuthnRegisterFinish)
+	mux.HandleFunc("GET /api/webauthn/credentials", cfg.handlerWebAuthnCredentialsList)
+	mux.HandleFunc("DELETE /api/webauthn/credentials/{credentialID}", cfg.handlerWebAuthnCredentialDelete)
+	mux.HandleFunc("GET /api/users/me", cfg.handlerUsersMeGet)
+	mux.HandleFunc("PUT /api/users/me", cfg.handlerUsersMeUpdate)
+	mux.HandleFunc("DELETE /api/users/me", cfg.handlerUsersMeDelete)
+	mux.HandleFunc("POST /api/users/me/password", cfg.handlerUsersMePassword)
+	mux.HandleFunc("POST /api/users/me/email", cfg.handlerUsersMeEmail)
+	mux.HandleFunc("GET /api/me/usage", cfg.handlerUsageGet)
+	mux.HandleFunc("GET /api/me/continue-watching", cfg.handlerContinueWatching)
+	mux.HandleFunc("POST /api/exports", cfg.handlerDataExportCreate)
+	mux.HandleFunc("GET /api/exports", cfg.handlerDataExportsList)
+	mux.HandleFunc("GET /api/exports/{exportID}", cfg.handlerDataExportGet)
+	mux.HandleFunc("GET /api/exports/{exportID}/download", cfg.handlerDataExportDownload)
+	mux.HandleFunc("GET /api/verify_email", cfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/verify_email/resend", cfg.handlerVerifyEmailResend)
+
+	mux.HandleFunc("POST /api/webhooks", cfg.handlerWebhooksCreate)
+	mux.HandleFunc("GET /api/webhooks", cfg.handlerWebhooksList)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}", cfg.handlerWebhookGet)
+	mux.HandleFunc("PUT /api/webhooks/{webhookID}", cfg.handlerWebhookUpdate)
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", cfg.handlerWebhookDelete)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", cfg.handlerWebhookDeliveriesList)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries/{deliveryID}", cfg.handlerWebhookDeliveryGet)
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", cfg.handlerWebhookDeliveryRedeliver)
+
+	mux.HandleFunc("POST /api/organizations", cfg.handlerOrganizationsCreate)
+	mux.HandleFunc("GET /api/organizations", cfg.handlerOrganizationsList)
+	mux.HandleFunc("GET /api/organizations/{orgID}", cfg.handlerOrganizationGet)
+	mux.HandleFunc("PUT /api/organizations/{orgID}", cfg.handlerOrganizationUpdate)
+	mux.HandleFunc("DELETE /api/organizations/{orgID}", cfg.handlerOrganizationDelete)
+	mux.HandleFunc("GET /api/organizations/{orgID}/members", cfg.handlerOrganizationMembersList)
+	mux.HandleFunc("POST /api/organizations/{orgID}/members", cfg.handlerOrganizationMembersAdd)
+	mux.HandleFunc("PUT /api/organizations/{orgID}/members/{userID}", cfg.handlerOrganizationMemberSetRole)
+	mux.HandleFunc("DELETE /api/organizations/{orgID}/members/{userID}", cfg.handlerOrganizationMemberRemove)
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerVideosSearch)
+	mux.HandleFunc("GET /api/videos/analytics", cfg.handlerVideosAnalytics)
+	mux.HandleFunc("GET /api/tags", cfg.handlerTagsList)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
+	mux.HandleFunc("GET /api/videos/{videoID}/review", cfg.handlerVideoReviewGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/review", cfg.handlerVideoReviewUpdate)
+	mux.HandleFunc("GET /api/videos/{videoID}/annotations", cfg.handlerAnnotationsList)
+	mux.HandleFunc("POST /api/videos/{videoID}/annotations", cfg.handlerAnnotationsCreate)
+	mux.HandleFunc("PATCH /api/videos/{videoID}/annotations/{annotationID}", cfg.handlerAnnotationUpdate)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/annotations/{annotationID}", cfg.handlerAnnotationDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/events", cfg.handlerVideoEvents)
+	mux.HandleFunc("POST /api/videos/{videoID}/events/token", cfg.handlerVideoEventsToken)
+	mux.HandleFunc("POST /api/videos/{videoID}/beacon", cfg.handlerVideoBeacon)
+	mux.HandleFunc("GET /api/videos/{videoID}/analytics", cfg.handlerVideoAnalytics)
+	mux.HandleFunc("PUT /api/videos/{videoID}/progress", cfg.handlerWatchProgressUpdate)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/progress", cfg.handlerWatchProgressDelete)
+	mux.HandleFunc("GET /api/videos/{videoID}/collaborators", cfg.handlerVideoCollaboratorsList)
+	mux.HandleFunc("POST /api/videos/{videoID}/collaborators", cfg.handlerVideoCollaboratorsInvite)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/collaborators/{userID}", cfg.handlerVideoCollaboratorRemove)
+	mux.HandleFunc("GET /api/videos/{videoID}/comments", cfg.handlerCommentsList)
+	mux.HandleFunc("POST /api/videos/{videoID}/comments", cfg.handlerCommentsCreate)
+	mux.HandleFunc("PATCH /api/videos/{videoID}/comments/{commentID}", cfg.handlerCommentUpdate)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/comments/{commentID}", cfg.handlerCommentDelete)
 	// mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
 
+	mux.HandleFunc("POST /api/playlists", cfg.handlerPlaylistsCreate)
+	mux.HandleFunc("GET /api/playlists", cfg.handlerPlaylistsList)
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.HandleFunc("PATCH /api/playlists/{playlistID}", cfg.handlerPlaylistUpdate)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.handlerPlaylistDelete)
+	mux.HandleFunc("GET /api/playlists/{playlistID}/videos", cfg.handlerPlaylistVideosList)
+	mux.HandleFunc("POST /api/playlists/{playlistID}/videos", cfg.handlerPlaylistVideosAdd)
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/videos", cfg.handlerPlaylistVideosReorder)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.handlerPlaylistVideoRemove)
+
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/users", cfg.handlerAdminUsersList)
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.handlerAdminUserSetRole)
+	mux.HandleFunc("POST /admin/users/{userID}/disable", cfg.handlerAdminUserDisable)
+	mux.HandleFunc("POST /admin/users/{userID}/enable", cfg.handlerAdminUserEnable)
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", cfg.handlerAdminUserUnlock)
+	mux.HandleFunc("PUT /admin/users/{userID}/quota", cfg.handlerAdminUserSetQuota)
+	mux.HandleFunc("DELETE /admin/users/{userID}", cfg.handlerAdminUserDelete)
+	mux.HandleFunc("GET /admin/videos/{videoID}", cfg.handlerAdminVideoGet)
+	mux.HandleFunc("GET /admin/audit_events", cfg.handlerAdminAuditEvents)
+	mux.HandleFunc("GET /admin/audit_events/export", cfg.handlerAdminAuditEventsExport)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
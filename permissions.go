@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type permission string
+
+const (
+	permViewAnyVideo   permission = "videos:view_any"
+	permDeleteAnyVideo permission = "videos:delete_any"
+	permManageUsers    permission = "users:manage"
+	permResetDatabase  permission = "database:reset"
+	permViewAuditLog   permission = "audit:view"
+)
+
+var rolePermissions = map[database.Role][]permission{
+	database.RoleUser: {},
+	database.RoleModerator: {
+		permViewAnyVideo,
+		permDeleteAnyVideo,
+	},
+	database.RoleAdmin: {
+		permViewAnyVideo,
+		permDeleteAnyVideo,
+		permManageUsers,
+		permResetDatabase,
+		permViewAuditLog,
+	},
+}
+
+var errUserDisabled = errors.New("user is disabled")
+
+func hasPermission(role database.Role, perm permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticatedUser validates the bearer JWT of the request and loads the
+// user it was issued for. Disabled users are rejected.
+func (cfg *apiConfig) authenticatedUser(r *http.Request) (*database.User, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.activeUser(userID)
+}
+
+// activeUser returns the user a validated token was issued to, or an error if
+// the user no longer exists or is disabled.
+func (cfg *apiConfig) activeUser(userID uuid.UUID) (*database.User, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	if user.DisabledAt != nil {
+		return nil, errUserDisabled
+	}
+	return user, nil
+}
+
+// requirePermission authenticates the request and checks that the caller's
+// role grants perm. On failure it writes the error response and returns false.
+func (cfg *apiConfig) requirePermission(w http.ResponseWriter, r *http.Request, perm permission) (*database.User, bool) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate user", err)
+		return nil, false
+	}
+	if !hasPermission(user.Role, perm) {
+		respondWithError(w, http.StatusForbidden, "You don't have permission to do this", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+// unverifiedAction is something an account can be barred from doing until it
+// has verified its email. The set is configured with UNVERIFIED_RESTRICTIONS.
+type unverifiedAction string
+
+const (
+	actionCreateVideo     unverifiedAction = "create_video"
+	actionUploadVideo     unverifiedAction = "upload_video"
+	actionUploadThumbnail unverifiedAction = "upload_thumbnail"
+)
+
+func parseUnverifiedRestrictions(s string) (map[unverifiedAction]bool, error) {
+	restrictions := map[unverifiedAction]bool{}
+	for _, field := range strings.Split(s, ",") {
+		action := unverifiedAction(strings.TrimSpace(field))
+		switch action {
+		case "":
+			continue
+		case actionCreateVideo, actionUploadVideo, actionUploadThumbnail:
+			restrictions[action] = true
+		default:
+			return nil, fmt.Errorf("unknown restricted action %q", action)
+		}
+	}
+	return restrictions, nil
+}
+
+// requireVerified checks that userID may perform action given its email
+// verification state. On failure it writes the error response and returns false.
+func (cfg *apiConfig) requireVerified(w http.ResponseWriter, userID uuid.UUID, action unverifiedAction) bool {
+	if !cfg.unverifiedRestrictions[action] {
+		return true
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", nil)
+		return false
+	}
+	if user.VerifiedAt == nil {
+		respondWithError(w, http.StatusForbidden, "Verify your email address first", nil)
+		return false
+	}
+	return true
+}
+
+// videoAction is something a user can do with a video.
+type videoAction string
+
+const (
+	videoActionView   videoAction = "view"
+	videoActionEdit   videoAction = "edit"
+	videoActionDelete videoAction = "delete"
+	// videoActionShare is managing the collaborators of the video
+	videoActionShare videoAction = "share"
+)
+
+// videoActionRoles is the least organization role needed for each action on
+// videos owned by an organization.
+var videoActionRoles = map[videoAction]database.OrganizationRole{
+	videoActionView:   database.OrganizationRoleViewer,
+	videoActionEdit:   database.OrganizationRoleEditor,
+	videoActionDelete: database.OrganizationRoleEditor,
+	videoActionShare:  database.OrganizationRoleEditor,
+}
+
+// videoActionCollaboratorRoles is the least collaborator role needed for each
+// action. Collaborators can't delete or share the video.
+var videoActionCollaboratorRoles = map[videoAction]database.CollaboratorRole{
+	videoActionView: database.CollaboratorRoleViewer,
+	videoActionEdit: database.CollaboratorRoleEditor,
+}
+
+// videoActionPermissions lets staff act on videos they don't own.
+var videoActionPermissions = map[videoAction]permission{
+	videoActionView:   permViewAnyVideo,
+	videoActionDelete: permDeleteAnyVideo,
+}
+
+// canAccessVideo reports whether user may perform action on video. Personal
+// videos are accessible to their owner, organization videos to members whose
+// role allows the action, and both to collaborators the video is shared with.
+func (cfg *apiConfig) canAccessVideo(user database.User, video database.Video, action videoAction) (bool, error) {
+	if perm, ok := videoActionPermissions[action]; ok && hasPermission(user.Role, perm) {
+		return true, nil
+	}
+
+	if video.OrganizationID == nil {
+		if video.UserID == user.ID {
+			return true, nil
+		}
+	} else {
+		member, err := cfg.db.GetOrganizationMember(*video.OrganizationID, user.ID)
+		if err != nil {
+			return false, err
+		}
+		if member != nil && member.Role.AtLeast(videoActionRoles[action]) {
+			return true, nil
+		}
+	}
+
+	minRole, ok := videoActionCollaboratorRoles[action]
+	if !ok {
+		return false, nil
+	}
+	collaborator, err := cfg.db.GetVideoCollaborator(video.ID, user.ID)
+	if err != nil {
+		return false, err
+	}
+	return collaborator != nil && collaborator.Role.AtLeast(minRole), nil
+}
+
+// requireVideoAccess checks that userID may perform action on video. On
+// failure it writes the error response and returns false.
+func (cfg *apiConfig) requireVideoAccess(w http.ResponseWriter, userID uuid.UUID, video database.Video, action videoAction) bool {
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return false
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return false
+	}
+	if user == nil || user.DisabledAt != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", nil)
+		return false
+	}
+	ok, err := cfg.canAccessVideo(*user, video, action)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check permissions", err)
+		return false
+	}
+	if !ok {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("You can't %s this video", action), nil)
+		return false
+	}
+	return true
+}
+
+// requireOrganizationRole checks that userID is a member of the organization
+// with at least the given role. Non-members get a 404 so organizations can't
+// be discovered. On failure it writes the error response and returns false.
+func (cfg *apiConfig) requireOrganizationRole(w http.ResponseWriter, userID, organizationID uuid.UUID, min database.OrganizationRole) (*database.OrganizationMember, bool) {
+	member, err := cfg.db.GetOrganizationMember(organizationID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get membership", err)
+		return nil, false
+	}
+	if member == nil {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return nil, false
+	}
+	if !member.Role.AtLeast(min) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Only organization members with the %s role or higher can do this", min), nil)
+		return nil, false
+	}
+	return member, true
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// defaultStorageQuota applies to users without a quota of their own.
+const defaultStorageQuota = 10 << 30 // 10GB
+
+// parseStorageQuota parses a quota in bytes, where 0 means unlimited.
+func parseStorageQuota(s string) (int64, error) {
+	quota, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if quota < 0 {
+		return 0, fmt.Errorf("quota can't be negative")
+	}
+	return quota, nil
+}
+
+// storageQuota returns the user's storage quota in bytes, or 0 if it is
+// unlimited.
+func (cfg *apiConfig) storageQuota(user database.User) int64 {
+	if user.StorageQuotaBytes != nil {
+		return *user.StorageQuotaBytes
+	}
+	return cfg.defaultStorageQuota
+}
+
+// requireStorageQuota checks, before the body is read, that the creator of
+// video has room for an upload that replaces replacedBytes of stored media.
+// It returns the creator's quota, which the size of the stored upload must be
+// recorded against, and how many bytes the upload may have at most. This is
+// only a first check: concurrent uploads can all pass it, so the quota is
+// enforced again when the size is recorded. On failure it writes the error
+// response and returns false.
+func (cfg *apiConfig) requireStorageQuota(w http.ResponseWriter, r *http.Request, video database.Video, replacedBytes int64) (quota, allowance int64, ok bool) {
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return 0, 0, false
+	}
+	if owner == nil {
+		respondWithError(w, http.StatusNotFound, "Video owner not found", nil)
+		return 0, 0, false
+	}
+
+	quota = cfg.storageQuota(*owner)
+	if quota == 0 {
+		return 0, math.MaxInt64, true
+	}
+	usage, err := cfg.db.GetUserStorageUsage(owner.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return 0, 0, false
+	}
+
+	remaining := max(quota-usage.TotalBytes()+replacedBytes, 0)
+	// the request body also holds the multipart headers, so this errs on the
+	// side of accepting uploads that barely fit
+	if r.ContentLength > remaining {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Storage quota exceeded", nil)
+		return 0, 0, false
+	}
+	return quota, remaining, true
+}
+
+type storageUsageResponse struct {
+	database.StorageUsage
+	UsedBytes int64 `json:"used_bytes"`
+	// QuotaBytes is nil when storage is unlimited
+	QuotaBytes *int64 `json:"quota_bytes"`
+}
+
+func (cfg *apiConfig) storageUsageResponse(user database.User) (storageUsageResponse, error) {
+	usage, err := cfg.db.GetUserStorageUsage(user.ID)
+	if err != nil {
+		return storageUsageResponse{}, err
+	}
+	resp := storageUsageResponse{
+		StorageUsage: usage,
+		UsedBytes:    usage.TotalBytes(),
+	}
+	if quota := cfg.storageQuota(user); quota != 0 {
+		resp.QuotaBytes = &quota
+	}
+	return resp, nil
+}
+
+func (cfg *apiConfig) handlerUsageGet(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticatedUser(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	resp, err := cfg.storageUsageResponse(*user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAdminUserSetQuota sets a user's storage quota in bytes. 0 means
+// unlimited, and null makes the user fall back to the default quota.
+func (cfg *apiConfig) handlerAdminUserSetQuota(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		QuotaBytes *int64 `json:"quota_bytes"`
+	}
+
+	if _, ok := cfg.requirePermission(w, r, permManageUsers); !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.QuotaBytes != nil && *params.QuotaBytes < 0 {
+		respondWithError(w, http.StatusBadRequest, "Quota can't be negative", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.db.SetUserStorageQuota(userID, params.QuotaBytes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+	user.StorageQuotaBytes = params.QuotaBytes
+
+	resp, err := cfg.storageUsageResponse(*user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -1,6 +1,10 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
 
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 	if cfg.platform != "dev" {
@@ -8,12 +12,20 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Reset is only allowed in dev environment."))
 		return
 	}
+	admin, ok := cfg.requirePermission(w, r, permResetDatabase)
+	if !ok {
+		return
+	}
 
-	err := cfg.db.Reset()
+	err := cfg.db.Reset(admin.ID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't reset database", err)
 		return
 	}
+	cfg.audit(r, database.AuditEvent{
+		Action:  auditDatabaseReset,
+		ActorID: &admin.ID,
+	})
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Database reset to initial state"))
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Thumbnails live on the local filesystem under assetsRoot and videos in the
+// S3 bucket, served through the CloudFront distribution. These helpers map the
+// URLs stored on database.Video back to those locations.
+
+// videoS3Key returns the S3 key of a video URL, or false if the URL doesn't
+// point into our distribution.
+func (cfg *apiConfig) videoS3Key(videoURL string) (string, bool) {
+	key, ok := strings.CutPrefix(videoURL, cfg.s3CfDistribution+"/")
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+// thumbnailPath returns the local path of a thumbnail URL, or false if it
+// isn't a file inside assetsRoot.
+func (cfg *apiConfig) thumbnailPath(thumbnailURL string) (string, bool) {
+	rel, err := filepath.Rel(cfg.assetsRoot, thumbnailURL)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.Join(cfg.assetsRoot, rel), true
+}
+
+// deleteVideoMedia removes the stored thumbnail and video file of a video.
+// Missing files are not an error.
+func (cfg *apiConfig) deleteVideoMedia(ctx context.Context, video database.Video) error {
+	var errs []error
+
+	if video.ThumbnailURL != nil {
+		if path, ok := cfg.thumbnailPath(*video.ThumbnailURL); ok {
+			err := os.Remove(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("couldn't delete thumbnail: %w", err))
+			}
+		}
+	}
+
+	if video.VideoURL != nil {
+		if key, ok := cfg.videoS3Key(*video.VideoURL); ok {
+			_, err := cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(cfg.s3Bucket),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("couldn't delete video from S3: %w", err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// backfillMediaSizes records the sizes of media stored before sizes were
+// tracked, so that it counts against its creators' quotas. Thumbnails are
+// looked up on disk and videos in S3. Media whose size can't be found is
+// logged and looked up again on the next start.
+func (cfg *apiConfig) backfillMediaSizes(ctx context.Context) error {
+	videos, err := cfg.db.GetVideosWithoutMediaSizes()
+	if err != nil {
+		return err
+	}
+	for _, video := range videos {
+		videoBytes, thumbnailBytes := video.VideoSizeBytes, video.ThumbnailSizeBytes
+
+		if video.ThumbnailURL != nil && thumbnailBytes == 0 {
+			if path, ok := cfg.thumbnailPath(*video.ThumbnailURL); ok {
+				info, err := os.Stat(path)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to get thumbnail size of video %s: %s\n", video.ID, err)
+				} else {
+					thumbnailBytes = info.Size()
+				}
+			}
+		}
+
+		if video.VideoURL != nil && videoBytes == 0 {
+			if key, ok := cfg.videoS3Key(*video.VideoURL); ok {
+				head, err := cfg.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+					Bucket: aws.String(cfg.s3Bucket),
+					Key:    aws.String(key),
+				})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to get video size of video %s: %s\n", video.ID, err)
+				} else {
+					videoBytes = aws.ToInt64(head.ContentLength)
+				}
+			}
+		}
+
+		if videoBytes == video.VideoSizeBytes && thumbnailBytes == video.ThumbnailSizeBytes {
+			continue
+		}
+		if err := cfg.db.SetVideoMediaSizes(video.ID, videoBytes, thumbnailBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errSoleOrganizationOwner = errors.New("user is the only owner of an organization")
+
+// deleteUserData deletes the user with its refresh tokens and personal
+// videos, and the media of those videos and its data export archives. Videos
+// owned by organizations are kept and passed on to another owner of the
+// organization, so users who are the only owner of an organization must hand
+// it over first. The account is deleted from the database first, so a
+// failure there leaves it whole; media and archives are deleted after, and
+// those that can't be are only logged.
+func (cfg *apiConfig) deleteUserData(ctx context.Context, user database.User) error {
+	orgs, err := cfg.db.GetSoleOwnedOrganizations(user.ID)
+	if err != nil {
+		return err
+	}
+	if len(orgs) > 0 {
+		return errSoleOrganizationOwner
+	}
+
+	videos, err := cfg.db.GetPersonalVideos(user.ID)
+	if err != nil {
+		return err
+	}
+	exports, err := cfg.db.GetDataExports(user.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.db.DeleteUser(user.ID); err != nil {
+		return err
+	}
+	cfg.loginGuard.unlockAccount(user.Email)
+
+	for _, video := range videos {
+		if err := cfg.deleteVideoMedia(ctx, video); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to delete media of video %s: %s\n", video.ID, err)
+		}
+	}
+	for _, export := range exports {
+		if export.FilePath == nil {
+			continue
+		}
+		err := os.Remove(*export.FilePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "failed to delete data export %s: %s\n", export.ID, err)
+		}
+	}
+	return nil
+}
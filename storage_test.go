@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestBackfillMediaSizes(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.assetsRoot = t.TempDir()
+	user := newTestUser(t, cfg)
+
+	stored := filepath.Join(cfg.assetsRoot, "stored.png")
+	if err := os.WriteFile(stored, make([]byte, 1234), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	thumbnails := map[string]string{
+		"stored":  stored,
+		"missing": filepath.Join(cfg.assetsRoot, "missing.png"),
+		"outside": "/etc/passwd",
+	}
+	videos := map[string]database.Video{}
+	for name, path := range thumbnails {
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: name, UserID: user.ID})
+		if err != nil {
+			t.Fatalf("CreateVideo: %v", err)
+		}
+		// stored before sizes were tracked
+		video.ThumbnailURL = &path
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			t.Fatalf("UpdateVideo: %v", err)
+		}
+		videos[name] = video
+	}
+
+	if err := cfg.backfillMediaSizes(context.Background()); err != nil {
+		t.Fatalf("backfillMediaSizes: %v", err)
+	}
+	want := map[string]int64{"stored": 1234, "missing": 0, "outside": 0}
+	for name, video := range videos {
+		got, err := cfg.db.GetVideo(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if got.ThumbnailSizeBytes != want[name] {
+			t.Errorf("%s: thumbnail size = %d, want %d", name, got.ThumbnailSizeBytes, want[name])
+		}
+	}
+}
+
+func TestDeleteUserData(t *testing.T) {
+	tests := []struct {
+		name        string
+		deleteFails bool
+	}{
+		{name: "deleted"},
+		{name: "database delete fails", deleteFails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dbPath := filepath.Join(dir, "test.db")
+			db, err := database.NewClient(dbPath)
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+			cfg := &apiConfig{db: db, assetsRoot: dir, loginGuard: newLoginGuard()}
+			user := newTestUser(t, cfg)
+
+			thumbnail := filepath.Join(dir, "thumbnail.png")
+			archive := filepath.Join(dir, "export.zip")
+			for _, path := range []string{thumbnail, archive} {
+				if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			}
+			video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "video", UserID: user.ID})
+			if err != nil {
+				t.Fatalf("CreateVideo: %v", err)
+			}
+			if _, err := cfg.db.UpdateVideoThumbnail(video.ID, thumbnail, 4, 0); err != nil {
+				t.Fatalf("UpdateVideoThumbnail: %v", err)
+			}
+			export, err := cfg.db.CreateDataExport(user.ID)
+			if err != nil {
+				t.Fatalf("CreateDataExport: %v", err)
+			}
+			if err := cfg.db.CompleteDataExport(export.ID, archive, 4, time.Now().Add(time.Hour)); err != nil {
+				t.Fatalf("CompleteDataExport: %v", err)
+			}
+
+			if tt.deleteFails {
+				raw, err := sql.Open("sqlite3", dbPath)
+				if err != nil {
+					t.Fatalf("opening database: %v", err)
+				}
+				defer raw.Close()
+				_, err = raw.Exec(`
+				CREATE TRIGGER fail_user_delete BEFORE DELETE ON users
+				BEGIN SELECT RAISE(ABORT, 'user delete failed'); END
+				`)
+				if err != nil {
+					t.Fatalf("creating trigger: %v", err)
+				}
+			}
+
+			err = cfg.deleteUserData(context.Background(), user)
+			if (err != nil) != tt.deleteFails {
+				t.Fatalf("deleteUserData error = %v, want failure %v", err, tt.deleteFails)
+			}
+			got, err := cfg.db.GetUser(user.ID)
+			if err != nil {
+				t.Fatalf("GetUser: %v", err)
+			}
+			if (got != nil) != tt.deleteFails {
+				t.Errorf("user kept = %v, want %v", got != nil, tt.deleteFails)
+			}
+			for _, path := range []string{thumbnail, archive} {
+				if _, err := os.Stat(path); (err == nil) != tt.deleteFails {
+					t.Errorf("%s kept = %v, want %v", filepath.Base(path), err == nil, tt.deleteFails)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Stages of an upload, in the order they are published. An upload ends with
+// either done or failed.
+const (
+	progressUploadReceived = "upload-received"
+	progressProbing        = "probing"
+	progressRemuxing       = "remuxing"
+	progressStoring        = "storing"
+	progressDone           = "done"
+	progressFailed         = "failed"
+)
+
+// videoProgressEvent reports how far the processing of an upload got.
+// Percent is only set while remuxing, Error only when it failed and Video
+// only when it is done.
+type videoProgressEvent struct {
+	Stage   string          `json:"stage"`
+	Percent *float64        `json:"percent,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Video   *database.Video `json:"video,omitempty"`
+}
+
+func (e videoProgressEvent) terminal() bool {
+	return e.Stage == progressDone || e.Stage == progressFailed
+}
+
+const (
+	// videoProgressBuffer is how many events a subscriber may fall behind
+	// before the oldest ones are dropped
+	videoProgressBuffer = 16
+	// videoProgressRetention is how long the outcome of an upload is kept for
+	// subscribers that connect after it finished
+	videoProgressRetention = time.Minute
+)
+
+type latestVideoProgress struct {
+	event videoProgressEvent
+	seq   uint64
+}
+
+// videoProgressBroker is an in-process pub/sub of upload progress, keyed by
+// video. It remembers the latest event of each upload, so subscribers that
+// connect midway learn the current stage right away.
+type videoProgressBroker struct {
+	mu          sync.Mutex
+	seq         uint64
+	latest      map[uuid.UUID]latestVideoProgress
+	subscribers map[uuid.UUID]map[chan videoProgressEvent]struct{}
+}
+
+func newVideoProgressBroker() *videoProgressBroker {
+	return &videoProgressBroker{
+		latest:      map[uuid.UUID]latestVideoProgress{},
+		subscribers: map[uuid.UUID]map[chan videoProgressEvent]struct{}{},
+	}
+}
+
+// publish sends event to every subscriber of the video. It never blocks: a
+// subscriber that fell behind loses its oldest buffered event instead.
+func (b *videoProgressBroker) publish(videoID uuid.UUID, event videoProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	seq := b.seq
+	b.latest[videoID] = latestVideoProgress{event: event, seq: seq}
+	if event.terminal() {
+		time.AfterFunc(videoProgressRetention, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			// a newer upload may have started in the meantime
+			if b.latest[videoID].seq == seq {
+				delete(b.latest, videoID)
+			}
+		})
+	}
+
+	for ch := range b.subscribers[videoID] {
+		select {
+		case ch <- event:
+			continue
+		default:
+		}
+		// publish is the only sender and holds the lock, so after dropping
+		// the oldest event there is room for this one
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- event
+	}
+}
+
+// subscribe returns a channel of the video's progress events, starting with
+// the latest one if an upload is in progress or just finished. Call the
+// returned function to unsubscribe.
+func (b *videoProgressBroker) subscribe(videoID uuid.UUID) (<-chan videoProgressEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan videoProgressEvent, videoProgressBuffer)
+	if latest, ok := b.latest[videoID]; ok {
+		ch <- latest.event
+	}
+	if b.subscribers[videoID] == nil {
+		b.subscribers[videoID] = map[chan videoProgressEvent]struct{}{}
+	}
+	b.subscribers[videoID][ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[videoID], ch)
+		if len(b.subscribers[videoID]) == 0 {
+			delete(b.subscribers, videoID)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestVideoProgressBroker(t *testing.T) {
+	b := newVideoProgressBroker()
+	videoID, otherID := uuid.New(), uuid.New()
+
+	receive := func(ch <-chan videoProgressEvent) (string, bool) {
+		select {
+		case event := <-ch:
+			return event.Stage, true
+		default:
+			return "", false
+		}
+	}
+
+	first, unsubscribeFirst := b.subscribe(videoID)
+	other, unsubscribeOther := b.subscribe(otherID)
+	defer unsubscribeOther()
+	if stage, ok := receive(first); ok {
+		t.Fatalf("new subscriber got %s before anything was published", stage)
+	}
+
+	b.publish(videoID, videoProgressEvent{Stage: progressUploadReceived})
+	b.publish(videoID, videoProgressEvent{Stage: progressProbing})
+	for _, want := range []string{progressUploadReceived, progressProbing} {
+		if stage, _ := receive(first); stage != want {
+			t.Errorf("got %q, want %q", stage, want)
+		}
+	}
+	if stage, ok := receive(other); ok {
+		t.Errorf("subscriber of another video got %s", stage)
+	}
+
+	// joining midway starts with the latest event
+	second, unsubscribeSecond := b.subscribe(videoID)
+	defer unsubscribeSecond()
+	if stage, _ := receive(second); stage != progressProbing {
+		t.Errorf("late subscriber got %q, want %q", stage, progressProbing)
+	}
+
+	unsubscribeFirst()
+	b.publish(videoID, videoProgressEvent{Stage: progressDone})
+	if stage, ok := receive(first); ok {
+		t.Errorf("unsubscribed channel got %s", stage)
+	}
+	if stage, _ := receive(second); stage != progressDone {
+		t.Errorf("got %q, want %q", stage, progressDone)
+	}
+	if len(b.subscribers[videoID]) != 1 {
+		t.Errorf("%d subscribers left, want 1", len(b.subscribers[videoID]))
+	}
+
+	// subscribers that fall behind lose the oldest events
+	for range videoProgressBuffer + 1 {
+		b.publish(otherID, videoProgressEvent{Stage: progressRemuxing})
+	}
+	b.publish(otherID, videoProgressEvent{Stage: progressFailed})
+	var last string
+	for range videoProgressBuffer {
+		last, _ = receive(other)
+	}
+	if last != progressFailed {
+		t.Errorf("last buffered event = %q, want %q", last, progressFailed)
+	}
+	if stage, ok := receive(other); ok {
+		t.Errorf("more than %d events buffered: got %s", videoProgressBuffer, stage)
+	}
+}
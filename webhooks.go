@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Events sent to webhooks. Each one carries the video it is about.
+const (
+	webhookVideoCreated     = "video.created"
+	webhookVideoUploaded    = "video.uploaded"
+	webhookVideoProcessed   = "video.processed"
+	webhookVideoDeleted     = "video.deleted"
+	webhookThumbnailUpdated = "thumbnail.updated"
+)
+
+var webhookEvents = []string{
+	webhookVideoCreated,
+	webhookVideoUploaded,
+	webhookVideoProcessed,
+	webhookVideoDeleted,
+	webhookThumbnailUpdated,
+}
+
+const (
+	// webhookMaxAttempts is how many times a delivery is tried before it is
+	// marked as failed. Retries back off exponentially from webhookRetryDelay,
+	// so the last one happens about two hours after the first.
+	webhookMaxAttempts = 8
+	webhookRetryDelay  = time.Minute
+	// webhookPollInterval is how often the queue is checked for retries that
+	// came due. New events wake the dispatcher right away.
+	webhookPollInterval = 5 * time.Second
+	webhookTimeout      = 10 * time.Second
+	webhookBatchSize    = 10
+	// webhookRetention is how long finished deliveries are kept in the log
+	webhookRetention = 30 * 24 * time.Hour
+	// webhookMaxLoggedResponse caps how much of a response body is logged
+	webhookMaxLoggedResponse = 1 << 10
+)
+
+// webhookDispatcher sends queued deliveries. The queue itself lives in the
+// database, so deliveries survive restarts.
+type webhookDispatcher struct {
+	client *http.Client
+	wake   chan struct{}
+}
+
+// nonPublicPrefixes are the special-purpose ranges that IsGlobalUnicast and
+// IsPrivate don't already exclude, from the IANA IPv4 and IPv6
+// special-purpose address registries.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, could map to anything
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4, could map to anything
+	netip.MustParsePrefix("3fff::/20"),       // documentation
+	netip.MustParsePrefix("5f00::/16"),       // segment routing
+}
+
+// isPublicIP reports whether ip is a unicast address on the public internet.
+func isPublicIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebhookDispatcher returns a dispatcher whose client refuses to connect
+// to anything but public addresses unless allowPrivate is set, so that
+// webhooks can't be used to reach internal services. The check runs on the
+// address actually dialed, which is why the client never uses a proxy.
+func newWebhookDispatcher(allowPrivate bool) *webhookDispatcher {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicIP(addrPort.Addr()) {
+				return fmt.Errorf("webhook address %s is not public", addrPort.Addr())
+			}
+			return nil
+		}
+	}
+	return &webhookDispatcher{
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			// a redirect could point anywhere, so it counts as a failed attempt
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// notify wakes the dispatcher up to send new deliveries.
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+type webhookPayload struct {
+	ID        uuid.UUID `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      struct {
+		Video database.Video `json:"video"`
+	} `json:"data"`
+}
+
+// emitWebhookEvent queues event for every active webhook of the video's
+// creator that subscribed to it. Errors are only logged, so that a failing
+// queue doesn't break the action itself.
+func (cfg *apiConfig) emitWebhookEvent(event string, video database.Video) {
+	webhooks, err := cfg.db.GetWebhooks(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't get webhooks for %s: %s", event, err)
+		return
+	}
+
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Active || !webhook.Subscribed(event) {
+			continue
+		}
+		if payload == nil {
+			p := webhookPayload{
+				ID:        uuid.New(),
+				Event:     event,
+				CreatedAt: time.Now().UTC(),
+			}
+			p.Data.Video = video
+			payload, err = json.Marshal(p)
+			if err != nil {
+				log.Printf("Couldn't encode %s payload: %s", event, err)
+				return
+			}
+		}
+		if _, err := cfg.db.CreateWebhookDelivery(webhook.ID, event, payload); err != nil {
+			log.Printf("Couldn't queue %s for webhook %s: %s", event, webhook.ID, err)
+		}
+	}
+	if payload != nil {
+		cfg.webhooks.notify()
+	}
+}
+
+// runWebhookDispatcher sends due deliveries until the process exits.
+func (cfg *apiConfig) runWebhookDispatcher() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		if time.Since(lastPrune) > time.Hour {
+			if err := cfg.db.DeleteWebhookDeliveriesBefore(time.Now().Add(-webhookRetention)); err != nil {
+				log.Printf("Couldn't delete old webhook deliveries: %s", err)
+			}
+			lastPrune = time.Now()
+		}
+		cfg.sendDueWebhookDeliveries()
+
+		select {
+		case <-ticker.C:
+		case <-cfg.webhooks.wake:
+		}
+	}
+}
+
+// sendDueWebhookDeliveries attempts due deliveries in batches until none are
+// left. The deliveries of a batch are sent concurrently.
+func (cfg *apiConfig) sendDueWebhookDeliveries() {
+	for {
+		deliveries, err := cfg.db.GetDueWebhookDeliveries(webhookBatchSize)
+		if err != nil {
+			log.Printf("Couldn't get due webhook deliveries: %s", err)
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cfg.attemptWebhookDelivery(delivery)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// attemptWebhookDelivery sends the delivery once and records the outcome,
+// scheduling a retry if it failed and attempts are left.
+func (cfg *apiConfig) attemptWebhookDelivery(delivery database.WebhookDelivery) {
+	webhook, err := cfg.db.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		log.Printf("Couldn't get webhook %s: %s", delivery.WebhookID, err)
+		return
+	}
+	if webhook == nil {
+		return
+	}
+
+	attempt := cfg.sendWebhook(*webhook, delivery)
+	attempt.DurationMS = time.Since(attempt.AttemptedAt).Milliseconds()
+
+	status := database.WebhookDeliveryPending
+	var nextAttemptAt *time.Time
+	switch {
+	case attempt.StatusCode != nil && *attempt.StatusCode >= 200 && *attempt.StatusCode < 300:
+		status = database.WebhookDeliverySucceeded
+	case delivery.Attempts+1 >= webhookMaxAttempts:
+		status = database.WebhookDeliveryFailed
+	default:
+		next := time.Now().UTC().Add(webhookRetryDelay << delivery.Attempts)
+		nextAttemptAt = &next
+	}
+
+	if err := cfg.db.RecordWebhookDeliveryAttempt(attempt, status, nextAttemptAt); err != nil {
+		log.Printf("Couldn't record attempt of webhook delivery %s: %s", delivery.ID, err)
+	}
+}
+
+// sendWebhook posts the delivery's payload to the webhook. The request is
+// signed with the webhook's secret: the X-Tubely-Signature header holds the
+// Unix timestamp and the hex HMAC-SHA256 of "<timestamp>.<body>", as
+// "t=<timestamp>,v1=<signature>".
+func (cfg *apiConfig) sendWebhook(webhook database.Webhook, delivery database.WebhookDelivery) database.WebhookDeliveryAttempt {
+	start := time.Now()
+	attempt := database.WebhookDeliveryAttempt{
+		DeliveryID:  delivery.ID,
+		AttemptedAt: start,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tubely-Webhooks")
+	req.Header.Set("X-Tubely-Event", delivery.Event)
+	req.Header.Set("X-Tubely-Delivery", delivery.ID.String())
+	req.Header.Set("X-Tubely-Signature", "t="+timestamp+",v1="+signWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := cfg.webhooks.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	attempt.StatusCode = &resp.StatusCode
+	body, err := io.ReadAll(io.LimitReader(resp.Body, webhookMaxLoggedResponse))
+	if err != nil {
+		attempt.Error = fmt.Sprintf("couldn't read response: %s", err)
+	}
+	attempt.ResponseBody = string(body)
+	if attempt.Error == "" && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		attempt.Error = resp.Status
+	}
+	return attempt
+}
+
+func signWebhookPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
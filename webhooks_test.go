@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "93.184.216.34", want: true},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{ip: "127.0.0.1", want: false},
+		{ip: "10.1.2.3", want: false},
+		{ip: "172.16.0.1", want: false},
+		{ip: "192.168.1.1", want: false},
+		{ip: "169.254.169.254", want: false},
+		{ip: "100.64.0.1", want: false},
+		{ip: "100.127.255.254", want: false},
+		{ip: "0.0.0.0", want: false},
+		{ip: "0.1.2.3", want: false},
+		{ip: "192.0.0.8", want: false},
+		{ip: "198.18.0.1", want: false},
+		{ip: "240.0.0.1", want: false},
+		{ip: "255.255.255.255", want: false},
+		{ip: "224.0.0.1", want: false},
+		{ip: "::1", want: false},
+		{ip: "::", want: false},
+		{ip: "fd00::1", want: false},
+		{ip: "fe80::1", want: false},
+		{ip: "::ffff:127.0.0.1", want: false},
+		{ip: "::ffff:10.0.0.1", want: false},
+		{ip: "64:ff9b::a00:1", want: false},
+		{ip: "2002:a00:1::", want: false},
+		{ip: "2001:db8::1", want: false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookDispatcherRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// a proxy must not be used to get around the check
+	t.Setenv("HTTP_PROXY", server.URL)
+	t.Setenv("NO_PROXY", "")
+
+	tests := []struct {
+		name         string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{name: "refused", wantErr: true},
+		{name: "allowed", allowPrivate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := newWebhookDispatcher(tt.allowPrivate).client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}